@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/jaredallard/balance/pkg/account"
+	log "github.com/sirupsen/logrus"
+)
+
+// runBackup runs `balance backup`, which writes every user, account, and
+// transaction to an archive
+func runBackup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	format := fs.String("format", string(account.BackupNDJSON), "format to write, json or ndjson")
+	output := fs.String("o", "", "file to write to, defaults to stdout")
+	fs.Parse(args)
+
+	f := account.BackupFormat(*format)
+	if f != account.BackupJSON && f != account.BackupNDJSON {
+		log.Fatalf("unknown format '%s', expected json or ndjson", *format)
+	}
+
+	db := connect()
+	defer db.Close()
+
+	a := account.NewClient(db)
+	ctx := context.Background()
+
+	// backups of inconsistent data restore as inconsistent data, so warn about it now
+	problems, err := a.CheckIntegrity(ctx)
+	if err != nil {
+		log.Fatalf("failed to check integrity: %v", err)
+	}
+	for _, p := range problems {
+		log.Warnf("integrity check failed: %s", p)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("failed to create output file: %v", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := a.Backup(ctx, w, f); err != nil {
+		log.Fatalf("failed to backup: %v", err)
+	}
+}
+
+// runRestore runs `balance restore`, which loads an archive into an empty database
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "don't restore anything if the backup fails its integrity checks")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: balance restore [-strict] BACKUP\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("failed to open backup: %v", err)
+	}
+	defer f.Close()
+
+	db := connect()
+	defer db.Close()
+
+	a := account.NewClient(db)
+	ctx := context.Background()
+	if err := a.Migrate(ctx); err != nil {
+		log.Fatalf("failed to migrate database: %v", err)
+	}
+
+	report, err := a.Restore(ctx, f, *strict)
+	if report != nil {
+		log.Infof("backup created at %s, schema version %d", report.Header.CreatedAt, report.Header.SchemaVersion)
+
+		names := make([]string, 0, len(report.Restored))
+		for name := range report.Restored {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			log.Infof("restored %d %s records", report.Restored[name], name)
+		}
+
+		for _, p := range report.Problems {
+			log.Warnf("integrity check failed: %s", p)
+		}
+	}
+	if err != nil {
+		log.Fatalf("failed to restore: %v", err)
+	}
+
+	if len(report.Problems) != 0 {
+		log.Fatalf("restored, but %d integrity checks failed", len(report.Problems))
+	}
+}
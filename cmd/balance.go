@@ -2,22 +2,59 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/go-pg/pg/v9"
-	"github.com/go-pg/pg/v9/orm"
 	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/api"
 	"github.com/jaredallard/balance/pkg/handlers"
-	"github.com/jaredallard/balance/pkg/social/telegram"
-	"github.com/pkg/errors"
+	"github.com/jaredallard/balance/pkg/health"
+	"github.com/jaredallard/balance/pkg/metrics"
+	"github.com/jaredallard/balance/pkg/scheduler"
+	"github.com/jaredallard/balance/pkg/social"
+	"github.com/jaredallard/balance/pkg/tracing"
+	"github.com/jaredallard/balance/pkg/web"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+
+	// providers register themselves with social when they're imported
+	_ "github.com/jaredallard/balance/pkg/social/telegram"
 )
 
+// connect connects to the database
+func connect() *pg.DB {
+	return pg.Connect(&pg.Options{
+		User:     "postgres",
+		Database: "balance",
+		Password: os.Getenv("POSTGRES_PASSWORD"),
+	})
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "import":
+			runImport(os.Args[2:])
+			return
+		case "backup":
+			runBackup(os.Args[2:])
+			return
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		}
+	}
+
 	log.Infof("starting balance bot")
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -27,95 +64,202 @@ func main() {
 
 	go func() {
 		<-c
-		log.Info("shutting down on interrupt")
+		log.Info("shutting down on interrupt, interrupt again to exit immediately")
 		cancel()
+
+		<-c
+		log.Warn("exiting without finishing in-flight messages")
+		os.Exit(1)
 	}()
 
-	db := pg.Connect(&pg.Options{
-		User:     "postgres",
-		Database: "balance",
-		Password: os.Getenv("POSTGRES_PASSWORD"),
-	})
-	defer db.Close()
+	shutdownTimeout := 30 * time.Second
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		var err error
+		shutdownTimeout, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("failed to parse SHUTDOWN_TIMEOUT: %v", err)
+		}
+	}
+
+	// the database is closed last, once we've shutdown, since everything else uses it
+	db := connect()
+	db.AddQueryHook(metrics.QueryHook{})
+	db.AddQueryHook(tracing.QueryHook{})
+
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Exporter(os.Getenv("TRACING_EXPORTER")))
+	if err != nil {
+		log.Fatalf("failed to setup tracing: %v", err)
+	}
+	defer func() {
+		// flush any spans that haven't been exported yet
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Warnf("failed to shutdown tracing: %v", err)
+		}
+	}()
 
 	a := account.NewClient(db)
+	if err := metrics.RegisterStats(a); err != nil {
+		log.Fatalf("failed to register metrics: %v", err)
+	}
 
-	_, err := db.Exec(`CREATE EXTENSION IF NOT EXISTS "uuid-ossp";`)
+	if err := a.Migrate(ctx); err != nil {
+		log.Fatalf("failed to migrate database: %v", err)
+	}
+
+	names := "telegram"
+	if v := os.Getenv("PROVIDERS"); v != "" {
+		names = v
+	}
+
+	var providers []social.Provider
+	for _, name := range strings.Split(names, ",") {
+		p, err := social.Open(account.PlatformName(strings.TrimSpace(name)), a)
+		if err != nil {
+			log.Fatalf("failed to create provider %s: %v", name, err)
+		}
+		providers = append(providers, p)
+	}
+
+	t, err := social.NewMultiplexer(providers...)
 	if err != nil {
-		log.Fatalf("failed to create extensions: %v", err)
+		log.Fatalf("failed to create providers: %v", err)
 	}
 
-	for _, model := range []interface{}{(*account.User)(nil), (*account.Account)(nil), (*account.Transaction)(nil)} {
-		err := db.CreateTable(model, &orm.CreateTableOptions{
-			Temp:        false,
-			IfNotExists: true,
-		})
+	h := handlers.NewHandlers(a, t)
+	if v := os.Getenv("REMINDER_THRESHOLD"); v != "" {
+		h.Reminders.Threshold, err = strconv.ParseFloat(v, 64)
 		if err != nil {
-			log.Warnf("failed to create tables in database: %v", err)
+			log.Fatalf("failed to parse REMINDER_THRESHOLD: %v", err)
+		}
+	}
+	if v := os.Getenv("REMINDER_AGE"); v != "" {
+		h.Reminders.Age, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("failed to parse REMINDER_AGE: %v", err)
+		}
+	}
+	if v := os.Getenv("REMINDER_INTERVAL"); v != "" {
+		h.Reminders.Interval, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("failed to parse REMINDER_INTERVAL: %v", err)
+		}
+	}
+
+	r := handlers.NewRouter(h)
+	if v := os.Getenv("IMPLICIT_REGISTRATION"); v != "" {
+		r.ImplicitRegistration, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("failed to parse IMPLICIT_REGISTRATION: %v", err)
 		}
 	}
 
-	t, err := telegram.NewProvider(a)
+	if v := os.Getenv("ADMINS"); v != "" {
+		r.Admins = strings.Split(v, ",")
+	}
+	if v := os.Getenv("MESSAGE_TIMEOUT"); v != "" {
+		r.Timeout, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("failed to parse MESSAGE_TIMEOUT: %v", err)
+		}
+	}
+
+	addr := os.Getenv("HTTP_ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+
+	webURL := os.Getenv("WEB_URL")
+	if webURL == "" {
+		webURL = "http://localhost" + addr
+	}
+
+	dashboard, err := web.NewServer(a, t, webURL)
 	if err != nil {
-		log.Fatalf("failed to create Telegram provider: %v", err)
+		log.Fatalf("failed to create web dashboard: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(api.Prefix, api.NewServer(a, h))
+	checker := health.NewChecker(a)
+	for _, p := range t.Providers() {
+		checker.AddProvider(string(p.Name()), p)
+	}
+
+	mux.HandleFunc("/healthz", checker.ServeLive)
+	mux.HandleFunc("/readyz", checker.ServeReady)
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/", dashboard)
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		log.Infof("serving http on %s", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("failed to serve http: %v", err)
+		}
+	}()
+
+	workers := 8
+	if v := os.Getenv("WORKERS"); v != "" {
+		workers, err = strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("failed to parse WORKERS: %v", err)
+		}
 	}
 
-	msgs, err := t.CreateStream(ctx)
+	queueSize := 100
+	if v := os.Getenv("QUEUE_SIZE"); v != "" {
+		queueSize, err = strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("failed to parse QUEUE_SIZE: %v", err)
+		}
+	}
+
+	s := &server{
+		Messages:        t,
+		Workers:         workers,
+		QueueSize:       queueSize,
+		ShutdownTimeout: shutdownTimeout,
+		Background:      scheduler.NewScheduler(a, h, t).Run,
+		HTTP:            srv,
+		DB:              db,
+		Handle: func(ctx context.Context, msg *social.Message) {
+			handleMessage(ctx, r, msg)
+		},
+	}
+	if err := s.Run(ctx); err != nil {
+		log.Fatalf("failed to create message stream: %v", err)
+	}
+}
+
+// handleMessage handles a message, and replies to it. The handler runs with
+// ctx, in the message's span.
+func handleMessage(ctx context.Context, r *handlers.Router, msg *social.Message) {
+	log.Infof("got message from %v: %s", msg.From, msg.Text)
+
+	if msg.Error != nil {
+		log.Warnf("failed to process message: %v", msg.Error)
+	}
+
+	command := r.Command(msg)
+	metrics.MessagesReceived.WithLabelValues(string(msg.PlatformName), command).Inc()
+
+	start := time.Now()
+	reply, err := r.Handle(trace.ContextWithSpan(ctx, trace.SpanFromContext(msg.Context())), msg)
+	metrics.HandlerDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
 	if err != nil {
-		log.Fatalf("failed to create Telegram stream: %v", err)
-	}
-
-	h := handlers.NewHandlers(a)
-
-	log.Infof("started processing messages")
-	for {
-		select {
-		case msg := <-msgs:
-			log.Infof("got message from %v: %s", msg.From, msg.Text)
-
-			// TODO(jaredallard): better entity handling
-			tokens := strings.Split(msg.Text, " ")
-			tokens[0] = strings.Replace(tokens[0], "/", "", 1)
-
-			var reply string
-			var err error
-
-			if msg.Error != nil {
-				log.Warnf(errors.Wrap(err, "failed to process message").Error())
-			}
-
-			if msg.From == nil {
-				reply, err = h.HandleNewUser(&msg)
-			} else if tokens[0] == "help" || tokens[0] == "start" {
-				reply, err = h.HandleHelp(&msg)
-			} else if tokens[0] == "list" {
-				reply, err = h.HandleListUsers(&msg)
-			} else if tokens[0] == "history" {
-				reply, err = h.HandleHistory(&msg, tokens)
-			} else if tokens[0] == "add" {
-				reply, err = h.HandleAdd(&msg, tokens)
-			} else if tokens[0] == "status" {
-				reply, err = h.HandleBalance(&msg)
-			} else {
-				reply = fmt.Sprintf("Unknown command '%s'", msg.Text)
-			}
-
-			if err != nil {
-				log.Errorf("failed to process message via handler: %v", err)
-			}
-
-			if reply != "" {
-				err := msg.Reply(reply)
-				if err != nil {
-					log.Warnf("failed to send reply: %v", err)
-				}
-			}
-
-		// TODO(jaredallard): we should wait for the message processor to shutdown before
-		// we shutdown the handler
-		case <-ctx.Done():
-			log.Warnf("message handler shutdown")
-			return
+		metrics.HandlerErrors.WithLabelValues(command).Inc()
+		log.Errorf("failed to process message via handler: %v", err)
+	}
+
+	if !reply.Empty() {
+		err := msg.Reply(reply)
+		if err != nil {
+			metrics.ReplyFailures.WithLabelValues(string(msg.PlatformName)).Inc()
+			log.Warnf("failed to send reply: %v", err)
 		}
 	}
+
+	trace.SpanFromContext(msg.Context()).End()
 }
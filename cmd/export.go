@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/export"
+	log "github.com/sirupsen/logrus"
+)
+
+// runExport runs `balance export`, which exports a user's history to a file
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "csv", "format to export as, csv, json, ledger, hledger or beancount")
+	platform := fs.String("platform", string(account.PlatformTelegram), "platform of the usernames")
+	username := fs.String("user", "", "username of the user to export")
+	withUsername := fs.String("with", "", "only export transactions with this username")
+	sinceStr := fs.String("since", "", "only export transactions since this date, e.g. 2020-01-31")
+	output := fs.String("o", "", "file to write to, defaults to stdout")
+	fs.Parse(args)
+
+	if *username == "" {
+		log.Fatalf("-user is required")
+	}
+
+	f, err := export.ParseFormat(*format)
+	if err != nil {
+		log.Fatalf("failed to parse -format: %v", err)
+	}
+
+	var since time.Time
+	if *sinceStr != "" {
+		since, err = time.Parse("2006-01-02", *sinceStr)
+		if err != nil {
+			log.Fatalf("failed to parse -since: %v", err)
+		}
+	}
+
+	db := connect()
+	defer db.Close()
+
+	a := account.NewClient(db)
+	ctx := context.Background()
+	p := account.PlatformName(*platform)
+
+	u, _, err := a.LookupUsername(ctx, p, normalizeUsername(*username))
+	if err != nil {
+		log.Fatalf("failed to find user '%s': %v", *username, err)
+	}
+
+	var with *account.User
+	if *withUsername != "" {
+		with, _, err = a.LookupUsername(ctx, p, normalizeUsername(*withUsername))
+		if err != nil {
+			log.Fatalf("failed to find user '%s': %v", *withUsername, err)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("failed to create output file: %v", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := export.NewExporter(a, p).Export(ctx, w, f, u, with, since); err != nil {
+		log.Fatalf("failed to export history: %v", err)
+	}
+}
+
+// normalizeUsername returns a username the way it's stored, without an @
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimPrefix(username, "@"))
+}
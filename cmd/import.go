@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/importer"
+	log "github.com/sirupsen/logrus"
+)
+
+// runImport runs `balance import`, which imports a Splitwise CSV export
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	platform := fs.String("platform", string(account.PlatformTelegram), "platform of the usernames in the mapping")
+	mappingFile := fs.String("mapping", "", "file mapping each person in the export to a user, one NAME=USERNAME per line")
+	dryRun := fs.Bool("dry-run", false, "report what would be imported without importing it")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: balance import -mapping FILE [-dry-run] SPLITWISE_CSV\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *mappingFile == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	mf, err := os.Open(*mappingFile)
+	if err != nil {
+		log.Fatalf("failed to open mapping: %v", err)
+	}
+	defer mf.Close()
+
+	m, err := importer.ParseMapping(mf)
+	if err != nil {
+		log.Fatalf("failed to parse mapping: %v", err)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("failed to open export: %v", err)
+	}
+	defer f.Close()
+
+	db := connect()
+	defer db.Close()
+
+	a := account.NewClient(db)
+	ctx := context.Background()
+	if err := a.Migrate(ctx); err != nil {
+		log.Fatalf("failed to migrate database: %v", err)
+	}
+
+	report, err := importer.NewImporter(a, account.PlatformName(*platform)).ImportSplitwise(ctx, f, m, *dryRun)
+	if report != nil {
+		fmt.Print(report)
+	}
+	if err != nil {
+		log.Fatalf("failed to import: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/jaredallard/balance/pkg/dispatcher"
+	"github.com/jaredallard/balance/pkg/social"
+	log "github.com/sirupsen/logrus"
+)
+
+// messageSource is where messages come from, *social.Multiplexer implements it
+type messageSource interface {
+	CreateStream(ctx context.Context) (<-chan social.Message, error)
+	Close() error
+}
+
+// server handles messages until it's told to shutdown, and then shuts
+// everything down in the order they depend on each other
+type server struct {
+	// Messages is closed once every message from it has been handled
+	Messages messageSource
+
+	// Handle handles a message. Its context is only cancelled if the message
+	// is still being handled ShutdownTimeout after we're told to shutdown.
+	Handle func(ctx context.Context, msg *social.Message)
+
+	Workers   int
+	QueueSize int
+
+	// ShutdownTimeout is how long in-flight messages, and HTTP requests, have
+	// to finish once we're told to shutdown
+	ShutdownTimeout time.Duration
+
+	// Background runs until ctx is done, e.g. the scheduler, and is waited on
+	// once every message has been handled
+	Background func(ctx context.Context)
+
+	// HTTP is shutdown once every message has been handled, if it's set
+	HTTP *http.Server
+
+	// DB is closed last, since everything else uses it
+	DB io.Closer
+}
+
+// Run handles messages until ctx is done and every message we received has
+// been handled, then shuts down
+func (s *server) Run(ctx context.Context) error {
+	// providers close their stream once ctx is done, and they've sent us every
+	// message they received
+	msgs, err := s.Messages.CreateStream(ctx)
+	if err != nil {
+		return err
+	}
+
+	backgroundDone := make(chan struct{})
+	go func() {
+		defer close(backgroundDone)
+		if s.Background != nil {
+			s.Background(ctx)
+		}
+	}()
+
+	// messages are handled with workCtx, rather than ctx, so that they can
+	// finish while we shutdown. It's only cancelled if they take too long.
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+
+	d := dispatcher.NewDispatcher(s.Workers, s.QueueSize, func(msg *social.Message) {
+		s.Handle(workCtx, msg)
+	})
+
+	go func() {
+		<-ctx.Done()
+		select {
+		case <-time.After(s.ShutdownTimeout):
+			log.Warnf("in-flight messages didn't finish within %s, cancelling them", s.ShutdownTimeout)
+			cancelWork()
+		case <-workCtx.Done():
+		}
+	}()
+
+	log.Infof("started processing messages with %d workers", s.Workers)
+	for msg := range msgs {
+		if err := d.Dispatch(workCtx, msg); err != nil {
+			log.Warnf("failed to dispatch message: %v", err)
+		}
+	}
+
+	log.Infof("message stream closed, waiting for in-flight messages")
+	d.Close()
+	cancelWork()
+	<-backgroundDone
+
+	if s.HTTP != nil {
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+		defer cancelShutdown()
+		if err := s.HTTP.Shutdown(shutdownCtx); err != nil {
+			log.Warnf("failed to shutdown http server: %v", err)
+		}
+	}
+
+	if err := s.Messages.Close(); err != nil {
+		log.Warnf("failed to close providers: %v", err)
+	}
+
+	if s.DB != nil {
+		if err := s.DB.Close(); err != nil {
+			log.Warnf("failed to close database: %v", err)
+		}
+	}
+
+	log.Infof("shutdown complete")
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaredallard/balance/pkg/social"
+)
+
+// events records what happened during a shutdown, in order
+type events struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (e *events) add(event string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.list = append(e.list, event)
+}
+
+// count returns how many times event happened
+func (e *events) count(event string) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	n := 0
+	for _, got := range e.list {
+		if got == event {
+			n++
+		}
+	}
+	return n
+}
+
+// last returns when event last happened, or -1 if it didn't
+func (e *events) last(event string) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := len(e.list) - 1; i >= 0; i-- {
+		if e.list[i] == event {
+			return i
+		}
+	}
+	return -1
+}
+
+// fakeProvider sends its messages, and then closes its stream once ctx is done
+type fakeProvider struct {
+	msgs   []social.Message
+	events *events
+}
+
+func (p *fakeProvider) CreateStream(ctx context.Context) (<-chan social.Message, error) {
+	stream := make(chan social.Message)
+	go func() {
+		defer close(stream)
+		for _, msg := range p.msgs {
+			stream <- msg
+		}
+		<-ctx.Done()
+	}()
+
+	return stream, nil
+}
+
+func (p *fakeProvider) Close() error {
+	p.events.add("provider closed")
+	return nil
+}
+
+// fakeDB records when it's closed
+type fakeDB struct {
+	events *events
+}
+
+func (db *fakeDB) Close() error {
+	db.events.add("db closed")
+	return nil
+}
+
+// newTestServer creates a server for n messages, each in their own chat, that
+// are handled by handle
+func newTestServer(n int, timeout time.Duration, handle func(ctx context.Context, msg *social.Message)) (*server, *events) {
+	e := &events{}
+
+	p := &fakeProvider{events: e}
+	for i := 0; i < n; i++ {
+		p.msgs = append(p.msgs, social.Message{ID: strconv.Itoa(i), ChatID: strconv.Itoa(i)})
+	}
+
+	return &server{
+		Messages:        p,
+		Handle:          handle,
+		Workers:         n,
+		QueueSize:       1,
+		ShutdownTimeout: timeout,
+		Background: func(ctx context.Context) {
+			<-ctx.Done()
+			e.add("background done")
+		},
+		DB: &fakeDB{events: e},
+	}, e
+}
+
+// run runs s, cancelling it once started has been received from n times, and
+// returns how long it took to shutdown
+func run(t *testing.T, s *server, started <-chan struct{}, n int) time.Duration {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	for i := 0; i < n; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d messages were handled", i, n)
+		}
+	}
+
+	start := time.Now()
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("failed to run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("didn't shutdown")
+	}
+
+	return time.Since(start)
+}
+
+// expectOrder checks that each event last happened after the one before it
+func expectOrder(t *testing.T, e *events, order ...string) {
+	t.Helper()
+
+	for i, event := range order {
+		at := e.last(event)
+		if at == -1 {
+			t.Fatalf("expected '%s' to happen, got %v", event, e.list)
+		}
+		if i > 0 && at < e.last(order[i-1]) {
+			t.Errorf("expected '%s' after '%s', got %v", event, order[i-1], e.list)
+		}
+	}
+}
+
+func TestShutdownFinishesInFlightMessages(t *testing.T) {
+	const n = 3
+	started := make(chan struct{}, n)
+
+	var e *events
+	var s *server
+	s, e = newTestServer(n, 5*time.Second, func(ctx context.Context, msg *social.Message) {
+		started <- struct{}{}
+
+		// still in-flight when we're told to shutdown
+		select {
+		case <-time.After(100 * time.Millisecond):
+			e.add("handled")
+		case <-ctx.Done():
+			e.add("cancelled")
+		}
+	})
+
+	if took := run(t, s, started, n); took >= s.ShutdownTimeout {
+		t.Errorf("expected to shutdown once messages were handled, took %v", took)
+	}
+
+	if got := e.count("handled"); got != n {
+		t.Errorf("expected %d messages to finish, %d did: %v", n, got, e.list)
+	}
+	if got := e.count("cancelled"); got != 0 {
+		t.Errorf("expected no messages to be cancelled, %d were", got)
+	}
+	expectOrder(t, e, "handled", "provider closed", "db closed")
+	expectOrder(t, e, "background done", "provider closed")
+}
+
+func TestShutdownCancelsStragglers(t *testing.T) {
+	const n = 2
+	started := make(chan struct{}, n)
+
+	var e *events
+	var s *server
+	s, e = newTestServer(n, 100*time.Millisecond, func(ctx context.Context, msg *social.Message) {
+		started <- struct{}{}
+
+		// never finishes on its own
+		<-ctx.Done()
+		e.add("cancelled")
+	})
+
+	took := run(t, s, started, n)
+	if took < s.ShutdownTimeout {
+		t.Errorf("expected messages to be cancelled after %v, took %v", s.ShutdownTimeout, took)
+	}
+	if took > 2*time.Second {
+		t.Errorf("expected messages to be cancelled after %v, took %v", s.ShutdownTimeout, took)
+	}
+
+	if got := e.count("cancelled"); got != n {
+		t.Errorf("expected %d messages to be cancelled, %d were: %v", n, got, e.list)
+	}
+	expectOrder(t, e, "cancelled", "provider closed", "db closed")
+}
@@ -1,12 +1,14 @@
 package account
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"math"
+	"sync"
 	"time"
 
 	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
 	"github.com/gofrs/uuid"
 	"github.com/patrickmn/go-cache"
 )
@@ -49,6 +51,9 @@ func (a *Account) String() string {
 type Client struct {
 	db    *pg.DB
 	cache *cache.Cache
+
+	listenersMu sync.RWMutex
+	listeners   []UserListener
 }
 
 // NewClient creates a new client
@@ -60,9 +65,9 @@ func NewClient(db *pg.DB) *Client {
 }
 
 // FindAccounts finds all accounts owned by a user
-func (c *Client) FindAccounts(u *User) ([]*Account, error) {
+func (c *Client) FindAccounts(ctx context.Context, u *User) ([]*Account, error) {
 	var a []*Account
-	err := c.db.Model(&a).
+	err := c.db.WithContext(ctx).Model(&a).
 		Relation("Creator").
 		Relation("Subject").
 		Where("account.creator_id = ? OR account.subject_id = ?", u.Id, u.Id).
@@ -74,10 +79,59 @@ func (c *Client) FindAccounts(u *User) ([]*Account, error) {
 	return a, err
 }
 
+// FindOutstandingAccounts finds all accounts that have a non-zero balance
+func (c *Client) FindOutstandingAccounts(ctx context.Context) ([]*Account, error) {
+	var a []*Account
+	err := c.db.WithContext(ctx).Model(&a).
+		Relation("Creator").
+		Relation("Subject").
+		Where("account.balance != 0").
+		Select()
+
+	return a, err
+}
+
+// Debtor returns the user who owes money on this account and who they owe it
+// to, or nil if the account is settled. Creator and Subject must be loaded.
+func (a *Account) Debtor() (debtor *User, creditor *User) {
+	switch {
+	case a.Balance > 0:
+		return a.Subject, a.Creator
+	case a.Balance < 0:
+		return a.Creator, a.Subject
+	}
+
+	return nil, nil
+}
+
+// OutstandingBalance returns the total amount owed across every account
+func (c *Client) OutstandingBalance(ctx context.Context) (float64, error) {
+	var total float64
+	_, err := c.db.WithContext(ctx).QueryOne(pg.Scan(&total), `SELECT COALESCE(SUM(ABS(balance)), 0) FROM accounts`)
+	return total, err
+}
+
+// CountActiveUsers returns how many users have created, or been involved in,
+// a transaction since a time
+func (c *Client) CountActiveUsers(ctx context.Context, since time.Time) (int, error) {
+	var n int
+	_, err := c.db.WithContext(ctx).QueryOne(pg.Scan(&n), `
+		SELECT COUNT(DISTINCT id) FROM (
+			SELECT created_by AS id FROM transactions WHERE created_at > ?0
+			UNION
+			SELECT jsonb_object_keys(accounts)::uuid AS id FROM transactions WHERE created_at > ?0
+		) AS active`, since)
+	return n, err
+}
+
 // FindAccountBetween finds an account between a user
-func (c *Client) FindAccountBetween(u1 *User, u2 *User) (*Account, error) {
+func (c *Client) FindAccountBetween(ctx context.Context, u1 *User, u2 *User) (*Account, error) {
+	return c.findAccountBetween(c.db.WithContext(ctx), u1, u2)
+}
+
+func (c *Client) findAccountBetween(db orm.DB, u1 *User, u2 *User) (*Account, error) {
 	a := &Account{}
-	err := c.db.Model(a).
+	err := db.Model(a).
 		Relation("Creator").
 		Relation("Subject").
 		Where("(account.creator_id = ? AND account.subject_id = ?) OR (account.creator_id = ? AND account.subject_id = ?)", u1.Id, u2.Id, u2.Id, u1.Id).
@@ -90,46 +144,45 @@ func (c *Client) FindAccountBetween(u1 *User, u2 *User) (*Account, error) {
 }
 
 // NewTransaction records a new transaction
-func (c *Client) NewTransaction(creator *User, subject *User, amount float64) error {
-	a, err := c.FindAccountBetween(creator, subject)
+func (c *Client) NewTransaction(ctx context.Context, creator *User, subject *User, amount float64) error {
+	return c.newTransaction(c.db.WithContext(ctx), creator, subject, amount)
+}
+
+// newTransaction applies amount to the account between creator and subject using db,
+// which may be a database transaction
+func (c *Client) newTransaction(db orm.DB, creator *User, subject *User, amount float64) error {
+	a, err := c.findAccountBetween(db, creator, subject)
 	if err != nil {
 		return err
 	}
 
-	newBalance := a.Balance
-	isNeg := amount < 0
-
-	if isNeg {
-		amount = math.Abs(amount)
-	}
-
-	if a.CreatorId == creator.Id {
-		if isNeg {
-			newBalance = newBalance - amount
-		} else {
-			newBalance = newBalance + amount
-		}
-	} else if a.SubjectId == creator.Id {
-		if isNeg {
-			newBalance = newBalance + amount
-		} else {
-			newBalance = newBalance - amount
-		}
-	} else {
+	// balances are what the subject owes the creator of the account
+	if a.SubjectId == creator.Id {
+		amount = -amount
+	} else if a.CreatorId != creator.Id {
 		return ErrAccountNotFound
 	}
 
-	a.Balance = newBalance
-	a.UpdatedAt = time.Now()
+	return addBalance(db, a, amount)
+}
 
-	_, err = c.db.Model(a).Column("balance", "updated_at").Where("account.id = ?", a.Id).Update(a)
+// addBalance adds amount to the balance of an account in the database, rather
+// than writing back the balance we read, so that concurrent changes aren't lost
+func addBalance(db orm.DB, a *Account, amount float64) error {
+	a.UpdatedAt = time.Now()
+	_, err := db.Model(a).
+		Set("balance = balance + ?", amount).
+		Set("updated_at = ?", a.UpdatedAt).
+		Where("account.id = ?", a.Id).
+		Returning("balance").
+		Update()
 	return err
 }
 
 // GetAccount returns an account
-func (c *Client) GetAccount(id uuid.UUID) (*Account, error) {
+func (c *Client) GetAccount(ctx context.Context, id uuid.UUID) (*Account, error) {
 	a := &Account{Id: id}
-	err := c.db.Model(a).
+	err := c.db.WithContext(ctx).Model(a).
 		Relation("Creator").
 		Relation("Subject").
 		Where("id = ?", a.Id).
@@ -142,7 +195,11 @@ func (c *Client) GetAccount(id uuid.UUID) (*Account, error) {
 }
 
 // CreateAccount creates an account
-func (c *Client) CreateAccount(a *Account) error {
+func (c *Client) CreateAccount(ctx context.Context, a *Account) error {
+	return c.createAccount(c.db.WithContext(ctx), a)
+}
+
+func (c *Client) createAccount(db orm.DB, a *Account) error {
 	// we have to set a.CreatorId to get our ORM to properly create the relations
 	if a.Creator != nil && a.CreatorId != a.Creator.Id {
 		a.CreatorId = a.Creator.Id
@@ -156,6 +213,6 @@ func (c *Client) CreateAccount(a *Account) error {
 		return fmt.Errorf("An account must have a creatorId and subjectId")
 	}
 
-	_, err := c.db.Model(a).Insert()
+	_, err := db.Model(a).Insert()
 	return err
 }
@@ -0,0 +1,95 @@
+package account
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
+)
+
+// newTestClient connects to the database in TEST_DATABASE_URL, which is
+// emptied before and after the test. Tests are skipped without one.
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL isn't set")
+	}
+
+	opts, err := pg.ParseURL(url)
+	if err != nil {
+		t.Fatalf("failed to parse TEST_DATABASE_URL: %v", err)
+	}
+
+	db := pg.Connect(opts)
+	c := NewClient(db)
+	if err := c.Migrate(context.Background()); err != nil {
+		db.Close()
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	truncate(t, db)
+	t.Cleanup(func() {
+		truncate(t, db)
+		db.Close()
+	})
+
+	return c
+}
+
+// truncate empties every table managed by this package
+func truncate(t *testing.T, db *pg.DB) {
+	t.Helper()
+
+	tables := make([]string, 0, len(models))
+	for _, model := range models {
+		tables = append(tables, string(orm.GetTable(reflect.TypeOf(model).Elem()).FullName))
+	}
+
+	if _, err := db.Exec("TRUNCATE " + strings.Join(tables, ", ")); err != nil {
+		t.Fatalf("failed to truncate tables: %v", err)
+	}
+}
+
+// nextPlatformID is the telegram ID given to the next test user
+var nextPlatformID = 1000
+
+// newTestUser creates a telegram user named name
+func newTestUser(t *testing.T, c *Client, name string) *User {
+	t.Helper()
+
+	nextPlatformID++
+	u := &User{
+		PlatformIds:       map[PlatformName]string{PlatformTelegram: strconv.Itoa(nextPlatformID)},
+		PlatformUsernames: map[PlatformName]string{PlatformTelegram: name},
+	}
+	if err := c.CreateUser(context.Background(), u); err != nil {
+		t.Fatalf("failed to create user %s: %v", name, err)
+	}
+
+	return u
+}
+
+// balance returns the balance of the account between creator and subject,
+// from the point of view of creator
+func balance(t *testing.T, c *Client, creator, subject *User) float64 {
+	t.Helper()
+
+	a, err := c.FindAccountBetween(context.Background(), creator, subject)
+	if err == ErrAccountNotFound {
+		return 0
+	} else if err != nil {
+		t.Fatalf("failed to find account between %s and %s: %v", creator.Id, subject.Id, err)
+	}
+
+	if a.CreatorId == creator.Id {
+		return a.Balance
+	}
+	return -a.Balance
+}
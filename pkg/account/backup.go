@@ -0,0 +1,467 @@
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/gofrs/uuid"
+)
+
+// BackupFormat is the file format of a backup
+type BackupFormat string
+
+const (
+	// BackupJSON is a single JSON document
+	BackupJSON BackupFormat = "json"
+
+	// BackupNDJSON is a header followed by one record per line
+	BackupNDJSON BackupFormat = "ndjson"
+)
+
+const (
+	// backupMagic identifies a file as a backup
+	backupMagic = "balance-backup"
+
+	// backupVersion is the version of the backup format, it changes whenever
+	// old versions wouldn't be able to restore a backup
+	backupVersion = 1
+)
+
+var (
+	// ErrNotEmpty is returned when restoring into a database that already has data
+	ErrNotEmpty error = errors.New("Database is not empty")
+
+	// ErrIntegrity is returned when a strict restore fails its integrity checks
+	ErrIntegrity error = errors.New("Backup failed integrity checks")
+)
+
+// BackupHeader describes a backup
+type BackupHeader struct {
+	// Format is always balance-backup
+	Format string `json:"format"`
+
+	// Version is the version of the backup format
+	Version int `json:"version"`
+
+	// SchemaVersion is how many migrations the database had applied
+	SchemaVersion int `json:"schema_version"`
+
+	CreatedAt time.Time `json:"created_at"`
+
+	// Counts is how many records of each type are in the backup
+	Counts map[string]int `json:"counts"`
+}
+
+// backupRecord is a single row in a backup
+type backupRecord struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// backupArchive is a JSON backup, or the first line of an NDJSON backup
+type backupArchive struct {
+	BackupHeader
+	Records []backupRecord `json:"records,omitempty"`
+}
+
+// backupData is every row in a backup, in the order they're restored in
+type backupData struct {
+	Users        []*User
+	Usernames    []*UsernameHistory
+	Accounts     []*Account
+	Transactions []*Transaction
+	Recurring    []*RecurringTransaction
+	Statements   []*StatementSubscription
+}
+
+// tables returns a pointer to each slice in d, keyed by its record type, in
+// restore order. API tokens, sessions, and link codes are never backed up.
+func (d *backupData) tables() []struct {
+	name  string
+	model interface{}
+} {
+	return []struct {
+		name  string
+		model interface{}
+	}{
+		{"user", &d.Users},
+		{"username_history", &d.Usernames},
+		{"account", &d.Accounts},
+		{"transaction", &d.Transactions},
+		{"recurring_transaction", &d.Recurring},
+		{"statement_subscription", &d.Statements},
+	}
+}
+
+// add decodes a record into d
+func (d *backupData) add(r backupRecord) error {
+	var err error
+	switch r.Type {
+	case "user":
+		v := &User{}
+		err = json.Unmarshal(r.Data, v)
+		d.Users = append(d.Users, v)
+	case "username_history":
+		v := &UsernameHistory{}
+		err = json.Unmarshal(r.Data, v)
+		d.Usernames = append(d.Usernames, v)
+	case "account":
+		v := &Account{}
+		err = json.Unmarshal(r.Data, v)
+		v.Creator, v.Subject = nil, nil
+		d.Accounts = append(d.Accounts, v)
+	case "transaction":
+		v := &Transaction{}
+		err = json.Unmarshal(r.Data, v)
+		d.Transactions = append(d.Transactions, v)
+	case "recurring_transaction":
+		v := &RecurringTransaction{}
+		err = json.Unmarshal(r.Data, v)
+		d.Recurring = append(d.Recurring, v)
+	case "statement_subscription":
+		v := &StatementSubscription{}
+		err = json.Unmarshal(r.Data, v)
+		d.Statements = append(d.Statements, v)
+	default:
+		return fmt.Errorf("unknown record type '%s'", r.Type)
+	}
+
+	return err
+}
+
+// records returns every row in d as records
+func (d *backupData) records() ([]backupRecord, map[string]int, error) {
+	records := []backupRecord{}
+	counts := make(map[string]int)
+
+	appendAll := func(name string, n int, get func(i int) interface{}) error {
+		counts[name] = n
+		for i := 0; i < n; i++ {
+			b, err := json.Marshal(get(i))
+			if err != nil {
+				return err
+			}
+			records = append(records, backupRecord{Type: name, Data: b})
+		}
+		return nil
+	}
+
+	for _, err := range []error{
+		appendAll("user", len(d.Users), func(i int) interface{} { return d.Users[i] }),
+		appendAll("username_history", len(d.Usernames), func(i int) interface{} { return d.Usernames[i] }),
+		appendAll("account", len(d.Accounts), func(i int) interface{} { return d.Accounts[i] }),
+		appendAll("transaction", len(d.Transactions), func(i int) interface{} { return d.Transactions[i] }),
+		appendAll("recurring_transaction", len(d.Recurring), func(i int) interface{} { return d.Recurring[i] }),
+		appendAll("statement_subscription", len(d.Statements), func(i int) interface{} { return d.Statements[i] }),
+	} {
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return records, counts, nil
+}
+
+// Backup writes every user, account, and transaction, along with the rest of
+// their data, to w as a self-describing archive
+func (c *Client) Backup(ctx context.Context, w io.Writer, f BackupFormat) error {
+	d := &backupData{}
+	err := c.db.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		// see a consistent snapshot of every table
+		if _, err := tx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY"); err != nil {
+			return err
+		}
+
+		for _, t := range d.tables() {
+			if err := tx.Model(t.model).Order("id ASC").Select(); err != nil && !errors.Is(err, pg.ErrNoRows) {
+				return fmt.Errorf("failed to read %s: %v", t.name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	records, counts, err := d.records()
+	if err != nil {
+		return err
+	}
+
+	archive := backupArchive{
+		BackupHeader: BackupHeader{
+			Format:        backupMagic,
+			Version:       backupVersion,
+			SchemaVersion: len(migrations),
+			CreatedAt:     time.Now().UTC(),
+			Counts:        counts,
+		},
+	}
+
+	enc := json.NewEncoder(w)
+	switch f {
+	case BackupJSON:
+		archive.Records = records
+		enc.SetIndent("", "  ")
+		return enc.Encode(archive)
+	case BackupNDJSON:
+		if err := enc.Encode(archive); err != nil {
+			return err
+		}
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown backup format '%s'", f)
+	}
+}
+
+// readBackup reads a JSON or NDJSON backup
+func readBackup(r io.Reader) (*BackupHeader, *backupData, error) {
+	dec := json.NewDecoder(r)
+
+	var archive backupArchive
+	if err := dec.Decode(&archive); err != nil {
+		return nil, nil, fmt.Errorf("failed to read backup header: %v", err)
+	}
+
+	h := &archive.BackupHeader
+	if h.Format != backupMagic {
+		return nil, nil, fmt.Errorf("not a backup")
+	}
+	if h.Version > backupVersion {
+		return nil, nil, fmt.Errorf("backup version %d is newer than this version of balance supports (%d)", h.Version, backupVersion)
+	}
+	if h.SchemaVersion > len(migrations) {
+		return nil, nil, fmt.Errorf("backup schema version %d is newer than this version of balance supports (%d)", h.SchemaVersion, len(migrations))
+	}
+
+	d := &backupData{}
+	for _, rec := range archive.Records {
+		if err := d.add(rec); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// NDJSON backups have a record per line after the header
+	for {
+		var rec backupRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("failed to read record: %v", err)
+		}
+
+		if err := d.add(rec); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	_, counts, err := d.records()
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, n := range h.Counts {
+		if counts[name] != n {
+			return nil, nil, fmt.Errorf("backup is truncated, expected %d %s records but found %d", n, name, counts[name])
+		}
+	}
+
+	return h, d, nil
+}
+
+// RestoreReport is the outcome of a restore
+type RestoreReport struct {
+	Header *BackupHeader
+
+	// Restored is how many records of each type were restored
+	Restored map[string]int
+
+	// Problems are the integrity checks that failed
+	Problems []string
+}
+
+// Restore loads a backup into an empty database. Every record is checked for
+// integrity first, problems are reported, and if strict is set nothing is
+// restored when there are any.
+func (c *Client) Restore(ctx context.Context, r io.Reader, strict bool) (*RestoreReport, error) {
+	h, d, err := readBackup(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.SchemaVersion < legacyAmountsVersion {
+		upgradeLegacyAmounts(d)
+	}
+
+	report := &RestoreReport{
+		Header:   h,
+		Restored: make(map[string]int),
+		Problems: checkIntegrity(d),
+	}
+	if strict && len(report.Problems) != 0 {
+		return report, ErrIntegrity
+	}
+
+	err = c.db.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		for _, t := range d.tables() {
+			n, err := tx.Model(t.model).Count()
+			if err != nil {
+				return err
+			}
+			if n != 0 {
+				return ErrNotEmpty
+			}
+		}
+
+		for _, t := range d.tables() {
+			// inserting an empty slice is an error
+			if reflect.ValueOf(t.model).Elem().Len() == 0 {
+				continue
+			}
+
+			res, err := tx.Model(t.model).Insert()
+			if err != nil {
+				return fmt.Errorf("failed to restore %s: %v", t.name, err)
+			}
+			report.Restored[t.name] = res.RowsAffected()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	c.cache.Flush()
+	return report, nil
+}
+
+// upgradeLegacyAmounts converts transactions in a backup from before the
+// legacy amounts migration, the same way the migration does
+func upgradeLegacyAmounts(d *backupData) {
+	for _, t := range d.Transactions {
+		if t.States != nil || len(t.Accounts) == 0 {
+			continue
+		}
+
+		t.Amount *= float64(len(t.Accounts))
+		t.States = make(map[uuid.UUID]TransactionState, len(t.Accounts))
+		for uid := range t.Accounts {
+			t.States[uid] = TransactionAccepted
+		}
+	}
+}
+
+// CheckIntegrity checks that every account's balance matches its transactions,
+// and that every row references rows that exist
+func (c *Client) CheckIntegrity(ctx context.Context) ([]string, error) {
+	d := &backupData{}
+	for _, t := range d.tables() {
+		if err := c.db.WithContext(ctx).Model(t.model).Select(); err != nil && !errors.Is(err, pg.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	return checkIntegrity(d), nil
+}
+
+// checkIntegrity returns every integrity problem in d
+func checkIntegrity(d *backupData) []string {
+	problems := []string{}
+
+	users := make(map[uuid.UUID]bool, len(d.Users))
+	for _, u := range d.Users {
+		users[u.Id] = true
+	}
+
+	accounts := make(map[uuid.UUID]*Account, len(d.Accounts))
+	for _, a := range d.Accounts {
+		accounts[a.Id] = a
+		if !users[a.CreatorId] || !users[a.SubjectId] {
+			problems = append(problems, fmt.Sprintf("account %s references a user that doesn't exist", a.Id))
+		}
+	}
+
+	for _, h := range d.Usernames {
+		if !users[h.UserId] {
+			problems = append(problems, fmt.Sprintf("username history %s references a user that doesn't exist", h.Id))
+		}
+	}
+
+	for _, r := range d.Recurring {
+		if !users[r.CreatedBy] {
+			problems = append(problems, fmt.Sprintf("recurring transaction %s references a user that doesn't exist", r.Id))
+		}
+	}
+
+	for _, s := range d.Statements {
+		if s.UserId != nil && !users[*s.UserId] {
+			problems = append(problems, fmt.Sprintf("statement %s references a user that doesn't exist", s.Id))
+		}
+	}
+
+	// expected is the balance of each account from its accepted legs, voided
+	// legs have already been reversed
+	expected := make(map[uuid.UUID]float64, len(d.Accounts))
+	for _, t := range d.Transactions {
+		for uid, aid := range t.Accounts {
+			// transactions are kept when a user is deleted, but their settled
+			// accounts aren't. Older versions of /link also left the leg between
+			// the two merged users, on an account that was dropped.
+			if !users[t.CreatedBy] || !users[uid] || uid == t.CreatedBy {
+				continue
+			}
+
+			a, ok := accounts[aid]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("transaction %s references an account that doesn't exist", t.Id))
+				continue
+			}
+			if (a.CreatorId != t.CreatedBy || a.SubjectId != uid) && (a.CreatorId != uid || a.SubjectId != t.CreatedBy) {
+				problems = append(problems, fmt.Sprintf("transaction %s references account %s, which isn't between its users", t.Id, aid))
+				continue
+			}
+
+			if t.State(uid) != TransactionAccepted {
+				continue
+			}
+
+			// balances are from the perspective of the account's creator
+			if a.CreatorId == t.CreatedBy {
+				expected[aid] += t.Share()
+			} else {
+				expected[aid] -= t.Share()
+			}
+		}
+	}
+
+	ids := make([]uuid.UUID, 0, len(d.Accounts))
+	for id := range accounts {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].String() < ids[j].String()
+	})
+
+	for _, id := range ids {
+		a := accounts[id]
+		if math.Abs(a.Balance-expected[id]) > 0.005 {
+			problems = append(problems, fmt.Sprintf("account %s has a balance of %.2f, but its transactions add up to %.2f", id, a.Balance, expected[id]))
+		}
+	}
+
+	return problems
+}
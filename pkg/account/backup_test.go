@@ -0,0 +1,199 @@
+package account
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+)
+
+// backupFixture is a backup with alice, bob, and carol, where bob owes alice
+// 10 and carol owes alice 5
+type backupFixture struct {
+	d                 *backupData
+	alice, bob, carol *User
+	ab, ac            *Account
+}
+
+func newBackupFixture() *backupFixture {
+	f := &backupFixture{
+		alice: &User{Id: uuid.Must(uuid.NewV4())},
+		bob:   &User{Id: uuid.Must(uuid.NewV4())},
+		carol: &User{Id: uuid.Must(uuid.NewV4())},
+	}
+	f.ab = &Account{Id: uuid.Must(uuid.NewV4()), CreatorId: f.alice.Id, SubjectId: f.bob.Id, Balance: 10}
+	f.ac = &Account{Id: uuid.Must(uuid.NewV4()), CreatorId: f.alice.Id, SubjectId: f.carol.Id, Balance: 5}
+
+	f.d = &backupData{
+		Users:    []*User{f.alice, f.bob, f.carol},
+		Accounts: []*Account{f.ab, f.ac},
+		Transactions: []*Transaction{
+			f.transaction(f.alice, 20, map[*User]*Account{f.bob: f.ab, f.carol: f.ac}, map[*User]TransactionState{
+				f.bob: TransactionAccepted, f.carol: TransactionVoided,
+			}),
+			f.transaction(f.carol, 5, map[*User]*Account{f.alice: f.ac}, map[*User]TransactionState{
+				f.alice: TransactionPending,
+			}),
+			f.transaction(f.alice, 5, map[*User]*Account{f.carol: f.ac}, map[*User]TransactionState{
+				f.carol: TransactionAccepted,
+			}),
+		},
+	}
+
+	return f
+}
+
+func (f *backupFixture) transaction(from *User, amount float64, accounts map[*User]*Account, states map[*User]TransactionState) *Transaction {
+	t := &Transaction{
+		Id:        uuid.Must(uuid.NewV4()),
+		CreatedBy: from.Id,
+		Amount:    amount,
+		Accounts:  make(map[uuid.UUID]uuid.UUID),
+	}
+	for u, a := range accounts {
+		t.Accounts[u.Id] = a.Id
+	}
+	if states != nil {
+		t.States = make(map[uuid.UUID]TransactionState)
+		for u, s := range states {
+			t.States[u.Id] = s
+		}
+	}
+	return t
+}
+
+// expectProblems checks that d has a problem containing each of want, and no others
+func expectProblems(t *testing.T, d *backupData, want ...string) {
+	t.Helper()
+
+	problems := checkIntegrity(d)
+	if len(problems) != len(want) {
+		t.Fatalf("expected %d problems, got %v", len(want), problems)
+	}
+	for _, w := range want {
+		found := false
+		for _, p := range problems {
+			found = found || strings.Contains(p, w)
+		}
+		if !found {
+			t.Errorf("expected a problem containing '%s', got %v", w, problems)
+		}
+	}
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	f := newBackupFixture()
+	expectProblems(t, f.d)
+
+	f.ab.Balance = 12
+	expectProblems(t, f.d, "has a balance of 12.00, but its transactions add up to 10.00")
+}
+
+func TestCheckIntegrityAccountReferences(t *testing.T) {
+	f := newBackupFixture()
+	f.d.Transactions = append(f.d.Transactions,
+		f.transaction(f.bob, 1, map[*User]*Account{f.carol: {Id: uuid.Must(uuid.NewV4())}}, nil),
+		f.transaction(f.bob, 1, map[*User]*Account{f.carol: f.ac}, nil),
+	)
+
+	expectProblems(t, f.d, "references an account that doesn't exist", "isn't between its users")
+}
+
+func TestCheckIntegrityDeletedUser(t *testing.T) {
+	f := newBackupFixture()
+
+	// carol settled up and ran /unregister, her account was deleted but her
+	// transactions were kept
+	f.d.Users = []*User{f.alice, f.bob}
+	f.d.Accounts = []*Account{f.ab}
+	expectProblems(t, f.d)
+
+	// accounts are always deleted along with their users
+	f.d.Accounts = append(f.d.Accounts, f.ac)
+	expectProblems(t, f.d, "references a user that doesn't exist", "transactions add up to 0.00")
+}
+
+func TestCheckIntegrityLinkedUser(t *testing.T) {
+	f := newBackupFixture()
+
+	// older versions of /link merged bob into alice, leaving the leg between
+	// them on the account that was dropped
+	f.d.Users = []*User{f.alice, f.carol}
+	f.d.Accounts = []*Account{f.ac}
+	f.d.Transactions[0].Accounts[f.alice.Id] = f.d.Transactions[0].Accounts[f.bob.Id]
+	f.d.Transactions[0].States[f.alice.Id] = TransactionAccepted
+	delete(f.d.Transactions[0].Accounts, f.bob.Id)
+	delete(f.d.Transactions[0].States, f.bob.Id)
+
+	expectProblems(t, f.d)
+}
+
+func TestUpgradeLegacyAmounts(t *testing.T) {
+	f := newBackupFixture()
+
+	// before approvals, transactions stored each subject's share as their amount
+	f.ab.Balance += 4
+	f.ac.Balance += 4
+	legacy := f.transaction(f.alice, 4, map[*User]*Account{f.bob: f.ab, f.carol: f.ac}, nil)
+	f.d.Transactions = append(f.d.Transactions, legacy)
+
+	expectProblems(t, f.d, "transactions add up to 12.00", "transactions add up to 7.00")
+
+	upgradeLegacyAmounts(f.d)
+	expectProblems(t, f.d)
+
+	if legacy.Amount != 8 || legacy.Share() != 4 {
+		t.Errorf("expected an amount of 8 and a share of 4, got %v and %v", legacy.Amount, legacy.Share())
+	}
+	if legacy.State(f.bob.Id) != TransactionAccepted || len(legacy.States) != 2 {
+		t.Errorf("expected every leg to be accepted, got %v", legacy.States)
+	}
+
+	// upgrading twice doesn't change anything
+	upgradeLegacyAmounts(f.d)
+	if legacy.Amount != 8 {
+		t.Errorf("expected upgrading twice to leave the amount at 8, got %v", legacy.Amount)
+	}
+}
+
+func TestCheckIntegrityAfterUnregisterAndLink(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	alice := newTestUser(t, c, "alice")
+	bob := newTestUser(t, c, "bob")
+	carol := newTestUser(t, c, "carol")
+	linked := newOtherPlatformUser(t, c, "alice")
+
+	for _, tr := range []struct {
+		from *User
+		to   *User
+		kind TransactionKind
+	}{
+		{from: alice, to: bob},
+		{from: bob, to: alice, kind: TransactionPayment},
+		{from: carol, to: alice},
+		{from: alice, to: linked},
+		{from: linked, to: alice, kind: TransactionPayment},
+	} {
+		if err := c.ImportTransaction(ctx, &Transaction{Amount: 10, Kind: tr.kind}, tr.from, []User{*tr.to}); err != nil {
+			t.Fatalf("failed to import transaction: %v", err)
+		}
+	}
+
+	if err := c.DeleteUser(ctx, bob); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	if err := c.MergeUsers(ctx, linked, alice); err != nil {
+		t.Fatalf("failed to merge users: %v", err)
+	}
+
+	problems, err := c.CheckIntegrity(ctx)
+	if err != nil {
+		t.Fatalf("failed to check integrity: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("expected no problems after /unregister and /link, got %v", problems)
+	}
+}
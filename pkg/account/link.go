@@ -0,0 +1,361 @@
+package account
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/gofrs/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// linkCodeAlphabet is the characters used in link codes, without any that
+// are easy to confuse with each other
+const linkCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+var (
+	// ErrLinkCodeNotFound is returned when a link code doesn't exist, or has expired
+	ErrLinkCodeNotFound error = errors.New("Link code not found")
+
+	// ErrPlatformConflict is returned when two users can't be merged because
+	// they're both on the same platform
+	ErrPlatformConflict error = errors.New("Users are both on the same platform")
+
+	// ErrMergeBalance is returned when two users can't be merged because they
+	// still owe each other money
+	ErrMergeBalance error = errors.New("Users have an outstanding balance with each other")
+
+	// ErrSharedTransaction is returned when two users can't be merged because
+	// they're both subjects of the same transaction, which can't be split
+	// between them once they're one user
+	ErrSharedTransaction error = errors.New("Users are both subjects of the same transaction")
+)
+
+// LinkCode is a one-time code used to link a user's accounts on two platforms
+type LinkCode struct {
+	// Code is the code the user has to send from their other platform
+	Code string `json:"code" pg:",pk"`
+
+	// UserId is the user that requested this code, and that the user who
+	// redeems it will be merged into
+	UserId uuid.UUID `json:"user_id" pg:"type:uuid,notnull"`
+
+	ExpiresAt time.Time `json:"expires_at" pg:"expires_at,notnull"`
+	CreatedAt time.Time `json:"created_at" pg:"default:now(),notnull"`
+}
+
+// CreateLinkCode creates a new link code for a user that expires after ttl
+func (c *Client) CreateLinkCode(ctx context.Context, u *User, ttl time.Duration) (*LinkCode, error) {
+	// clean up any codes that were never redeemed
+	if _, err := c.db.WithContext(ctx).Model((*LinkCode)(nil)).Where("expires_at < ?", time.Now()).Delete(); err != nil {
+		log.Warnf("failed to delete expired link codes: %v", err)
+	}
+
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	code := make([]byte, len(b))
+	for i := range b {
+		code[i] = linkCodeAlphabet[int(b[i])%len(linkCodeAlphabet)]
+	}
+
+	l := &LinkCode{
+		Code:      string(code),
+		UserId:    u.Id,
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedAt: time.Now(),
+	}
+
+	_, err := c.db.WithContext(ctx).Model(l).Insert()
+	return l, err
+}
+
+// RedeemLinkCode uses up a link code, returning the user that created it
+func (c *Client) RedeemLinkCode(ctx context.Context, code string) (*User, error) {
+	l := &LinkCode{}
+	_, err := c.db.WithContext(ctx).Model(l).
+		Where("code = ?", strings.ToUpper(code)).
+		Returning("*").
+		Delete()
+	if errors.Is(err, pg.ErrNoRows) || (err == nil && l.UserId == uuid.Nil) {
+		return nil, ErrLinkCodeNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(l.ExpiresAt) {
+		return nil, ErrLinkCodeNotFound
+	}
+
+	return c.GetUser(ctx, l.UserId)
+}
+
+// MergeUsers merges the user from into the user into, moving over all of their
+// platforms, accounts, and transactions before deleting from. Accounts that both
+// users have with the same person are collapsed into one account. The account
+// between the two users is dropped, along with the legs of any transactions
+// between them, so it has to be settled first. Everything happens in a single
+// database transaction.
+func (c *Client) MergeUsers(ctx context.Context, into *User, from *User) error {
+	if into.Id == from.Id {
+		return nil
+	}
+
+	for p := range from.PlatformIds {
+		if _, ok := into.PlatformIds[p]; ok {
+			return ErrPlatformConflict
+		}
+	}
+
+	err := c.db.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		between, err := c.findAccountBetween(tx, into, from)
+		if err == nil && between.Balance != 0 {
+			return ErrMergeBalance
+		} else if err != nil && err != ErrAccountNotFound {
+			return err
+		}
+
+		// a transaction can only have one leg per user
+		n, err := tx.Model((*Transaction)(nil)).
+			Where("accounts->>? != '' AND accounts->>? != ''", from.Id, into.Id).
+			Count()
+		if err != nil {
+			return err
+		}
+		if n != 0 {
+			return ErrSharedTransaction
+		}
+
+		var accts []*Account
+		err = tx.Model(&accts).
+			Where("creator_id = ? OR subject_id = ?", from.Id, from.Id).
+			For("UPDATE").
+			Select()
+		if err != nil {
+			return fmt.Errorf("failed to list accounts: %v", err)
+		}
+
+		// accountIds maps accounts that were collapsed into the account that replaced them
+		accountIds := make(map[uuid.UUID]uuid.UUID)
+		for _, a := range accts {
+			other := a.CreatorId
+			if other == from.Id {
+				other = a.SubjectId
+			}
+
+			// an account between the two users can't exist once they're the same
+			// user, it was settled so nothing is lost
+			if other == into.Id {
+				if _, err := tx.Model(a).WherePK().Delete(); err != nil {
+					return fmt.Errorf("failed to delete account %s: %v", a.Id, err)
+				}
+				continue
+			}
+
+			existing, err := c.findAccountBetween(tx, into, &User{Id: other})
+			if err == ErrAccountNotFound {
+				if a.CreatorId == from.Id {
+					a.CreatorId = into.Id
+				} else {
+					a.SubjectId = into.Id
+				}
+				a.UpdatedAt = time.Now()
+
+				if _, err := tx.Model(a).Column("creator_id", "subject_id", "updated_at").WherePK().Update(); err != nil {
+					return fmt.Errorf("failed to move account %s: %v", a.Id, err)
+				}
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			// balances are relative to the creator, so normalize this account's
+			// balance to what the other user owes us before collapsing it
+			owed := a.Balance
+			if a.SubjectId == from.Id {
+				owed = -owed
+			}
+			if existing.CreatorId != into.Id {
+				owed = -owed
+			}
+
+			if err := addBalance(tx, existing, owed); err != nil {
+				return fmt.Errorf("failed to collapse account %s: %v", a.Id, err)
+			}
+			if _, err := tx.Model(a).WherePK().Delete(); err != nil {
+				return fmt.Errorf("failed to delete account %s: %v", a.Id, err)
+			}
+			accountIds[a.Id] = existing.Id
+		}
+
+		var trans []*Transaction
+		err = tx.Model(&trans).
+			Where("accounts->>? != '' OR created_by = ?", from.Id, from.Id).
+			For("UPDATE").
+			Select()
+		if err != nil {
+			return fmt.Errorf("failed to list transactions: %v", err)
+		}
+
+		for _, t := range trans {
+			if t.CreatedBy == from.Id {
+				t.CreatedBy = into.Id
+			}
+
+			if aid, ok := t.Accounts[from.Id]; ok {
+				delete(t.Accounts, from.Id)
+				t.Accounts[into.Id] = aid
+			}
+
+			if state, ok := t.States[from.Id]; ok {
+				delete(t.States, from.Id)
+				t.States[into.Id] = state
+			}
+
+			// the leg between the two users was on the dropped account, keep
+			// everyone else's share of the transaction the same without it
+			if _, ok := t.Accounts[into.Id]; ok && t.CreatedBy == into.Id {
+				t.Amount -= t.Share()
+				delete(t.Accounts, into.Id)
+				delete(t.States, into.Id)
+
+				if len(t.Accounts) == 0 {
+					if _, err := tx.Model(t).WherePK().Delete(); err != nil {
+						return fmt.Errorf("failed to delete transaction %s: %v", t.Id, err)
+					}
+					continue
+				}
+			}
+
+			for uid, aid := range t.Accounts {
+				if newID, ok := accountIds[aid]; ok {
+					t.Accounts[uid] = newID
+				}
+			}
+
+			if _, err := tx.Model(t).Column("created_by", "accounts", "states", "amount").WherePK().Update(); err != nil {
+				return fmt.Errorf("failed to update transaction %s: %v", t.Id, err)
+			}
+		}
+
+		// transactions created by other users can still reference a collapsed account
+		for oldID, newID := range accountIds {
+			_, err := tx.Exec(`
+				UPDATE transactions SET accounts = (
+					SELECT jsonb_object_agg(key, CASE WHEN value = to_jsonb(?::text) THEN to_jsonb(?::text) ELSE value END)
+					FROM jsonb_each(accounts)
+				) WHERE accounts::text LIKE ?`, oldID.String(), newID.String(), "%"+oldID.String()+"%")
+			if err != nil {
+				return fmt.Errorf("failed to remap account %s: %v", oldID, err)
+			}
+		}
+
+		var recurring []*RecurringTransaction
+		if err := tx.Model(&recurring).Select(); err != nil {
+			return fmt.Errorf("failed to list recurring transactions: %v", err)
+		}
+		for _, r := range recurring {
+			changed := r.CreatedBy == from.Id
+			if changed {
+				r.CreatedBy = into.Id
+			}
+
+			subjects := make([]uuid.UUID, 0, len(r.Subjects))
+			for _, id := range r.Subjects {
+				if id == from.Id {
+					id = into.Id
+					changed = true
+				}
+
+				for _, s := range subjects {
+					if s == id {
+						return ErrSharedTransaction
+					}
+				}
+				subjects = append(subjects, id)
+			}
+
+			if !changed {
+				continue
+			}
+
+			// like transactions, drop the subject that's now the creator
+			r.Subjects = subjects
+			r.removeSubject(r.CreatedBy)
+			r.UpdatedAt = time.Now()
+
+			if len(r.Subjects) == 0 {
+				if _, err := tx.Model(r).WherePK().Delete(); err != nil {
+					return fmt.Errorf("failed to delete recurring transaction %s: %v", r.Id, err)
+				}
+				continue
+			}
+			if _, err := tx.Model(r).Column("created_by", "subjects", "amount", "updated_at").WherePK().Update(); err != nil {
+				return fmt.Errorf("failed to update recurring transaction %s: %v", r.Id, err)
+			}
+		}
+
+		_, err = tx.Model((*StatementSubscription)(nil)).
+			Set("user_id = ?", into.Id).
+			Where("user_id = ?", from.Id).
+			Update()
+		if err != nil {
+			return fmt.Errorf("failed to update statements: %v", err)
+		}
+
+		_, err = tx.Model((*UsernameHistory)(nil)).
+			Set("user_id = ?", into.Id).
+			Where("user_id = ?", from.Id).
+			Update()
+		if err != nil {
+			return fmt.Errorf("failed to update username history: %v", err)
+		}
+
+		_, err = tx.Model((*APIToken)(nil)).
+			Set("user_id = ?", into.Id).
+			Where("user_id = ?", from.Id).
+			Update()
+		if err != nil {
+			return fmt.Errorf("failed to update api tokens: %v", err)
+		}
+
+		_, err = tx.Model((*Session)(nil)).
+			Set("user_id = ?", into.Id).
+			Where("user_id = ?", from.Id).
+			Update()
+		if err != nil {
+			return fmt.Errorf("failed to update sessions: %v", err)
+		}
+
+		if _, err := tx.Model(from).WherePK().Delete(); err != nil {
+			return fmt.Errorf("failed to delete merged user: %v", err)
+		}
+
+		for p, id := range from.PlatformIds {
+			into.PlatformIds[p] = id
+		}
+		for p, username := range from.PlatformUsernames {
+			if into.PlatformUsernames == nil {
+				into.PlatformUsernames = make(map[PlatformName]string)
+			}
+			into.PlatformUsernames[p] = username
+		}
+		into.UpdatedAt = time.Now()
+
+		_, err = tx.Model(into).Column("platform_ids", "platform_usernames", "updated_at").WherePK().Update()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	c.userChanged(from)
+	c.userChanged(into)
+	return nil
+}
@@ -0,0 +1,124 @@
+package account
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofrs/uuid"
+)
+
+// newOtherPlatformUser creates a user that is only on another platform, so
+// telegram users can be merged into it
+func newOtherPlatformUser(t *testing.T, c *Client, name string) *User {
+	t.Helper()
+
+	u := &User{
+		PlatformIds:       map[PlatformName]string{"other": name},
+		PlatformUsernames: map[PlatformName]string{"other": name},
+	}
+	if err := c.CreateUser(context.Background(), u); err != nil {
+		t.Fatalf("failed to create user %s: %v", name, err)
+	}
+
+	return u
+}
+
+func TestMergeUsersRefusesBalanceBetweenThem(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	into := newOtherPlatformUser(t, c, "alice")
+	from := newTestUser(t, c, "alice")
+
+	if err := c.ImportTransaction(ctx, &Transaction{Amount: 10}, into, []User{*from}); err != nil {
+		t.Fatalf("failed to import transaction: %v", err)
+	}
+
+	if err := c.MergeUsers(ctx, into, from); err != ErrMergeBalance {
+		t.Fatalf("expected ErrMergeBalance, got %v", err)
+	}
+
+	// settling up allows them to be merged
+	if err := c.ImportTransaction(ctx, &Transaction{Amount: 10, Kind: TransactionPayment}, from, []User{*into}); err != nil {
+		t.Fatalf("failed to import payment: %v", err)
+	}
+	if err := c.MergeUsers(ctx, into, from); err != nil {
+		t.Fatalf("failed to merge settled users: %v", err)
+	}
+
+	// transactions that were only between them are gone, along with their account
+	trans, err := c.GetAllTransactionsByUser(ctx, into, nil)
+	if err != nil {
+		t.Fatalf("failed to list transactions: %v", err)
+	}
+	if len(trans) != 0 {
+		t.Errorf("expected transactions between the merged users to be removed, got %v", trans)
+	}
+	if _, err := c.FindAccountBetween(ctx, into, into); err != ErrAccountNotFound {
+		t.Errorf("expected no account between the merged user and themselves, got %v", err)
+	}
+}
+
+func TestMergeUsersRefusesSharedTransaction(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	into := newOtherPlatformUser(t, c, "alice")
+	from := newTestUser(t, c, "alice")
+	bob := newTestUser(t, c, "bob")
+
+	if err := c.CreateTransaction(ctx, &Transaction{Amount: 30}, bob, []User{*into, *from}); err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+
+	if err := c.MergeUsers(ctx, into, from); err != ErrSharedTransaction {
+		t.Fatalf("expected ErrSharedTransaction, got %v", err)
+	}
+
+	// nothing was merged
+	if _, err := c.GetUser(ctx, from.Id); err != nil {
+		t.Errorf("expected user to still exist after a refused merge: %v", err)
+	}
+}
+
+func TestMergeUsersKeepsOtherShares(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	into := newOtherPlatformUser(t, c, "alice")
+	from := newTestUser(t, c, "alice")
+	bob := newTestUser(t, c, "bob")
+
+	tr := &Transaction{Amount: 20}
+	if err := c.CreateTransaction(ctx, tr, into, []User{*from, *bob}); err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	if err := c.ImportTransaction(ctx, &Transaction{Amount: 6}, from, []User{*bob}); err != nil {
+		t.Fatalf("failed to import transaction: %v", err)
+	}
+
+	if err := c.MergeUsers(ctx, into, from); err != nil {
+		t.Fatalf("failed to merge users: %v", err)
+	}
+
+	merged, err := c.GetTransaction(ctx, tr.Id.String())
+	if err != nil {
+		t.Fatalf("failed to get transaction: %v", err)
+	}
+	if len(merged.Accounts) != 1 || merged.Accounts[bob.Id] == uuid.Nil {
+		t.Fatalf("expected only bob's leg to be left, got %v", merged.Accounts)
+	}
+	if merged.Share() != 10 {
+		t.Errorf("expected bob's share to stay at 10, got %v", merged.Share())
+	}
+	if _, ok := merged.States[into.Id]; ok {
+		t.Errorf("expected the merged user's leg to be removed, got states %v", merged.States)
+	}
+
+	if err := c.AcceptTransaction(ctx, merged, bob); err != nil {
+		t.Fatalf("failed to accept transaction: %v", err)
+	}
+	if got := balance(t, c, into, bob); got != 16 {
+		t.Errorf("expected bob to owe the merged user 16, got %v", got)
+	}
+}
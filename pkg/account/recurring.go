@@ -0,0 +1,223 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/gofrs/uuid"
+)
+
+// Frequency is how often a recurring transaction runs
+type Frequency string
+
+const (
+	FrequencyDaily   Frequency = "daily"
+	FrequencyWeekly  Frequency = "weekly"
+	FrequencyMonthly Frequency = "monthly"
+)
+
+// ErrRecurringNotFound is returned when a recurring transaction doesn't exist
+var ErrRecurringNotFound error = errors.New("Recurring transaction not found")
+
+// RecurringTransaction is a transaction that is created on a schedule
+type RecurringTransaction struct {
+	// Id of this recurring transaction
+	Id uuid.UUID `json:"id" pg:",pk,type:uuid,default:uuid_generate_v4()"`
+
+	// CreatedBy is the user who created this recurring transaction, and who
+	// every transaction it creates is created by
+	CreatedBy uuid.UUID `json:"created_by" pg:"created_by,type:uuid,notnull"`
+
+	// Subjects are the users that are charged every time this runs
+	Subjects []uuid.UUID `json:"subjects" pg:"subjects,notnull"`
+
+	// Amount is the amount of every transaction, split across all subjects
+	Amount float64 `json:"amount" pg:"amount"`
+
+	// Description is used as the description of every transaction
+	Description string `json:"description,omitempty" pg:"description"`
+
+	// Frequency is how often this runs
+	Frequency Frequency `json:"frequency" pg:"frequency,notnull"`
+
+	// Day is the day of the week (0 is Sunday) for weekly transactions, or
+	// the day of the month for monthly transactions. Days past the end of a
+	// month run on the last day of that month.
+	Day int `json:"day" pg:"day,use_zero"`
+
+	// PlatformName and ChatID are the chat this was created in, and where
+	// messages are posted when it runs
+	PlatformName PlatformName `json:"platform_name" pg:"platform_name,notnull"`
+	ChatID       string       `json:"chat_id" pg:"chat_id,notnull"`
+
+	// Paused recurring transactions are skipped until they're resumed
+	Paused bool `json:"paused" pg:"paused,use_zero"`
+
+	// NextRunAt is when the next occurrence of this recurring transaction is
+	NextRunAt time.Time `json:"next_run_at" pg:"next_run_at,notnull"`
+
+	// LastRunAt is the last occurrence that was run
+	LastRunAt time.Time `json:"last_run_at,omitempty" pg:"last_run_at"`
+
+	CreatedAt time.Time `json:"created_at" pg:"default:now(),notnull"`
+	UpdatedAt time.Time `json:"updated_at" pg:"default:now(),notnull"`
+}
+
+func (r *RecurringTransaction) String() string {
+	return fmt.Sprintf("RecurringTransaction<ID: %s, Amount: %v, Frequency: %s, Day: %d, NextRunAt: %s>", r.Id, r.Amount, r.Frequency, r.Day, r.NextRunAt)
+}
+
+// ShortID returns a short, human typeable, version of this recurring transaction's ID
+func (r *RecurringTransaction) ShortID() string {
+	return strings.SplitN(r.Id.String(), "-", 2)[0]
+}
+
+// removeSubject removes a user from the subjects of this recurring transaction,
+// lowering the amount so every other subject's share stays the same. Returns if
+// the user was a subject.
+func (r *RecurringTransaction) removeSubject(id uuid.UUID) bool {
+	for i, s := range r.Subjects {
+		if s == id {
+			r.Amount -= r.Amount / float64(len(r.Subjects))
+			r.Subjects = append(r.Subjects[:i], r.Subjects[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Next returns the first occurrence of this recurring transaction after t
+func (r *RecurringTransaction) Next(t time.Time) time.Time {
+	return NextOccurrence(r.Frequency, r.Day, t)
+}
+
+// NextOccurrence returns the first occurrence of a schedule after t. Day is the
+// day of the week (0 is Sunday) for weekly schedules, or the day of the month for
+// monthly schedules. Occurrences are always at midnight UTC.
+func NextOccurrence(f Frequency, d int, t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch f {
+	case FrequencyWeekly:
+		next := day.AddDate(0, 0, 1)
+		for int(next.Weekday()) != d%7 {
+			next = next.AddDate(0, 0, 1)
+		}
+		return next
+	case FrequencyMonthly:
+		next := dayOfMonth(t.Year(), t.Month(), d)
+		if !next.After(t) {
+			next = dayOfMonth(t.Year(), t.Month()+1, d)
+		}
+		return next
+	default:
+		return day.AddDate(0, 0, 1)
+	}
+}
+
+// dayOfMonth returns the day of the month, or the last day of the month if
+// the month doesn't have that many days
+func dayOfMonth(year int, month time.Month, day int) time.Time {
+	// the zeroth day of the next month is the last day of this month
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if day > last {
+		day = last
+	}
+	if day < 1 {
+		day = 1
+	}
+
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// CreateRecurring creates a recurring transaction, scheduling its first run
+func (c *Client) CreateRecurring(ctx context.Context, r *RecurringTransaction) error {
+	if r.NextRunAt.IsZero() {
+		r.NextRunAt = r.Next(time.Now())
+	}
+
+	_, err := c.db.WithContext(ctx).Model(r).Insert()
+	return err
+}
+
+// ListRecurring returns all of the recurring transactions created by a user
+func (c *Client) ListRecurring(ctx context.Context, u *User) ([]*RecurringTransaction, error) {
+	rs := []*RecurringTransaction{}
+	err := c.db.WithContext(ctx).Model(&rs).
+		Where("created_by = ?", u.Id).
+		Order("created_at ASC").
+		Select()
+
+	return rs, err
+}
+
+// GetDueRecurring returns all of the recurring transactions that are due to run at t
+func (c *Client) GetDueRecurring(ctx context.Context, t time.Time) ([]*RecurringTransaction, error) {
+	rs := []*RecurringTransaction{}
+	err := c.db.WithContext(ctx).Model(&rs).
+		Where("paused = false").
+		Where("next_run_at <= ?", t).
+		Order("next_run_at ASC").
+		Select()
+
+	return rs, err
+}
+
+// GetRecurring returns a recurring transaction
+func (c *Client) GetRecurring(ctx context.Context, id uuid.UUID) (*RecurringTransaction, error) {
+	r := &RecurringTransaction{}
+	err := c.db.WithContext(ctx).Model(r).
+		Where("id = ?", id).
+		Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, ErrRecurringNotFound
+	}
+
+	return r, err
+}
+
+// AdvanceRecurring marks an occurrence of a recurring transaction as run and
+// schedules the next one. Only advances if no one else has advanced it already.
+func (c *Client) AdvanceRecurring(ctx context.Context, r *RecurringTransaction, ran time.Time) error {
+	next := r.Next(ran)
+	_, err := c.db.WithContext(ctx).Model(r).
+		Set("last_run_at = ?", ran).
+		Set("next_run_at = ?", next).
+		Set("updated_at = ?", time.Now()).
+		Where("id = ?", r.Id).
+		Where("next_run_at = ?", r.NextRunAt).
+		Update()
+	if err != nil {
+		return err
+	}
+
+	r.LastRunAt = ran
+	r.NextRunAt = next
+	return nil
+}
+
+// SetRecurringPaused pauses, or resumes, a recurring transaction. Resuming
+// skips any occurrences that were missed while it was paused.
+func (c *Client) SetRecurringPaused(ctx context.Context, r *RecurringTransaction, paused bool) error {
+	r.Paused = paused
+	r.UpdatedAt = time.Now()
+	if !paused && !r.NextRunAt.After(time.Now()) {
+		r.NextRunAt = r.Next(time.Now())
+	}
+
+	_, err := c.db.WithContext(ctx).Model(r).Column("paused", "next_run_at", "updated_at").WherePK().Update()
+	return err
+}
+
+// DeleteRecurring deletes a recurring transaction, transactions it has already
+// created are kept
+func (c *Client) DeleteRecurring(ctx context.Context, r *RecurringTransaction) error {
+	_, err := c.db.WithContext(ctx).Model(r).WherePK().Delete()
+	return err
+}
@@ -0,0 +1,73 @@
+package account
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestRemoveSubject(t *testing.T) {
+	a, b, c := uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())
+	r := &RecurringTransaction{Subjects: []uuid.UUID{a, b, c}, Amount: 30}
+
+	if r.removeSubject(uuid.Must(uuid.NewV4())) {
+		t.Errorf("expected removing a user that isn't a subject to do nothing")
+	}
+	if !r.removeSubject(b) {
+		t.Fatalf("expected subject to be removed")
+	}
+	if len(r.Subjects) != 2 || r.Subjects[0] != a || r.Subjects[1] != c {
+		t.Errorf("expected subjects [%s %s], got %v", a, c, r.Subjects)
+	}
+	if r.Amount != 20 {
+		t.Errorf("expected everyone else's share to stay at 10, amount is %v", r.Amount)
+	}
+
+	r.removeSubject(a)
+	r.removeSubject(c)
+	if len(r.Subjects) != 0 || r.Amount != 0 {
+		t.Errorf("expected no subjects and no amount, got %v and %v", r.Subjects, r.Amount)
+	}
+}
+
+func TestNextOccurrence(t *testing.T) {
+	date := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	pst := time.FixedZone("PST", -8*60*60)
+
+	tests := []struct {
+		name string
+		f    Frequency
+		day  int
+		t    time.Time
+		want time.Time
+	}{
+		{name: "daily", f: FrequencyDaily, t: date(2021, 1, 31, 12), want: date(2021, 2, 1, 0)},
+		{name: "daily at midnight", f: FrequencyDaily, t: date(2021, 12, 31, 0), want: date(2022, 1, 1, 0)},
+		{name: "daily in another timezone", f: FrequencyDaily, t: time.Date(2021, 1, 31, 20, 0, 0, 0, pst), want: date(2021, 2, 2, 0)},
+
+		// 2021-01-06 is a Wednesday
+		{name: "weekly later this week", f: FrequencyWeekly, day: int(time.Friday), t: date(2021, 1, 6, 12), want: date(2021, 1, 8, 0)},
+		{name: "weekly on the same day", f: FrequencyWeekly, day: int(time.Wednesday), t: date(2021, 1, 6, 0), want: date(2021, 1, 13, 0)},
+		{name: "weekly wraps to next week", f: FrequencyWeekly, day: int(time.Monday), t: date(2021, 1, 6, 12), want: date(2021, 1, 11, 0)},
+		{name: "weekly wraps from saturday to sunday", f: FrequencyWeekly, day: int(time.Sunday), t: date(2021, 1, 9, 23), want: date(2021, 1, 10, 0)},
+		{name: "weekly wraps across a year", f: FrequencyWeekly, day: int(time.Monday), t: date(2020, 12, 30, 12), want: date(2021, 1, 4, 0)},
+
+		{name: "monthly later this month", f: FrequencyMonthly, day: 15, t: date(2021, 1, 10, 12), want: date(2021, 1, 15, 0)},
+		{name: "monthly on the same day", f: FrequencyMonthly, day: 15, t: date(2021, 1, 15, 0), want: date(2021, 2, 15, 0)},
+		{name: "monthly later on the same day", f: FrequencyMonthly, day: 15, t: date(2021, 1, 15, 12), want: date(2021, 2, 15, 0)},
+		{name: "monthly clamps to february", f: FrequencyMonthly, day: 31, t: date(2021, 1, 31, 0), want: date(2021, 2, 28, 0)},
+		{name: "monthly clamps to a leap day", f: FrequencyMonthly, day: 31, t: date(2024, 1, 31, 0), want: date(2024, 2, 29, 0)},
+		{name: "monthly after a clamped month", f: FrequencyMonthly, day: 31, t: date(2021, 2, 28, 0), want: date(2021, 3, 31, 0)},
+		{name: "monthly clamps to a 30 day month", f: FrequencyMonthly, day: 31, t: date(2021, 4, 1, 0), want: date(2021, 4, 30, 0)},
+		{name: "monthly wraps across a year", f: FrequencyMonthly, day: 5, t: date(2021, 12, 20, 0), want: date(2022, 1, 5, 0)},
+	}
+
+	for _, tt := range tests {
+		if got := NextOccurrence(tt.f, tt.day, tt.t); !got.Equal(tt.want) {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
+	log "github.com/sirupsen/logrus"
+)
+
+// models are all of the tables that are managed by this package
+var models = []interface{}{
+	(*User)(nil),
+	(*Account)(nil),
+	(*Transaction)(nil),
+	(*RecurringTransaction)(nil),
+	(*StatementSubscription)(nil),
+	(*LinkCode)(nil),
+	(*UsernameHistory)(nil),
+	(*APIToken)(nil),
+	(*Session)(nil),
+}
+
+// migrations are applied, in order, after all of the models have been created.
+// Tables created by CreateTable won't get new columns, so any column added to an
+// existing model needs to be added here. Never reorder or remove a migration.
+var migrations = []string{
+	`ALTER TABLE transactions ADD COLUMN IF NOT EXISTS states jsonb`,
+	`ALTER TABLE transactions ADD COLUMN IF NOT EXISTS description text`,
+	`ALTER TABLE transactions ADD COLUMN IF NOT EXISTS recurring_id uuid`,
+	`ALTER TABLE transactions ADD COLUMN IF NOT EXISTS scheduled_for timestamptz`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS transactions_recurring_occurrence ON transactions (recurring_id, scheduled_for)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS reminders_disabled boolean NOT NULL DEFAULT false`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS quiet_hours_start bigint NOT NULL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS quiet_hours_end bigint NOT NULL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone text`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS last_reminded_at timestamptz`,
+	`ALTER TABLE transactions ADD COLUMN IF NOT EXISTS platform_name text`,
+	`ALTER TABLE transactions ADD COLUMN IF NOT EXISTS chat_id text`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS placeholder boolean NOT NULL DEFAULT false`,
+	`ALTER TABLE transactions ADD COLUMN IF NOT EXISTS kind text`,
+	`ALTER TABLE transactions ADD COLUMN IF NOT EXISTS import_id text`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS transactions_import_id ON transactions (import_id)`,
+
+	// transactions from before approvals existed stored each subject's share as
+	// their amount, convert them to the full amount with every leg accepted
+	`UPDATE transactions SET
+		amount = amount * (SELECT count(*) FROM jsonb_object_keys(accounts)),
+		states = (SELECT jsonb_object_agg(k, '"accepted"'::jsonb) FROM jsonb_object_keys(accounts) AS k)
+	WHERE states IS NULL AND accounts IS NOT NULL AND accounts != '{}'::jsonb`,
+}
+
+// legacyAmountsVersion is the migration that converted the amounts of
+// transactions from before approvals existed
+const legacyAmountsVersion = 17
+
+// schemaMigration records that a migration has been applied
+type schemaMigration struct {
+	Version   int       `pg:",pk"`
+	AppliedAt time.Time `pg:"default:now(),notnull"`
+}
+
+// Migrate creates all of the tables that are required and applies any
+// migrations that haven't been applied yet
+func (c *Client) Migrate(ctx context.Context) error {
+	if _, err := c.db.WithContext(ctx).Exec(`CREATE EXTENSION IF NOT EXISTS "uuid-ossp";`); err != nil {
+		return fmt.Errorf("failed to create extensions: %v", err)
+	}
+
+	for _, model := range append(models, (*schemaMigration)(nil)) {
+		err := c.db.WithContext(ctx).CreateTable(model, &orm.CreateTableOptions{
+			Temp:        false,
+			IfNotExists: true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create table for %T: %v", model, err)
+		}
+	}
+
+	for i, m := range migrations {
+		version := i + 1
+		err := c.db.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+			n, err := tx.Model((*schemaMigration)(nil)).Where("version = ?", version).Count()
+			if err != nil || n != 0 {
+				return err
+			}
+
+			log.Infof("applying migration %d", version)
+			if _, err := tx.Exec(m); err != nil {
+				return err
+			}
+
+			_, err = tx.Model(&schemaMigration{Version: version, AppliedAt: time.Now()}).Insert()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d: %v", version, err)
+		}
+	}
+
+	return nil
+}
+
+// Ping checks that the database can be reached
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.db.WithContext(ctx).Exec("SELECT 1")
+	return err
+}
+
+// CheckMigrations returns an error if any migrations haven't been applied
+func (c *Client) CheckMigrations(ctx context.Context) error {
+	n, err := c.db.WithContext(ctx).Model((*schemaMigration)(nil)).Count()
+	if err != nil {
+		return err
+	}
+
+	if n < len(migrations) {
+		return fmt.Errorf("%d of %d migrations applied", n, len(migrations))
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/gofrs/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// SessionKind is what a session token can be used for
+type SessionKind string
+
+const (
+	// SessionLogin is a one-time token sent to a user to log in with
+	SessionLogin SessionKind = "login"
+
+	// SessionWeb is a token that a logged in browser holds
+	SessionWeb SessionKind = "web"
+)
+
+// ErrSessionNotFound is returned when a session doesn't exist, or has expired
+var ErrSessionNotFound error = errors.New("Session not found")
+
+// Session is a token that authenticates a user on the web. Only a hash of the
+// token is stored.
+type Session struct {
+	// Hash is the SHA256 of the token
+	Hash string `json:"-" pg:",pk"`
+
+	Kind SessionKind `json:"kind" pg:"kind,notnull"`
+
+	// UserId is the user this session authenticates as
+	UserId uuid.UUID `json:"user_id" pg:"type:uuid,notnull"`
+
+	ExpiresAt time.Time `json:"expires_at" pg:"expires_at,notnull"`
+	CreatedAt time.Time `json:"created_at" pg:"default:now(),notnull"`
+}
+
+// CreateSession creates a new session of kind for a user that expires after
+// ttl, the token is only ever returned here
+func (c *Client) CreateSession(ctx context.Context, u *User, kind SessionKind, ttl time.Duration) (string, error) {
+	// clean up any sessions that have expired
+	if _, err := c.db.WithContext(ctx).Model((*Session)(nil)).Where("expires_at < ?", time.Now()).Delete(); err != nil {
+		log.Warnf("failed to delete expired sessions: %v", err)
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	s := &Session{
+		Hash:      hashToken(token),
+		Kind:      kind,
+		UserId:    u.Id,
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedAt: time.Now(),
+	}
+
+	_, err = c.db.WithContext(ctx).Model(s).Insert()
+	return token, err
+}
+
+// RedeemLoginSession uses up a login token, returning the user it was sent to
+func (c *Client) RedeemLoginSession(ctx context.Context, token string) (*User, error) {
+	s := &Session{}
+	_, err := c.db.WithContext(ctx).Model(s).
+		Where("hash = ?", hashToken(token)).
+		Where("kind = ?", SessionLogin).
+		Returning("*").
+		Delete()
+	if errors.Is(err, pg.ErrNoRows) || (err == nil && s.UserId == uuid.Nil) {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(s.ExpiresAt) {
+		return nil, ErrSessionNotFound
+	}
+
+	return c.GetUser(ctx, s.UserId)
+}
+
+// FindUserBySession returns the user that a web session belongs to
+func (c *Client) FindUserBySession(ctx context.Context, token string) (*User, error) {
+	s := &Session{}
+	err := c.db.WithContext(ctx).Model(s).
+		Where("hash = ?", hashToken(token)).
+		Where("kind = ?", SessionWeb).
+		Where("expires_at > ?", time.Now()).
+		Limit(1).
+		Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return c.GetUser(ctx, s.UserId)
+}
+
+// DeleteSession deletes a session, logging it out
+func (c *Client) DeleteSession(ctx context.Context, token string) error {
+	_, err := c.db.WithContext(ctx).Model((*Session)(nil)).Where("hash = ?", hashToken(token)).Delete()
+	return err
+}
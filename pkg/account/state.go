@@ -0,0 +1,60 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TransactionState is the state of a single user's leg of a transaction
+type TransactionState string
+
+const (
+	// TransactionPending is a leg that is waiting on the subject to approve it
+	TransactionPending TransactionState = "pending"
+
+	// TransactionAccepted is a leg that the subject has approved, only accepted
+	// legs are reflected in an account's balance
+	TransactionAccepted TransactionState = "accepted"
+
+	// TransactionDisputed is a leg that the subject has disputed
+	TransactionDisputed TransactionState = "disputed"
+
+	// TransactionVoided is a leg that the creator has voided, if it was
+	// accepted then it has been reversed
+	TransactionVoided TransactionState = "voided"
+)
+
+var (
+	// ErrInvalidTransition is returned when a transaction leg can't move into the requested state
+	ErrInvalidTransition error = errors.New("Invalid transaction state transition")
+
+	// ErrNotInvolved is returned when a user isn't a subject of a transaction
+	ErrNotInvolved error = errors.New("User is not involved in this transaction")
+)
+
+// transitions is a map of a state to the states it's allowed to move into
+var transitions = map[TransactionState][]TransactionState{
+	TransactionPending:  {TransactionAccepted, TransactionDisputed, TransactionVoided},
+	TransactionDisputed: {TransactionAccepted, TransactionVoided},
+	TransactionAccepted: {TransactionVoided},
+}
+
+// CanTransition returns if a leg in state s is allowed to move into state to
+func (s TransactionState) CanTransition(to TransactionState) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Transition returns the next state of a leg, or an error if it's not allowed
+func (s TransactionState) Transition(to TransactionState) (TransactionState, error) {
+	if !s.CanTransition(to) {
+		return s, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, s, to)
+	}
+
+	return to, nil
+}
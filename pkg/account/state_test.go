@@ -0,0 +1,60 @@
+package account
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	states := []TransactionState{TransactionPending, TransactionAccepted, TransactionDisputed, TransactionVoided}
+	allowed := map[TransactionState]map[TransactionState]bool{
+		TransactionPending:  {TransactionAccepted: true, TransactionDisputed: true, TransactionVoided: true},
+		TransactionDisputed: {TransactionAccepted: true, TransactionVoided: true},
+		TransactionAccepted: {TransactionVoided: true},
+		TransactionVoided:   {},
+	}
+
+	for _, from := range states {
+		for _, to := range states {
+			if got, want := from.CanTransition(to), allowed[from][to]; got != want {
+				t.Errorf("%s -> %s: expected CanTransition to be %v, got %v", from, to, want, got)
+			}
+		}
+	}
+}
+
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		from    TransactionState
+		to      TransactionState
+		want    TransactionState
+		invalid bool
+	}{
+		{from: TransactionPending, to: TransactionAccepted, want: TransactionAccepted},
+		{from: TransactionPending, to: TransactionDisputed, want: TransactionDisputed},
+		{from: TransactionPending, to: TransactionVoided, want: TransactionVoided},
+		{from: TransactionDisputed, to: TransactionAccepted, want: TransactionAccepted},
+		{from: TransactionDisputed, to: TransactionVoided, want: TransactionVoided},
+		{from: TransactionAccepted, to: TransactionVoided, want: TransactionVoided},
+		{from: TransactionPending, to: TransactionPending, want: TransactionPending, invalid: true},
+		{from: TransactionDisputed, to: TransactionDisputed, want: TransactionDisputed, invalid: true},
+		{from: TransactionAccepted, to: TransactionDisputed, want: TransactionAccepted, invalid: true},
+		{from: TransactionAccepted, to: TransactionAccepted, want: TransactionAccepted, invalid: true},
+		{from: TransactionVoided, to: TransactionAccepted, want: TransactionVoided, invalid: true},
+		{from: TransactionVoided, to: TransactionVoided, want: TransactionVoided, invalid: true},
+		{from: "", to: TransactionAccepted, want: "", invalid: true},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.from.Transition(tt.to)
+		if got != tt.want {
+			t.Errorf("%s -> %s: expected state '%s', got '%s'", tt.from, tt.to, tt.want, got)
+		}
+		if tt.invalid && !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("%s -> %s: expected ErrInvalidTransition, got %v", tt.from, tt.to, err)
+		}
+		if !tt.invalid && err != nil {
+			t.Errorf("%s -> %s: unexpected error: %v", tt.from, tt.to, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// StatementSubscription is a chat that is sent a periodic statement. Statements
+// sent to a group summarize transactions created in that group, statements sent
+// to a user summarize all of their transactions.
+type StatementSubscription struct {
+	// Id of this subscription
+	Id uuid.UUID `json:"id" pg:",pk,type:uuid,default:uuid_generate_v4()"`
+
+	// PlatformName and ChatID are the chat this statement is sent to
+	PlatformName PlatformName `json:"platform_name" pg:"platform_name,notnull,unique:chat"`
+	ChatID       string       `json:"chat_id" pg:"chat_id,notnull,unique:chat"`
+
+	// UserId is the user this statement is for, or nil if it's for a group
+	UserId *uuid.UUID `json:"user_id,omitempty" pg:"user_id,type:uuid"`
+
+	// Frequency is how often this statement is sent, either weekly or monthly
+	Frequency Frequency `json:"frequency" pg:"frequency,notnull"`
+
+	// LastSentAt is when the last statement was sent, the next statement
+	// covers everything since then
+	LastSentAt time.Time `json:"last_sent_at" pg:"last_sent_at,notnull"`
+
+	// NextRunAt is when the next statement will be sent
+	NextRunAt time.Time `json:"next_run_at" pg:"next_run_at,notnull"`
+
+	CreatedAt time.Time `json:"created_at" pg:"default:now(),notnull"`
+}
+
+func (s *StatementSubscription) String() string {
+	return fmt.Sprintf("StatementSubscription<ID: %s, Chat: %s:%s, Frequency: %s>", s.Id, s.PlatformName, s.ChatID, s.Frequency)
+}
+
+// Next returns when the statement after t should be sent, weekly statements are
+// sent on Mondays and monthly statements on the first of the month
+func (s *StatementSubscription) Next(t time.Time) time.Time {
+	day := 1
+	if s.Frequency == FrequencyWeekly {
+		day = int(time.Monday)
+	}
+
+	return NextOccurrence(s.Frequency, day, t)
+}
+
+// Subscribe creates, or updates, the statement subscription for a chat
+func (c *Client) Subscribe(ctx context.Context, s *StatementSubscription) error {
+	now := time.Now()
+	if s.LastSentAt.IsZero() {
+		s.LastSentAt = now
+	}
+	s.NextRunAt = s.Next(now)
+
+	_, err := c.db.WithContext(ctx).Model(s).
+		OnConflict("(platform_name, chat_id) DO UPDATE").
+		Set("frequency = EXCLUDED.frequency").
+		Set("user_id = EXCLUDED.user_id").
+		Set("next_run_at = EXCLUDED.next_run_at").
+		Insert()
+	return err
+}
+
+// Unsubscribe removes the statement subscription for a chat
+func (c *Client) Unsubscribe(ctx context.Context, p PlatformName, chatID string) error {
+	_, err := c.db.WithContext(ctx).Model((*StatementSubscription)(nil)).
+		Where("platform_name = ?", p).
+		Where("chat_id = ?", chatID).
+		Delete()
+	return err
+}
+
+// GetDueStatements returns all of the statement subscriptions that are due at t
+func (c *Client) GetDueStatements(ctx context.Context, t time.Time) ([]*StatementSubscription, error) {
+	subs := []*StatementSubscription{}
+	err := c.db.WithContext(ctx).Model(&subs).
+		Where("next_run_at <= ?", t).
+		Select()
+
+	return subs, err
+}
+
+// AdvanceStatement records that a statement was sent at t, and schedules the next one
+func (c *Client) AdvanceStatement(ctx context.Context, s *StatementSubscription, t time.Time) error {
+	s.LastSentAt = t
+	s.NextRunAt = s.Next(t)
+	_, err := c.db.WithContext(ctx).Model(s).Column("last_sent_at", "next_run_at").WherePK().Update()
+	return err
+}
@@ -0,0 +1,102 @@
+package account
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/gofrs/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrTokenNotFound is returned when a token doesn't exist, or has expired
+var ErrTokenNotFound error = errors.New("Token not found")
+
+// APIToken is a token that can be used to access the API as a user. Only a
+// hash of the token is stored.
+type APIToken struct {
+	// Id of this token
+	Id uuid.UUID `json:"id" pg:",pk,type:uuid,default:uuid_generate_v4()"`
+
+	// UserId is the user this token authenticates as
+	UserId uuid.UUID `json:"user_id" pg:"type:uuid,notnull"`
+
+	// Hash is the SHA256 of the token
+	Hash string `json:"-" pg:"hash,notnull,unique"`
+
+	// LastUsedAt is the last time this token was used
+	LastUsedAt time.Time `json:"last_used_at,omitempty" pg:"last_used_at"`
+
+	CreatedAt time.Time `json:"created_at" pg:"default:now(),notnull"`
+}
+
+func (t *APIToken) String() string {
+	return fmt.Sprintf("APIToken<ID: %s, UserID: %s>", t.Id, t.UserId)
+}
+
+// newToken returns a new random token
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hash of a token that is stored in the database
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken creates a new API token for a user, the token is only ever
+// returned here
+func (c *Client) CreateAPIToken(ctx context.Context, u *User) (string, *APIToken, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	t := &APIToken{
+		UserId:    u.Id,
+		Hash:      hashToken(token),
+		CreatedAt: time.Now(),
+	}
+
+	_, err = c.db.WithContext(ctx).Model(t).Insert()
+	return token, t, err
+}
+
+// FindUserByAPIToken returns the user that an API token belongs to
+func (c *Client) FindUserByAPIToken(ctx context.Context, token string) (*User, error) {
+	t := &APIToken{}
+	err := c.db.WithContext(ctx).Model(t).
+		Where("hash = ?", hashToken(token)).
+		Limit(1).
+		Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, ErrTokenNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	_, err = c.db.WithContext(ctx).Model(t).Set("last_used_at = ?", time.Now()).WherePK().Update()
+	if err != nil {
+		log.Warnf("failed to update last use of token %s: %v", t.Id, err)
+	}
+
+	return c.GetUser(ctx, t.UserId)
+}
+
+// RevokeAPITokens revokes every API token a user has
+func (c *Client) RevokeAPITokens(ctx context.Context, u *User) error {
+	_, err := c.db.WithContext(ctx).Model((*APIToken)(nil)).Where("user_id = ?", u.Id).Delete()
+	return err
+}
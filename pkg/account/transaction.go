@@ -1,11 +1,38 @@
 package account
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
 	"github.com/gofrs/uuid"
-	log "github.com/sirupsen/logrus"
+)
+
+// TransactionKind is what a transaction represents
+type TransactionKind string
+
+const (
+	// TransactionCharge is the creator requesting money from the subjects
+	TransactionCharge TransactionKind = "charge"
+
+	// TransactionPayment is the creator paying the subject back
+	TransactionPayment TransactionKind = "payment"
+)
+
+var (
+	// ErrTransactionNotFound is returned when a transaction doesn't exist
+	ErrTransactionNotFound error = errors.New("Transaction not found")
+
+	// ErrTransactionExists is returned when a recurring transaction has already
+	// been created for an occurrence
+	ErrTransactionExists error = errors.New("Transaction already exists")
+
+	// ErrNotCreator is returned when a user tries to change a transaction they didn't create
+	ErrNotCreator error = errors.New("User did not create this transaction")
 )
 
 // Transaction is a user transaction
@@ -20,9 +47,32 @@ type Transaction struct {
 	// were hit during this transaction
 	Accounts map[uuid.UUID]uuid.UUID `pg:"accounts" json:"account_id"`
 
+	// States is a userID -> state mapping of each subject's leg of this
+	// transaction
+	States map[uuid.UUID]TransactionState `pg:"states" json:"states"`
+
 	// Amount that this transaction was for, split across all involved users
 	Amount float64 `json:"amount" pg:"amount"`
 
+	// Kind is what this transaction represents, transactions without a kind are charges
+	Kind TransactionKind `json:"kind,omitempty" pg:"kind"`
+
+	// Description is an optional description of what this transaction was for
+	Description string `json:"description,omitempty" pg:"description"`
+
+	// RecurringId is the recurring transaction that created this transaction, if any
+	RecurringId *uuid.UUID `json:"recurring_id,omitempty" pg:"recurring_id,type:uuid"`
+
+	// ScheduledFor is the occurrence of the recurring transaction this transaction was created for
+	ScheduledFor time.Time `json:"scheduled_for,omitempty" pg:"scheduled_for"`
+
+	// PlatformName and ChatID are the chat this transaction was created in
+	PlatformName PlatformName `json:"platform_name,omitempty" pg:"platform_name"`
+	ChatID       string       `json:"chat_id,omitempty" pg:"chat_id"`
+
+	// ImportId identifies where a transaction was imported from, if it was
+	ImportId string `json:"import_id,omitempty" pg:"import_id"`
+
 	CreatedAt time.Time `json:"created_at" pg:"default:now(),notnull"`
 }
 
@@ -30,9 +80,49 @@ func (t *Transaction) String() string {
 	return fmt.Sprintf("Transaction<ID: %s, Amount: %v, CreatedBy: %s, Accounts: %s>", t.Id, t.Amount, t.CreatedBy, t.Accounts)
 }
 
-func (c *Client) GetAllTransactionsByUser(u *User, filterUser *User) ([]*Transaction, error) {
+// IsSettlement returns if this transaction settles a balance, rather than creating one
+func (t *Transaction) IsSettlement() bool {
+	return t.Kind == TransactionPayment || t.Amount < 0
+}
+
+// Voided returns if this transaction has been voided by its creator
+func (t *Transaction) Voided() bool {
+	for uid := range t.Accounts {
+		if t.State(uid) != TransactionVoided {
+			return false
+		}
+	}
+
+	return len(t.Accounts) > 0
+}
+
+// ShortID returns a short, human typeable, version of this transaction's ID
+func (t *Transaction) ShortID() string {
+	return strings.SplitN(t.Id.String(), "-", 2)[0]
+}
+
+// Share returns the amount each subject of this transaction is responsible for
+func (t *Transaction) Share() float64 {
+	if len(t.Accounts) == 0 {
+		return 0
+	}
+
+	return t.Amount / float64(len(t.Accounts))
+}
+
+// State returns the state of a user's leg of this transaction. Transactions
+// created before approvals existed have no states, and are treated as accepted.
+func (t *Transaction) State(userID uuid.UUID) TransactionState {
+	if s, ok := t.States[userID]; ok {
+		return s
+	}
+
+	return TransactionAccepted
+}
+
+func (c *Client) GetAllTransactionsByUser(ctx context.Context, u *User, filterUser *User) ([]*Transaction, error) {
 	trans := []*Transaction{}
-	query := c.db.Model(&trans).
+	query := c.db.WithContext(ctx).Model(&trans).
 		Where("accounts->>? != '' OR created_by = ?", u.Id, u.Id)
 
 	// append a filter for the user we want
@@ -45,36 +135,311 @@ func (c *Client) GetAllTransactionsByUser(u *User, filterUser *User) ([]*Transac
 	return trans, err
 }
 
+// TransactionFilter filters the transactions returned by ListTransactions
+type TransactionFilter struct {
+	// With only includes transactions involving this user
+	With *uuid.UUID
+
+	// Since and Until only include transactions created in this range
+	Since time.Time
+	Until time.Time
+
+	// State only includes transactions with a leg in this state
+	State TransactionState
+
+	Limit  int
+	Offset int
+}
+
+// ListTransactions returns a page of the transactions involving a user, newest
+// first, along with the total number of transactions that matched the filter
+func (c *Client) ListTransactions(ctx context.Context, u *User, f *TransactionFilter) ([]*Transaction, int, error) {
+	trans := []*Transaction{}
+	query := c.db.WithContext(ctx).Model(&trans).
+		Where("accounts->>? != '' OR created_by = ?", u.Id, u.Id).
+		Order("created_at DESC")
+
+	if f.With != nil {
+		query = query.Where("accounts->>? != '' OR created_by = ?", *f.With, *f.With)
+	}
+	if !f.Since.IsZero() {
+		query = query.Where("created_at >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		query = query.Where("created_at < ?", f.Until)
+	}
+	if f.State != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM jsonb_each_text(states) WHERE value = ?)", f.State)
+	}
+	if f.Limit > 0 {
+		query = query.Limit(f.Limit)
+	}
+	if f.Offset > 0 {
+		query = query.Offset(f.Offset)
+	}
+
+	count, err := query.SelectAndCount()
+	return trans, count, err
+}
+
+// GetTransactionsByUserSince returns all transactions involving a user that were created after since
+func (c *Client) GetTransactionsByUserSince(ctx context.Context, u *User, since time.Time) ([]*Transaction, error) {
+	trans := []*Transaction{}
+	err := c.db.WithContext(ctx).Model(&trans).
+		Where("accounts->>? != '' OR created_by = ?", u.Id, u.Id).
+		Where("created_at > ?", since).
+		Order("created_at ASC").
+		Select()
+
+	return trans, err
+}
+
+// GetTransactionsInChat returns all transactions that were created in a chat after since
+func (c *Client) GetTransactionsInChat(ctx context.Context, p PlatformName, chatID string, since time.Time) ([]*Transaction, error) {
+	trans := []*Transaction{}
+	err := c.db.WithContext(ctx).Model(&trans).
+		Where("platform_name = ?", p).
+		Where("chat_id = ?", chatID).
+		Where("created_at > ?", since).
+		Order("created_at ASC").
+		Select()
+
+	return trans, err
+}
+
+// Chat is a chat that transactions were created in
+type Chat struct {
+	PlatformName PlatformName `json:"platform_name"`
+	ChatID       string       `json:"chat_id"`
+
+	// Transactions is how many transactions were created in this chat
+	Transactions int `json:"transactions"`
+
+	// LastActivity is when the most recent transaction was created
+	LastActivity time.Time `json:"last_activity"`
+}
+
+// ListChats returns the chats that a user has transactions in, most recently
+// active first
+func (c *Client) ListChats(ctx context.Context, u *User) ([]Chat, error) {
+	chats := []Chat{}
+	_, err := c.db.WithContext(ctx).Query(&chats, `
+		SELECT platform_name, chat_id, count(*) AS transactions, max(created_at) AS last_activity
+		FROM transactions
+		WHERE (accounts->>? != '' OR created_by = ?) AND chat_id IS NOT NULL AND chat_id != ''
+		GROUP BY platform_name, chat_id
+		ORDER BY last_activity DESC`, u.Id, u.Id)
+
+	return chats, err
+}
+
+// GetTransactionsByState returns all transactions where the user's leg is in the provided state
+func (c *Client) GetTransactionsByState(ctx context.Context, u *User, state TransactionState) ([]*Transaction, error) {
+	trans := []*Transaction{}
+	err := c.db.WithContext(ctx).Model(&trans).
+		Where("states->>? = ?", u.Id, state).
+		Order("created_at ASC").
+		Select()
+
+	return trans, err
+}
+
 // GetTransaction by ID
-func (c *Client) GetTransaction(id string) (*Transaction, error) {
+func (c *Client) GetTransaction(ctx context.Context, id string) (*Transaction, error) {
 	t := &Transaction{}
-	err := c.db.Model(t).
+	err := c.db.WithContext(ctx).Model(t).
 		Where("transaction.id = ?", id).
 		Limit(1).
 		Select(t)
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, ErrTransactionNotFound
+	}
 
 	return t, err
 }
 
-// CreateUser creates a new transaction in the database
-func (c *Client) CreateTransaction(createdBy *User, involved []User, amount float64) error {
-	accounts := make(map[uuid.UUID]uuid.UUID)
-	for _, u := range involved {
-		a, err := c.FindAccountBetween(createdBy, &u)
-		if err != nil {
-			log.Errorf("failed to get accounts")
-			continue
-		}
-		accounts[u.Id] = a.Id
+// CreateTransaction creates a new transaction in the database, creating any accounts
+// that don't exist yet. Every involved user's leg starts out pending.
+func (c *Client) CreateTransaction(ctx context.Context, t *Transaction, createdBy *User, involved []User) error {
+	return c.createTransaction(ctx, t, createdBy, involved, TransactionPending)
+}
+
+// ImportTransaction creates a transaction that was imported from another
+// system, every leg is accepted and applied to its account immediately.
+// Transactions with an ImportId are only ever imported once.
+func (c *Client) ImportTransaction(ctx context.Context, t *Transaction, createdBy *User, involved []User) error {
+	return c.createTransaction(ctx, t, createdBy, involved, TransactionAccepted)
+}
+
+// IsImported returns if a transaction with an import ID has been imported
+func (c *Client) IsImported(ctx context.Context, importID string) (bool, error) {
+	return c.db.WithContext(ctx).Model((*Transaction)(nil)).Where("import_id = ?", importID).Exists()
+}
+
+// createTransaction creates a transaction with every leg in state, accepted
+// legs are applied to their accounts
+func (c *Client) createTransaction(ctx context.Context, t *Transaction, createdBy *User, involved []User, state TransactionState) error {
+	t.CreatedBy = createdBy.Id
+	t.Accounts = make(map[uuid.UUID]uuid.UUID)
+	t.States = make(map[uuid.UUID]TransactionState)
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now()
 	}
 
-	t := &Transaction{
-		CreatedBy: createdBy.Id,
-		Accounts:  accounts,
-		Amount:    amount,
-		CreatedAt: time.Now(),
+	err := c.db.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		for i := range involved {
+			u := &involved[i]
+
+			a, err := c.findAccountBetween(tx, createdBy, u)
+			if err == ErrAccountNotFound {
+				a = &Account{
+					Creator: createdBy,
+					Subject: u,
+				}
+				err = c.createAccount(tx, a)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to ensure account for %s: %v", u.Id, err)
+			}
+
+			t.Accounts[u.Id] = a.Id
+			t.States[u.Id] = state
+		}
+
+		if _, err := tx.Model(t).Insert(); err != nil {
+			return err
+		}
+
+		if state != TransactionAccepted {
+			return nil
+		}
+
+		for i := range involved {
+			if err := c.applyLeg(tx, t, &involved[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	// recurring and imported transactions have unique indexes on their origin
+	if pgErr, ok := err.(pg.Error); ok && pgErr.IntegrityViolation() && (t.RecurringId != nil || t.ImportId != "") {
+		return ErrTransactionExists
 	}
 
-	_, err := c.db.Model(t).Insert()
 	return err
 }
+
+// AcceptTransaction accepts the user's leg of a transaction, applying it to their account
+func (c *Client) AcceptTransaction(ctx context.Context, t *Transaction, u *User) error {
+	return c.transitionTransaction(ctx, t, u, TransactionAccepted)
+}
+
+// DisputeTransaction disputes the user's leg of a transaction
+func (c *Client) DisputeTransaction(ctx context.Context, t *Transaction, u *User) error {
+	return c.transitionTransaction(ctx, t, u, TransactionDisputed)
+}
+
+// transitionTransaction moves a user's leg of a transaction into a new state, and
+// updates the balance of their account if it was accepted
+func (c *Client) transitionTransaction(ctx context.Context, t *Transaction, u *User, to TransactionState) error {
+	return c.db.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		// reload the transaction while holding a lock on it to prevent
+		// a leg from being applied twice
+		current := &Transaction{}
+		err := tx.Model(current).
+			Where("transaction.id = ?", t.Id).
+			For("UPDATE").
+			Select()
+		if errors.Is(err, pg.ErrNoRows) {
+			return ErrTransactionNotFound
+		} else if err != nil {
+			return err
+		}
+
+		if _, ok := current.Accounts[u.Id]; !ok {
+			return ErrNotInvolved
+		}
+
+		next, err := current.State(u.Id).Transition(to)
+		if err != nil {
+			return err
+		}
+
+		if next == TransactionAccepted {
+			if err := c.applyLeg(tx, current, u); err != nil {
+				return err
+			}
+		}
+
+		if current.States == nil {
+			current.States = make(map[uuid.UUID]TransactionState)
+		}
+		current.States[u.Id] = next
+
+		if _, err := tx.Model(current).Column("states").WherePK().Update(); err != nil {
+			return err
+		}
+
+		*t = *current
+		return nil
+	})
+}
+
+// applyLeg applies a user's share of a transaction to the account between
+// them and the creator of the transaction
+func (c *Client) applyLeg(db orm.DB, t *Transaction, u *User) error {
+	creator := &User{Id: t.CreatedBy}
+	return c.newTransaction(db, creator, u, t.Share())
+}
+
+// VoidTransaction voids every leg of a transaction, reversing any legs that were
+// already accepted. Only the creator of a transaction can void it.
+func (c *Client) VoidTransaction(ctx context.Context, t *Transaction, u *User) error {
+	if t.CreatedBy != u.Id {
+		return ErrNotCreator
+	}
+
+	return c.db.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		current := &Transaction{}
+		err := tx.Model(current).
+			Where("transaction.id = ?", t.Id).
+			For("UPDATE").
+			Select()
+		if errors.Is(err, pg.ErrNoRows) {
+			return ErrTransactionNotFound
+		} else if err != nil {
+			return err
+		}
+
+		if current.States == nil {
+			current.States = make(map[uuid.UUID]TransactionState)
+		}
+
+		for uid := range current.Accounts {
+			state := current.State(uid)
+			next, err := state.Transition(TransactionVoided)
+			if err != nil {
+				return err
+			}
+
+			if state == TransactionAccepted {
+				subject := &User{Id: uid}
+				creator := &User{Id: current.CreatedBy}
+				if err := c.newTransaction(tx, creator, subject, -current.Share()); err != nil {
+					return err
+				}
+			}
+
+			current.States[uid] = next
+		}
+
+		if _, err := tx.Model(current).Column("states").WherePK().Update(); err != nil {
+			return err
+		}
+
+		*t = *current
+		return nil
+	})
+}
@@ -0,0 +1,195 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestTransactionShare(t *testing.T) {
+	a, b := uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())
+
+	tests := []struct {
+		accounts map[uuid.UUID]uuid.UUID
+		amount   float64
+		want     float64
+	}{
+		{accounts: nil, amount: 10, want: 0},
+		{accounts: map[uuid.UUID]uuid.UUID{a: a}, amount: 10, want: 10},
+		{accounts: map[uuid.UUID]uuid.UUID{a: a, b: b}, amount: 10, want: 5},
+		{accounts: map[uuid.UUID]uuid.UUID{a: a, b: b}, amount: -10, want: -5},
+	}
+
+	for _, tt := range tests {
+		tr := &Transaction{Accounts: tt.accounts, Amount: tt.amount}
+		if got := tr.Share(); got != tt.want {
+			t.Errorf("%v split %d ways: expected share %v, got %v", tt.amount, len(tt.accounts), tt.want, got)
+		}
+	}
+}
+
+func TestTransactionStates(t *testing.T) {
+	a, b := uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())
+	tr := &Transaction{Accounts: map[uuid.UUID]uuid.UUID{a: a, b: b}}
+
+	// transactions from before approvals existed have no states
+	if s := tr.State(a); s != TransactionAccepted {
+		t.Errorf("expected a leg without a state to be accepted, got '%s'", s)
+	}
+	if tr.Voided() {
+		t.Errorf("expected a transaction without states not to be voided")
+	}
+
+	tr.States = map[uuid.UUID]TransactionState{a: TransactionVoided, b: TransactionPending}
+	if tr.Voided() {
+		t.Errorf("expected a partially voided transaction not to be voided")
+	}
+
+	tr.States[b] = TransactionVoided
+	if !tr.Voided() {
+		t.Errorf("expected a transaction with every leg voided to be voided")
+	}
+
+	if (&Transaction{}).Voided() {
+		t.Errorf("expected a transaction without legs not to be voided")
+	}
+}
+
+func TestOnlyAcceptedLegsChangeBalance(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	alice := newTestUser(t, c, "alice")
+	bob := newTestUser(t, c, "bob")
+	carol := newTestUser(t, c, "carol")
+
+	tr := &Transaction{Amount: 20}
+	if err := c.CreateTransaction(ctx, tr, alice, []User{*bob, *carol}); err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+
+	expect := func(step string, wantBob, wantCarol float64) {
+		t.Helper()
+		if got := balance(t, c, alice, bob); got != wantBob {
+			t.Errorf("%s: expected bob to owe %v, got %v", step, wantBob, got)
+		}
+		if got := balance(t, c, alice, carol); got != wantCarol {
+			t.Errorf("%s: expected carol to owe %v, got %v", step, wantCarol, got)
+		}
+	}
+
+	expect("pending", 0, 0)
+
+	if err := c.AcceptTransaction(ctx, tr, bob); err != nil {
+		t.Fatalf("failed to accept transaction: %v", err)
+	}
+	expect("bob accepted", 10, 0)
+
+	if err := c.DisputeTransaction(ctx, tr, carol); err != nil {
+		t.Fatalf("failed to dispute transaction: %v", err)
+	}
+	expect("carol disputed", 10, 0)
+
+	// an accepted leg can't be accepted, and applied, a second time
+	if err := c.AcceptTransaction(ctx, tr, bob); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("expected ErrInvalidTransition accepting twice, got %v", err)
+	}
+	expect("bob accepted twice", 10, 0)
+
+	if err := c.AcceptTransaction(ctx, tr, carol); err != nil {
+		t.Fatalf("failed to accept disputed transaction: %v", err)
+	}
+	expect("carol accepted", 10, 10)
+
+	if err := c.AcceptTransaction(ctx, tr, alice); err != ErrNotInvolved {
+		t.Errorf("expected ErrNotInvolved accepting as the creator, got %v", err)
+	}
+
+	if err := c.VoidTransaction(ctx, tr, bob); err != ErrNotCreator {
+		t.Errorf("expected ErrNotCreator voiding as a subject, got %v", err)
+	}
+	if err := c.VoidTransaction(ctx, tr, alice); err != nil {
+		t.Fatalf("failed to void transaction: %v", err)
+	}
+	expect("voided", 0, 0)
+
+	if !tr.Voided() {
+		t.Errorf("expected transaction to be voided, got states %v", tr.States)
+	}
+}
+
+func TestVoidingPendingLegsLeavesBalance(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	alice := newTestUser(t, c, "alice")
+	bob := newTestUser(t, c, "bob")
+
+	accepted := &Transaction{Amount: 5}
+	if err := c.ImportTransaction(ctx, accepted, bob, []User{*alice}); err != nil {
+		t.Fatalf("failed to import transaction: %v", err)
+	}
+	if got := balance(t, c, bob, alice); got != 5 {
+		t.Fatalf("expected imported transaction to be applied, alice owes %v", got)
+	}
+
+	pending := &Transaction{Amount: 10}
+	if err := c.CreateTransaction(ctx, pending, bob, []User{*alice}); err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	if err := c.VoidTransaction(ctx, pending, bob); err != nil {
+		t.Fatalf("failed to void transaction: %v", err)
+	}
+	if got := balance(t, c, bob, alice); got != 5 {
+		t.Errorf("expected voiding a pending transaction not to change the balance, alice owes %v", got)
+	}
+
+	if err := c.VoidTransaction(ctx, pending, bob); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("expected ErrInvalidTransition voiding twice, got %v", err)
+	}
+}
+
+func TestConcurrentTransactions(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	alice := newTestUser(t, c, "alice")
+	bob := newTestUser(t, c, "bob")
+
+	// create the account between them first
+	if err := c.ImportTransaction(ctx, &Transaction{Amount: 1}, alice, []User{*bob}); err != nil {
+		t.Fatalf("failed to import transaction: %v", err)
+	}
+
+	// transactions in different chats are handled at the same time, none of
+	// them can be lost
+	const n = 20
+	errs := make(chan error, 2*n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs <- c.ImportTransaction(ctx, &Transaction{Amount: 2}, alice, []User{*bob})
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- c.ImportTransaction(ctx, &Transaction{Amount: 1}, bob, []User{*alice})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("failed to import transaction: %v", err)
+		}
+	}
+
+	if got := balance(t, c, alice, bob); got != n+1 {
+		t.Errorf("expected bob to owe %d, got %v", n+1, got)
+	}
+}
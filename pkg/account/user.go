@@ -1,12 +1,15 @@
 package account
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
 	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
 	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/metrics"
 	"github.com/patrickmn/go-cache"
 )
 
@@ -19,8 +22,16 @@ const (
 var (
 	ErrUserExists   error = errors.New("User already exists")
 	ErrUserNotFound error = errors.New("User not found")
+
+	// ErrOutstandingBalance is returned when a user can't be deleted because
+	// they still owe, or are owed, money
+	ErrOutstandingBalance error = errors.New("User has outstanding balances")
 )
 
+// UserListener is called whenever a user is changed, or removed, in a way
+// that invalidates any cached copies of them
+type UserListener func(u *User)
+
 // User is an account owner
 type User struct {
 	// Id of the User
@@ -30,6 +41,23 @@ type User struct {
 	PlatformIds       map[PlatformName]string `pg:"platform_ids,notnull" json:"platform_ids"`
 	PlatformUsernames map[PlatformName]string `pg:"platform_usernames,notnull" json:"platform_usernames"`
 
+	// Placeholder is set for users that were created by someone mentioning them,
+	// before they talked to us. Placeholders are claimed the first time the user
+	// sends us a message.
+	Placeholder bool `pg:"placeholder,use_zero" json:"placeholder"`
+
+	// RemindersDisabled is set when a user has opted out of payment reminders
+	RemindersDisabled bool `pg:"reminders_disabled,use_zero" json:"reminders_disabled"`
+
+	// QuietHoursStart and QuietHoursEnd are the hours of the day, in Timezone,
+	// that a user shouldn't be reminded during. Equal values disable quiet hours.
+	QuietHoursStart int    `pg:"quiet_hours_start,use_zero" json:"quiet_hours_start"`
+	QuietHoursEnd   int    `pg:"quiet_hours_end,use_zero" json:"quiet_hours_end"`
+	Timezone        string `pg:"timezone" json:"timezone,omitempty"`
+
+	// LastRemindedAt is the last time this user was sent a reminder
+	LastRemindedAt time.Time `pg:"last_reminded_at" json:"last_reminded_at,omitempty"`
+
 	CreatedAt time.Time `json:"created_at" pg:"default:now(),notnull"`
 	UpdatedAt time.Time `json:"updated_at" pg:"default:now(),notnull"`
 }
@@ -38,10 +66,78 @@ func (u *User) String() string {
 	return fmt.Sprintf("User<ID: %s, PlatformIds: %v>", u.Id, u.PlatformIds)
 }
 
+// Clone returns a copy of u that can be changed without changing u. Cached
+// users are shared between goroutines, so only copies of them are handed out.
+func (u *User) Clone() *User {
+	clone := *u
+	if u.PlatformIds != nil {
+		clone.PlatformIds = make(map[PlatformName]string, len(u.PlatformIds))
+		for p, id := range u.PlatformIds {
+			clone.PlatformIds[p] = id
+		}
+	}
+	if u.PlatformUsernames != nil {
+		clone.PlatformUsernames = make(map[PlatformName]string, len(u.PlatformUsernames))
+		for p, username := range u.PlatformUsernames {
+			clone.PlatformUsernames[p] = username
+		}
+	}
+
+	return &clone
+}
+
+// Location returns the user's timezone, defaulting to UTC
+func (u *User) Location() *time.Location {
+	if u.Timezone == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+// InQuietHours returns if t is during the user's quiet hours
+func (u *User) InQuietHours(t time.Time) bool {
+	if u.QuietHoursStart == u.QuietHoursEnd {
+		return false
+	}
+
+	hour := t.In(u.Location()).Hour()
+
+	// quiet hours that wrap around midnight, e.g. 22 -> 8
+	if u.QuietHoursStart > u.QuietHoursEnd {
+		return hour >= u.QuietHoursStart || hour < u.QuietHoursEnd
+	}
+
+	return hour >= u.QuietHoursStart && hour < u.QuietHoursEnd
+}
+
+// AddUserListener registers a function to be called whenever a user changes
+func (c *Client) AddUserListener(fn UserListener) {
+	c.listenersMu.Lock()
+	defer c.listenersMu.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
+// userChanged invalidates our cache of a user, and notifies all listeners
+func (c *Client) userChanged(u *User) {
+	c.cache.Delete(fmt.Sprintf("user:%s", u.Id))
+
+	c.listenersMu.RLock()
+	defer c.listenersMu.RUnlock()
+	for _, fn := range c.listeners {
+		fn(u)
+	}
+}
+
 // FindUser finds a user by their social ID
-func (c *Client) FindUser(p PlatformName, id string) (*User, error) {
+func (c *Client) FindUser(ctx context.Context, p PlatformName, id string) (*User, error) {
 	u := &User{}
-	err := c.db.Model(u).
+	err := c.db.WithContext(ctx).Model(u).
 		Where("platform_ids->>? = ?", p, id).
 		Limit(1).
 		Select()
@@ -54,9 +150,9 @@ func (c *Client) FindUser(p PlatformName, id string) (*User, error) {
 }
 
 // FindUserByUsername finds a user by their social ID
-func (c *Client) FindUserByUsernam(p PlatformName, username string) (*User, error) {
+func (c *Client) FindUserByUsernam(ctx context.Context, p PlatformName, username string) (*User, error) {
 	u := &User{}
-	err := c.db.Model(u).
+	err := c.db.WithContext(ctx).Model(u).
 		Where("platform_usernames->>? = ?", p, username).
 		Limit(1).
 		Select()
@@ -68,46 +164,190 @@ func (c *Client) FindUserByUsernam(p PlatformName, username string) (*User, erro
 	return u, err
 }
 
+// ClaimPlaceholder turns a placeholder for a user into a real user, returning
+// ErrUserNotFound if there isn't one. Placeholders are matched by their
+// platform ID if they were created with one, otherwise by their username.
+// Username has to be the user's actual username on the platform, not a name
+// anyone could pick, users without one only claim placeholders by their ID.
+func (c *Client) ClaimPlaceholder(ctx context.Context, p PlatformName, id, username string) (*User, error) {
+	u := &User{}
+	err := c.db.WithContext(ctx).Model(u).
+		Where("placeholder = true").
+		WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+			q = q.Where("platform_ids->>? = ?", p, id)
+			if username != "" {
+				q = q.WhereOr("platform_ids->>? IS NULL AND platform_usernames->>? = ?", p, p, username)
+			}
+			return q, nil
+		}).
+		Order("created_at ASC").
+		Limit(1).
+		Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	u.Placeholder = false
+	u.PlatformIds[p] = id
+	if u.PlatformUsernames == nil {
+		u.PlatformUsernames = make(map[PlatformName]string)
+	}
+	if username != "" {
+		u.PlatformUsernames[p] = username
+	}
+	u.UpdatedAt = time.Now()
+
+	_, err = c.db.WithContext(ctx).Model(u).Column("placeholder", "platform_ids", "platform_usernames", "updated_at").WherePK().Update()
+	if err != nil {
+		return nil, err
+	}
+
+	c.userChanged(u)
+	return u, nil
+}
+
 // GetUser returns a user
-func (c *Client) GetUser(id uuid.UUID) (*User, error) {
+func (c *Client) GetUser(ctx context.Context, id uuid.UUID) (*User, error) {
 	cacheKey := fmt.Sprintf("user:%s", id)
 	v, found := c.cache.Get(cacheKey)
+	metrics.CacheLookup("account_users", found)
 
 	u := &User{}
 	var err error
 	if !found {
-		err = c.db.Model(u).
+		err = c.db.WithContext(ctx).Model(u).
 			Where("id = ?", id).
 			Select()
 
 		if errors.Is(err, pg.ErrNoRows) {
 			return nil, ErrUserNotFound
+		} else if err != nil {
+			return nil, err
 		}
 
-		c.cache.Set(cacheKey, u, cache.DefaultExpiration)
+		c.cache.Set(cacheKey, u.Clone(), cache.DefaultExpiration)
 	} else {
-		u = v.(*User)
-		return u, nil
+		u = v.(*User).Clone()
 	}
 
-	return u, err
+	return u, nil
 }
 
 // ListUsers returns a list of all the users in the database
-func (c *Client) ListUsers() ([]*User, error) {
+func (c *Client) ListUsers(ctx context.Context) ([]*User, error) {
 	users := []*User{}
-	err := c.db.Model(users).Select()
+	err := c.db.WithContext(ctx).Model(&users).Select()
 	return users, err
 }
 
 // CreateUser creates a user in the database
-func (c *Client) CreateUser(u *User) error {
+func (c *Client) CreateUser(ctx context.Context, u *User) error {
 	// TODO(jaredallard): this is not optimal
 	for p, v := range u.PlatformIds {
-		if u, err := c.FindUser(p, v); err == nil && u != nil {
+		if u, err := c.FindUser(ctx, p, v); err == nil && u != nil {
 			return ErrUserExists
 		}
 	}
-	_, err := c.db.Model(u).Insert()
+	_, err := c.db.WithContext(ctx).Model(u).Insert()
+	if err != nil {
+		return err
+	}
+
+	c.userChanged(u)
+	return nil
+}
+
+// DeleteUser deletes a user along with their settled accounts. Users that have
+// outstanding balances, or pending transactions, can't be deleted. Transactions
+// are kept, since they're part of other users' history. Users are removed from
+// other users' recurring transactions, which are deleted if no one is left.
+func (c *Client) DeleteUser(ctx context.Context, u *User) error {
+	err := c.db.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		n, err := tx.Model((*Account)(nil)).
+			Where("creator_id = ? OR subject_id = ?", u.Id, u.Id).
+			Where("balance != 0").
+			Count()
+		if err != nil {
+			return err
+		}
+		if n != 0 {
+			return ErrOutstandingBalance
+		}
+
+		n, err = tx.Model((*Transaction)(nil)).
+			Where("states->>? = ?", u.Id, TransactionPending).
+			Count()
+		if err != nil {
+			return err
+		}
+		if n != 0 {
+			return ErrOutstandingBalance
+		}
+
+		var recurring []*RecurringTransaction
+		err = tx.Model(&recurring).
+			Where("created_by != ?", u.Id).
+			Where("subjects @> ?::jsonb", fmt.Sprintf("[%q]", u.Id)).
+			Select()
+		if err != nil {
+			return err
+		}
+		for _, r := range recurring {
+			r.removeSubject(u.Id)
+			r.UpdatedAt = time.Now()
+
+			if len(r.Subjects) == 0 {
+				_, err = tx.Model(r).WherePK().Delete()
+			} else {
+				_, err = tx.Model(r).Column("subjects", "amount", "updated_at").WherePK().Update()
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, q := range []*orm.Query{
+			tx.Model((*Account)(nil)).Where("creator_id = ? OR subject_id = ?", u.Id, u.Id),
+			tx.Model((*RecurringTransaction)(nil)).Where("created_by = ?", u.Id),
+			tx.Model((*StatementSubscription)(nil)).Where("user_id = ?", u.Id),
+			tx.Model((*LinkCode)(nil)).Where("user_id = ?", u.Id),
+			tx.Model((*UsernameHistory)(nil)).Where("user_id = ?", u.Id),
+			tx.Model((*APIToken)(nil)).Where("user_id = ?", u.Id),
+			tx.Model((*Session)(nil)).Where("user_id = ?", u.Id),
+		} {
+			if _, err := q.Delete(); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Model(u).WherePK().Delete()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	c.userChanged(u)
+	return nil
+}
+
+// UpdateReminderSettings saves a user's reminder opt-out and quiet hours
+func (c *Client) UpdateReminderSettings(ctx context.Context, u *User) error {
+	u.UpdatedAt = time.Now()
+	_, err := c.db.WithContext(ctx).Model(u).
+		Column("reminders_disabled", "quiet_hours_start", "quiet_hours_end", "timezone", "updated_at").
+		WherePK().
+		Update()
+	c.userChanged(u)
+	return err
+}
+
+// MarkReminded records that a user was sent a reminder at t
+func (c *Client) MarkReminded(ctx context.Context, u *User, t time.Time) error {
+	u.LastRemindedAt = t
+	_, err := c.db.WithContext(ctx).Model(u).Column("last_reminded_at").WherePK().Update()
+	c.userChanged(u)
 	return err
 }
@@ -0,0 +1,135 @@
+package account
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestDeleteUserRemovesRecurringSubject(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	alice := newTestUser(t, c, "alice")
+	bob := newTestUser(t, c, "bob")
+	carol := newTestUser(t, c, "carol")
+
+	shared := &RecurringTransaction{
+		CreatedBy:    alice.Id,
+		Subjects:     []uuid.UUID{bob.Id, carol.Id},
+		Amount:       20,
+		Frequency:    FrequencyMonthly,
+		Day:          1,
+		PlatformName: PlatformTelegram,
+		ChatID:       "1001",
+	}
+	only := &RecurringTransaction{
+		CreatedBy:    alice.Id,
+		Subjects:     []uuid.UUID{bob.Id},
+		Amount:       5,
+		Frequency:    FrequencyDaily,
+		PlatformName: PlatformTelegram,
+		ChatID:       "1001",
+	}
+	for _, r := range []*RecurringTransaction{shared, only} {
+		if err := c.CreateRecurring(ctx, r); err != nil {
+			t.Fatalf("failed to create recurring transaction: %v", err)
+		}
+	}
+
+	if err := c.DeleteUser(ctx, bob); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+
+	r, err := c.GetRecurring(ctx, shared.Id)
+	if err != nil {
+		t.Fatalf("failed to get recurring transaction: %v", err)
+	}
+	if len(r.Subjects) != 1 || r.Subjects[0] != carol.Id {
+		t.Errorf("expected only carol to be left, got %v", r.Subjects)
+	}
+	if r.Amount != 10 {
+		t.Errorf("expected carol's share to stay at 10, amount is %v", r.Amount)
+	}
+
+	if _, err := c.GetRecurring(ctx, only.Id); err != ErrRecurringNotFound {
+		t.Errorf("expected a recurring transaction without subjects to be deleted, got %v", err)
+	}
+
+	// every user in a due recurring transaction still exists
+	due, err := c.GetDueRecurring(ctx, time.Now().AddDate(0, 2, 0))
+	if err != nil {
+		t.Fatalf("failed to list due recurring transactions: %v", err)
+	}
+	for _, r := range due {
+		for _, id := range r.Subjects {
+			if _, err := c.GetUser(ctx, id); err != nil {
+				t.Errorf("recurring transaction %s has a subject that doesn't exist: %v", r.Id, err)
+			}
+		}
+	}
+}
+
+func TestClaimPlaceholder(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	placeholder := &User{
+		Placeholder:       true,
+		PlatformIds:       map[PlatformName]string{},
+		PlatformUsernames: map[PlatformName]string{PlatformTelegram: "bob"},
+	}
+	if err := c.CreateUser(ctx, placeholder); err != nil {
+		t.Fatalf("failed to create placeholder: %v", err)
+	}
+
+	// users without a username can't claim a placeholder by name
+	if _, err := c.ClaimPlaceholder(ctx, PlatformTelegram, "2001", ""); err != ErrUserNotFound {
+		t.Fatalf("expected ErrUserNotFound claiming without a username, got %v", err)
+	}
+	if _, err := c.ClaimPlaceholder(ctx, PlatformTelegram, "2001", "alice"); err != ErrUserNotFound {
+		t.Fatalf("expected ErrUserNotFound claiming with another username, got %v", err)
+	}
+
+	u, err := c.ClaimPlaceholder(ctx, PlatformTelegram, "2002", "bob")
+	if err != nil {
+		t.Fatalf("failed to claim placeholder: %v", err)
+	}
+	if u.Id != placeholder.Id || u.Placeholder || u.PlatformIds[PlatformTelegram] != "2002" {
+		t.Errorf("expected placeholder %s to be claimed by 2002, got %v", placeholder.Id, u)
+	}
+
+	if _, err := c.ClaimPlaceholder(ctx, PlatformTelegram, "2003", "bob"); err != ErrUserNotFound {
+		t.Errorf("expected a placeholder to only be claimed once, got %v", err)
+	}
+}
+
+func TestClaimPlaceholderByID(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	placeholder := &User{
+		Placeholder:       true,
+		PlatformIds:       map[PlatformName]string{PlatformTelegram: "2001"},
+		PlatformUsernames: map[PlatformName]string{PlatformTelegram: "bobsmith"},
+	}
+	if err := c.CreateUser(ctx, placeholder); err != nil {
+		t.Fatalf("failed to create placeholder: %v", err)
+	}
+
+	// placeholders with an ID can only be claimed by that ID, which works
+	// without a username
+	if _, err := c.ClaimPlaceholder(ctx, PlatformTelegram, "2002", "bobsmith"); err != ErrUserNotFound {
+		t.Fatalf("expected ErrUserNotFound claiming with another ID, got %v", err)
+	}
+
+	u, err := c.ClaimPlaceholder(ctx, PlatformTelegram, "2001", "")
+	if err != nil {
+		t.Fatalf("failed to claim placeholder: %v", err)
+	}
+	if u.PlatformUsernames[PlatformTelegram] != "bobsmith" {
+		t.Errorf("expected the placeholder's username to be kept, got '%s'", u.PlatformUsernames[PlatformTelegram])
+	}
+}
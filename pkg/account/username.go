@@ -0,0 +1,119 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/gofrs/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// UsernameHistory is a username that a user used to have on a platform
+type UsernameHistory struct {
+	// Id of this entry
+	Id uuid.UUID `json:"id" pg:",pk,type:uuid,default:uuid_generate_v4()"`
+
+	// UserId is the user that had this username
+	UserId uuid.UUID `json:"user_id" pg:"type:uuid,notnull"`
+
+	PlatformName PlatformName `json:"platform_name" pg:"platform_name,notnull"`
+	Username     string       `json:"username" pg:"username,notnull"`
+
+	// ReplacedAt is when the user stopped using this username
+	ReplacedAt time.Time `json:"replaced_at" pg:"default:now(),notnull"`
+}
+
+func (h *UsernameHistory) String() string {
+	return fmt.Sprintf("UsernameHistory<UserID: %s, Platform: %s, Username: %s>", h.UserId, h.PlatformName, h.Username)
+}
+
+// UpdateUsername changes a user's username on a platform, recording their old
+// username. If another user currently has the username then their username must
+// be stale, since platforms don't allow duplicates, so it's moved into their
+// history until we see them again.
+func (c *Client) UpdateUsername(ctx context.Context, u *User, p PlatformName, username string) error {
+	old := u.PlatformUsernames[p]
+	if old == username {
+		return nil
+	}
+
+	var stale []*User
+	err := c.db.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		err := tx.Model(&stale).
+			Where("platform_usernames->>? = ?", p, username).
+			Where("id != ?", u.Id).
+			For("UPDATE").
+			Select()
+		if err != nil {
+			return err
+		}
+
+		for _, other := range stale {
+			log.Warnf("username %s on %s moved from user %s to %s, clearing stale username", username, p, other.Id, u.Id)
+			_, err := tx.Model(&UsernameHistory{UserId: other.Id, PlatformName: p, Username: username, ReplacedAt: time.Now()}).Insert()
+			if err != nil {
+				return err
+			}
+
+			delete(other.PlatformUsernames, p)
+			other.UpdatedAt = time.Now()
+			if _, err := tx.Model(other).Column("platform_usernames", "updated_at").WherePK().Update(); err != nil {
+				return err
+			}
+		}
+
+		if old != "" {
+			_, err := tx.Model(&UsernameHistory{UserId: u.Id, PlatformName: p, Username: old, ReplacedAt: time.Now()}).Insert()
+			if err != nil {
+				return err
+			}
+		}
+
+		if u.PlatformUsernames == nil {
+			u.PlatformUsernames = make(map[PlatformName]string)
+		}
+		u.PlatformUsernames[p] = username
+		u.UpdatedAt = time.Now()
+
+		_, err = tx.Model(u).Column("platform_usernames", "updated_at").WherePK().Update()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, other := range stale {
+		c.userChanged(other)
+	}
+	c.userChanged(u)
+
+	return nil
+}
+
+// LookupUsername finds a user by their username, falling back to usernames they
+// used to have. previous is true when username is no longer the user's username.
+func (c *Client) LookupUsername(ctx context.Context, p PlatformName, username string) (u *User, previous bool, err error) {
+	u, err = c.FindUserByUsernam(ctx, p, username)
+	if err != ErrUserNotFound {
+		return u, false, err
+	}
+
+	h := &UsernameHistory{}
+	err = c.db.WithContext(ctx).Model(h).
+		Where("platform_name = ?", p).
+		Where("username = ?", username).
+		Order("replaced_at DESC").
+		Limit(1).
+		Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, false, ErrUserNotFound
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	u, err = c.GetUser(ctx, h.UserId)
+	return u, true, err
+}
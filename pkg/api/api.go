@@ -0,0 +1,164 @@
+// Package api implements a REST/JSON API over the account client
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+	log "github.com/sirupsen/logrus"
+)
+
+// Prefix is the path the API is served under
+const Prefix = "/api/v1/"
+
+type contextKey int
+
+// userKey is the context key of the authenticated user
+const userKey contextKey = iota
+
+// Store is the part of the account client the API is served from
+type Store interface {
+	FindUserByAPIToken(ctx context.Context, token string) (*account.User, error)
+	GetUser(ctx context.Context, id uuid.UUID) (*account.User, error)
+	FindAccounts(ctx context.Context, u *account.User) ([]*account.Account, error)
+	ListTransactions(ctx context.Context, u *account.User, f *account.TransactionFilter) ([]*account.Transaction, int, error)
+	GetTransaction(ctx context.Context, id string) (*account.Transaction, error)
+	CreateTransaction(ctx context.Context, t *account.Transaction, createdBy *account.User, involved []account.User) error
+	VoidTransaction(ctx context.Context, t *account.Transaction, u *account.User) error
+}
+
+// Notifier asks users to approve transactions created through the API
+type Notifier interface {
+	NotifyTransaction(ctx context.Context, from *account.User, users []account.User, t *account.Transaction, p account.PlatformName)
+}
+
+// Server serves the API
+type Server struct {
+	a Store
+	h Notifier
+
+	// Platform is the platform users are notified on about transactions created
+	// through the API
+	Platform account.PlatformName
+}
+
+// NewServer creates a new API server
+func NewServer(a Store, h Notifier) *Server {
+	return &Server{
+		a:        a,
+		h:        h,
+		Platform: account.PlatformTelegram,
+	}
+}
+
+// apiError is the body of every error response
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// writeJSON writes v as the body of a response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnf("failed to write api response: %v", err)
+	}
+}
+
+// writeError writes an error response, internal errors are logged rather than
+// returned to the client
+func writeError(w http.ResponseWriter, status int, msg string, err error) {
+	if err != nil {
+		log.Errorf("api: %s: %v", msg, err)
+	}
+	writeJSON(w, status, apiError{Error: msg})
+}
+
+// userFrom returns the authenticated user of a request
+func userFrom(r *http.Request) *account.User {
+	u, _ := r.Context().Value(userKey).(*account.User)
+	return u
+}
+
+// authenticate is middleware that only allows requests with a valid API token
+func (s *Server) authenticate(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == r.Header.Get("Authorization") {
+			writeError(w, http.StatusUnauthorized, "missing bearer token", nil)
+			return
+		}
+
+		u, err := s.a.FindUserByAPIToken(r.Context(), token)
+		if err == account.ErrTokenNotFound || err == account.ErrUserNotFound {
+			writeError(w, http.StatusUnauthorized, "invalid token", nil)
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to authenticate", err)
+			return
+		}
+
+		fn(w, r.WithContext(context.WithValue(r.Context(), userKey, u)))
+	}
+}
+
+// ServeHTTP routes a request to the handler for its path
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, Prefix), "/")
+	parts := strings.Split(path, "/")
+
+	// routes are matched by their path segments, IDs are passed as the rest of parts
+	var fn http.HandlerFunc
+	method := http.MethodGet
+	switch {
+	case path == "openapi.json":
+		fn = s.getOpenAPI
+	case path == "me":
+		fn = s.authenticate(s.getMe)
+	case path == "users":
+		fn = s.authenticate(s.listUsers)
+	case len(parts) == 2 && parts[0] == "users":
+		fn = s.authenticate(s.getUser)
+	case path == "accounts":
+		fn = s.authenticate(s.listAccounts)
+	case path == "balances":
+		fn = s.authenticate(s.listBalances)
+	case path == "transactions" && r.Method == http.MethodPost:
+		method = http.MethodPost
+		fn = s.authenticate(s.createTransaction)
+	case path == "transactions":
+		fn = s.authenticate(s.listTransactions)
+	case len(parts) == 2 && parts[0] == "transactions":
+		fn = s.authenticate(s.getTransaction)
+	case len(parts) == 3 && parts[0] == "transactions" && parts[2] == "void":
+		method = http.MethodPost
+		fn = s.authenticate(s.voidTransaction)
+	case path == "payments":
+		method = http.MethodPost
+		fn = s.authenticate(s.createPayment)
+	default:
+		writeError(w, http.StatusNotFound, "not found", nil)
+		return
+	}
+
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	fn(w, r)
+}
+
+// pathID returns the ID segment of a /resource/{id} path
+func pathID(r *http.Request) string {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, Prefix), "/"), "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
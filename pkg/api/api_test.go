@@ -0,0 +1,481 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+)
+
+// store is an in-process Store, transactions follow the same state rules as
+// account.Client
+type store struct {
+	mu     sync.Mutex
+	users  map[uuid.UUID]*account.User
+	tokens map[string]uuid.UUID
+	accts  []*account.Account
+	trans  map[uuid.UUID]*account.Transaction
+}
+
+func newStore() *store {
+	return &store{
+		users:  make(map[uuid.UUID]*account.User),
+		tokens: make(map[string]uuid.UUID),
+		trans:  make(map[uuid.UUID]*account.Transaction),
+	}
+}
+
+// addUser adds a user that authenticates with token, if it isn't empty
+func (s *store) addUser(name, token string) *account.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := &account.User{
+		Id:                uuid.Must(uuid.NewV4()),
+		PlatformIds:       map[account.PlatformName]string{account.PlatformTelegram: name + "-id"},
+		PlatformUsernames: map[account.PlatformName]string{account.PlatformTelegram: name},
+		Timezone:          "America/Los_Angeles",
+	}
+	s.users[u.Id] = u
+	if token != "" {
+		s.tokens[token] = u.Id
+	}
+	return u
+}
+
+// account returns the account between two users, creating it if it doesn't exist
+func (s *store) account(creator, subject *account.User) *account.Account {
+	for _, a := range s.accts {
+		if (a.CreatorId == creator.Id && a.SubjectId == subject.Id) || (a.CreatorId == subject.Id && a.SubjectId == creator.Id) {
+			return a
+		}
+	}
+
+	a := &account.Account{
+		Id:        uuid.Must(uuid.NewV4()),
+		CreatorId: creator.Id,
+		Creator:   creator,
+		SubjectId: subject.Id,
+		Subject:   subject,
+	}
+	s.accts = append(s.accts, a)
+	return a
+}
+
+func (s *store) FindUserByAPIToken(ctx context.Context, token string) (*account.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.tokens[token]
+	if !ok {
+		return nil, account.ErrTokenNotFound
+	}
+	return s.users[id], nil
+}
+
+func (s *store) GetUser(ctx context.Context, id uuid.UUID) (*account.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return nil, account.ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (s *store) FindAccounts(ctx context.Context, u *account.User) ([]*account.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var accts []*account.Account
+	for _, a := range s.accts {
+		if a.CreatorId == u.Id || a.SubjectId == u.Id {
+			accts = append(accts, a)
+		}
+	}
+	return accts, nil
+}
+
+func (s *store) ListTransactions(ctx context.Context, u *account.User, f *account.TransactionFilter) ([]*account.Transaction, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	trans := []*account.Transaction{}
+	for _, t := range s.trans {
+		if _, ok := t.Accounts[u.Id]; ok || t.CreatedBy == u.Id {
+			trans = append(trans, t)
+		}
+	}
+	sort.Slice(trans, func(i, j int) bool { return trans[i].CreatedAt.After(trans[j].CreatedAt) })
+
+	total := len(trans)
+	if f.Offset < len(trans) {
+		trans = trans[f.Offset:]
+	} else {
+		trans = trans[:0]
+	}
+	if f.Limit > 0 && f.Limit < len(trans) {
+		trans = trans[:f.Limit]
+	}
+	return trans, total, nil
+}
+
+func (s *store) GetTransaction(ctx context.Context, id string) (*account.Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.trans[uuid.FromStringOrNil(id)]
+	if !ok {
+		return nil, account.ErrTransactionNotFound
+	}
+	return t, nil
+}
+
+func (s *store) CreateTransaction(ctx context.Context, t *account.Transaction, createdBy *account.User, involved []account.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t.Id = uuid.Must(uuid.NewV4())
+	t.CreatedBy = createdBy.Id
+	t.CreatedAt = time.Now()
+	t.Accounts = make(map[uuid.UUID]uuid.UUID)
+	t.States = make(map[uuid.UUID]account.TransactionState)
+	for i := range involved {
+		u := s.users[involved[i].Id]
+		t.Accounts[u.Id] = s.account(createdBy, u).Id
+		t.States[u.Id] = account.TransactionPending
+	}
+
+	s.trans[t.Id] = t
+	return nil
+}
+
+func (s *store) VoidTransaction(ctx context.Context, t *account.Transaction, u *account.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t.CreatedBy != u.Id {
+		return account.ErrNotCreator
+	}
+
+	current, ok := s.trans[t.Id]
+	if !ok {
+		return account.ErrTransactionNotFound
+	}
+
+	states := make(map[uuid.UUID]account.TransactionState)
+	for uid := range current.Accounts {
+		next, err := current.State(uid).Transition(account.TransactionVoided)
+		if err != nil {
+			return err
+		}
+		states[uid] = next
+	}
+
+	current.States = states
+	*t = *current
+	return nil
+}
+
+// notifier records the transactions users were notified about
+type notifier struct {
+	mu       sync.Mutex
+	notified []*account.Transaction
+}
+
+func (n *notifier) NotifyTransaction(ctx context.Context, from *account.User, users []account.User, t *account.Transaction, p account.PlatformName) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.notified = append(n.notified, t)
+}
+
+// testServer is an API server backed by an in-process store, with alice
+// and bob sharing an account, and carol sharing nothing with either of them
+type testServer struct {
+	*httptest.Server
+	store    *store
+	notifier *notifier
+
+	alice, bob, carol *account.User
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := newStore()
+	n := &notifier{}
+	ts := &testServer{
+		Server:   httptest.NewServer(NewServer(s, n)),
+		store:    s,
+		notifier: n,
+		alice:    s.addUser("alice", "alice-token"),
+		bob:      s.addUser("bob", "bob-token"),
+		carol:    s.addUser("carol", "carol-token"),
+	}
+	t.Cleanup(ts.Close)
+
+	s.account(ts.alice, ts.bob).Balance = 12.5
+	return ts
+}
+
+// do sends a request as the user with token, decoding the response into v if
+// it isn't nil, and returns the status code
+func (ts *testServer) do(t *testing.T, method, path, token, body string, v interface{}) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, ts.URL+Prefix+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s: expected a JSON response, got '%s'", method, path, ct)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: failed to decode response: %v", method, path, err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+func TestAuthentication(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{name: "missing", header: "", want: http.StatusUnauthorized},
+		{name: "not bearer", header: "alice-token", want: http.StatusUnauthorized},
+		{name: "invalid", header: "Bearer nope", want: http.StatusUnauthorized},
+		{name: "valid", header: "Bearer alice-token", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+Prefix+"me", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tt.name, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, resp.StatusCode)
+		}
+	}
+
+	// the spec doesn't need a token
+	if status := ts.do(t, http.MethodGet, "openapi.json", "", "", &map[string]interface{}{}); status != http.StatusOK {
+		t.Errorf("expected the spec to be served without a token, got %d", status)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	ts := newTestServer(t)
+
+	if status := ts.do(t, http.MethodGet, "nope", "alice-token", "", nil); status != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown path, got %d", status)
+	}
+	if status := ts.do(t, http.MethodDelete, "me", "alice-token", "", nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for the wrong method, got %d", status)
+	}
+	if status := ts.do(t, http.MethodGet, "payments", "alice-token", "", nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for GET /payments, got %d", status)
+	}
+}
+
+func TestUsersArePublic(t *testing.T) {
+	ts := newTestServer(t)
+
+	var users []map[string]interface{}
+	if status := ts.do(t, http.MethodGet, "users", "alice-token", "", &users); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+
+	// only bob shares an account with alice
+	if len(users) != 1 || users[0]["id"] != ts.bob.Id.String() {
+		t.Fatalf("expected only bob, got %v", users)
+	}
+	for _, field := range []string{"platform_ids", "timezone", "reminders_disabled", "last_reminded_at"} {
+		if _, ok := users[0][field]; ok {
+			t.Errorf("expected %s not to be exposed, got %v", field, users[0])
+		}
+	}
+
+	if status := ts.do(t, http.MethodGet, "users", "carol-token", "", &users); status != http.StatusOK || len(users) != 0 {
+		t.Errorf("expected carol to see no one, got %d: %v", status, users)
+	}
+
+	var u map[string]interface{}
+	if status := ts.do(t, http.MethodGet, "users/"+ts.bob.Id.String(), "alice-token", "", &u); status != http.StatusOK {
+		t.Errorf("expected alice to see bob, got %d", status)
+	}
+	if _, ok := u["platform_ids"]; ok {
+		t.Errorf("expected platform_ids not to be exposed, got %v", u)
+	}
+	if status := ts.do(t, http.MethodGet, "users/"+ts.carol.Id.String(), "alice-token", "", nil); status != http.StatusNotFound {
+		t.Errorf("expected alice not to see carol, got %d", status)
+	}
+	if status := ts.do(t, http.MethodGet, "users/"+ts.alice.Id.String(), "alice-token", "", nil); status != http.StatusOK {
+		t.Errorf("expected alice to see herself, got %d", status)
+	}
+	if status := ts.do(t, http.MethodGet, "users/nope", "alice-token", "", nil); status != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid ID, got %d", status)
+	}
+
+	var accts []map[string]interface{}
+	if status := ts.do(t, http.MethodGet, "accounts", "bob-token", "", &accts); status != http.StatusOK || len(accts) != 1 {
+		t.Fatalf("expected bob to have 1 account, got %d: %v", status, accts)
+	}
+	for _, side := range []string{"creator", "subject"} {
+		if _, ok := accts[0][side].(map[string]interface{})["platform_ids"]; ok {
+			t.Errorf("expected the %s's platform_ids not to be exposed, got %v", side, accts[0][side])
+		}
+	}
+}
+
+func TestBalances(t *testing.T) {
+	ts := newTestServer(t)
+
+	for token, want := range map[string]float64{"alice-token": 12.5, "bob-token": -12.5} {
+		var balances []struct {
+			User    publicUser `json:"user"`
+			Balance float64    `json:"balance"`
+		}
+		if status := ts.do(t, http.MethodGet, "balances", token, "", &balances); status != http.StatusOK {
+			t.Fatalf("expected 200, got %d", status)
+		}
+
+		if len(balances) != 1 || balances[0].Balance != want {
+			t.Errorf("%s: expected a balance of %v, got %v", token, want, balances)
+		}
+	}
+}
+
+func TestCreateTransaction(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want int
+	}{
+		{name: "invalid body", path: "transactions", body: "{", want: http.StatusBadRequest},
+		{name: "zero amount", path: "transactions", body: `{"users": ["` + ts.bob.Id.String() + `"], "amount": 0}`, want: http.StatusBadRequest},
+		{name: "no users", path: "transactions", body: `{"users": [], "amount": 10}`, want: http.StatusBadRequest},
+		{name: "yourself", path: "transactions", body: `{"users": ["` + ts.alice.Id.String() + `"], "amount": 10}`, want: http.StatusBadRequest},
+		{name: "unknown user", path: "transactions", body: `{"users": ["` + uuid.Must(uuid.NewV4()).String() + `"], "amount": 10}`, want: http.StatusBadRequest},
+		{name: "negative payment", path: "payments", body: `{"user": "` + ts.bob.Id.String() + `", "amount": -5}`, want: http.StatusBadRequest},
+		{name: "charge", path: "transactions", body: `{"users": ["` + ts.bob.Id.String() + `", "` + ts.carol.Id.String() + `"], "amount": 30, "description": "pizza"}`, want: http.StatusCreated},
+		{name: "payment", path: "payments", body: `{"user": "` + ts.bob.Id.String() + `", "amount": 5}`, want: http.StatusCreated},
+	}
+
+	created := 0
+	for _, tt := range tests {
+		var tr account.Transaction
+		status := ts.do(t, http.MethodPost, tt.path, "alice-token", tt.body, &tr)
+		if status != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, status)
+			continue
+		}
+		if status != http.StatusCreated {
+			continue
+		}
+
+		created++
+		if tr.CreatedBy != ts.alice.Id {
+			t.Errorf("%s: expected alice to be the creator, got %s", tt.name, tr.CreatedBy)
+		}
+		for uid := range tr.Accounts {
+			if tr.State(uid) != account.TransactionPending {
+				t.Errorf("%s: expected every leg to be pending, got %v", tt.name, tr.States)
+			}
+		}
+	}
+
+	if len(ts.notifier.notified) != created {
+		t.Errorf("expected %d notifications, got %d", created, len(ts.notifier.notified))
+	}
+
+	var list transactionList
+	if status := ts.do(t, http.MethodGet, "transactions?limit=1", "bob-token", "", &list); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if list.Total != 2 || len(list.Transactions) != 1 || list.Limit != 1 {
+		t.Errorf("expected 1 of 2 transactions, got %d of %d", len(list.Transactions), list.Total)
+	}
+	if status := ts.do(t, http.MethodGet, "transactions", "carol-token", "", &list); status != http.StatusOK || list.Total != 1 {
+		t.Errorf("expected carol to see 1 transaction, got %d: %d", status, list.Total)
+	}
+
+	for _, query := range []string{"limit=0", "limit=501", "offset=-1", "state=nope", "since=yesterday", "with=nope"} {
+		if status := ts.do(t, http.MethodGet, "transactions?"+query, "bob-token", "", nil); status != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, status)
+		}
+	}
+}
+
+func TestVoidTransaction(t *testing.T) {
+	ts := newTestServer(t)
+
+	var tr account.Transaction
+	body := `{"users": ["` + ts.bob.Id.String() + `"], "amount": 10}`
+	if status := ts.do(t, http.MethodPost, "transactions", "alice-token", body, &tr); status != http.StatusCreated {
+		t.Fatalf("failed to create transaction: %d", status)
+	}
+	path := "transactions/" + tr.Id.String()
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		want  int
+	}{
+		{name: "invalid id", path: "transactions/nope/void", token: "alice-token", want: http.StatusBadRequest},
+		{name: "unknown id", path: "transactions/" + uuid.Must(uuid.NewV4()).String() + "/void", token: "alice-token", want: http.StatusNotFound},
+		{name: "not involved", path: path + "/void", token: "carol-token", want: http.StatusNotFound},
+		{name: "not creator", path: path + "/void", token: "bob-token", want: http.StatusForbidden},
+		{name: "creator", path: path + "/void", token: "alice-token", want: http.StatusOK},
+		{name: "already voided", path: path + "/void", token: "alice-token", want: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		if status := ts.do(t, http.MethodPost, tt.path, tt.token, "", nil); status != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, status)
+		}
+	}
+
+	if status := ts.do(t, http.MethodGet, path, "bob-token", "", &tr); status != http.StatusOK {
+		t.Fatalf("expected bob to see the transaction, got %d", status)
+	}
+	if !tr.Voided() {
+		t.Errorf("expected the transaction to be voided, got states %v", tr.States)
+	}
+	if status := ts.do(t, http.MethodGet, path, "carol-token", "", nil); status != http.StatusNotFound {
+		t.Errorf("expected carol not to see the transaction, got %d", status)
+	}
+}
@@ -0,0 +1,215 @@
+package api
+
+import "net/http"
+
+// openAPI is the OpenAPI specification of the API
+const openAPI = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "balance",
+    "version": "1.0.0",
+    "description": "Track balances between people. Authenticate with a token from the /token chat command."
+  },
+  "servers": [{"url": "/api/v1"}],
+  "security": [{"bearer": []}],
+  "paths": {
+    "/me": {
+      "get": {
+        "summary": "Get the authenticated user",
+        "responses": {"200": {"description": "The user", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}}}
+      }
+    },
+    "/users": {
+      "get": {
+        "summary": "List the users the authenticated user shares an account with",
+        "responses": {"200": {"description": "Users", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/PublicUser"}}}}}}
+      }
+    },
+    "/users/{id}": {
+      "get": {
+        "summary": "Get a user the authenticated user shares an account with",
+        "parameters": [{"$ref": "#/components/parameters/id"}],
+        "responses": {
+          "200": {"description": "The user", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PublicUser"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/accounts": {
+      "get": {
+        "summary": "List the authenticated user's accounts",
+        "responses": {"200": {"description": "Accounts", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Account"}}}}}}
+      }
+    },
+    "/balances": {
+      "get": {
+        "summary": "List what each user owes the authenticated user",
+        "responses": {"200": {"description": "Balances", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Balance"}}}}}}
+      }
+    },
+    "/transactions": {
+      "get": {
+        "summary": "List transactions involving the authenticated user, newest first",
+        "parameters": [
+          {"name": "with", "in": "query", "schema": {"type": "string", "format": "uuid"}, "description": "Only transactions involving this user"},
+          {"name": "since", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "until", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "state", "in": "query", "schema": {"$ref": "#/components/schemas/TransactionState"}},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 500, "default": 50}},
+          {"name": "offset", "in": "query", "schema": {"type": "integer", "minimum": 0, "default": 0}}
+        ],
+        "responses": {
+          "200": {"description": "A page of transactions", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/TransactionList"}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Request an amount, split between users",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {
+          "type": "object",
+          "required": ["users", "amount"],
+          "properties": {
+            "users": {"type": "array", "items": {"type": "string", "format": "uuid"}},
+            "amount": {"type": "number"},
+            "description": {"type": "string"}
+          }
+        }}}},
+        "responses": {
+          "201": {"description": "The transaction, waiting on approval", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Transaction"}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/transactions/{id}": {
+      "get": {
+        "summary": "Get a transaction",
+        "parameters": [{"$ref": "#/components/parameters/id"}],
+        "responses": {
+          "200": {"description": "The transaction", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Transaction"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/transactions/{id}/void": {
+      "post": {
+        "summary": "Void a transaction the authenticated user created, reversing any accepted legs",
+        "parameters": [{"$ref": "#/components/parameters/id"}],
+        "responses": {
+          "200": {"description": "The voided transaction", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Transaction"}}}},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/payments": {
+      "post": {
+        "summary": "Record paying a user",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {
+          "type": "object",
+          "required": ["user", "amount"],
+          "properties": {
+            "user": {"type": "string", "format": "uuid"},
+            "amount": {"type": "number", "exclusiveMinimum": 0},
+            "description": {"type": "string"}
+          }
+        }}}},
+        "responses": {
+          "201": {"description": "The payment, waiting on approval", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Transaction"}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearer": {"type": "http", "scheme": "bearer"}
+    },
+    "parameters": {
+      "id": {"name": "id", "in": "path", "required": true, "schema": {"type": "string", "format": "uuid"}}
+    },
+    "responses": {
+      "Error": {"description": "An error", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "properties": {"error": {"type": "string"}}
+      },
+      "User": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string", "format": "uuid"},
+          "platform_ids": {"type": "object", "additionalProperties": {"type": "string"}},
+          "platform_usernames": {"type": "object", "additionalProperties": {"type": "string"}},
+          "placeholder": {"type": "boolean"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "updated_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "PublicUser": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string", "format": "uuid"},
+          "platform_usernames": {"type": "object", "additionalProperties": {"type": "string"}},
+          "placeholder": {"type": "boolean"}
+        }
+      },
+      "Account": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string", "format": "uuid"},
+          "creator_id": {"type": "string", "format": "uuid"},
+          "creator": {"$ref": "#/components/schemas/PublicUser"},
+          "subject_id": {"type": "string", "format": "uuid"},
+          "subject": {"$ref": "#/components/schemas/PublicUser"},
+          "balance": {"type": "number", "description": "What the subject owes the creator"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "updated_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Balance": {
+        "type": "object",
+        "properties": {
+          "user": {"$ref": "#/components/schemas/PublicUser"},
+          "balance": {"type": "number", "description": "What user owes the authenticated user, negative if the authenticated user owes them"}
+        }
+      },
+      "TransactionState": {
+        "type": "string",
+        "enum": ["pending", "accepted", "disputed", "voided"]
+      },
+      "Transaction": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string", "format": "uuid"},
+          "created_by": {"type": "string", "format": "uuid"},
+          "account_id": {"type": "object", "description": "The account of each involved user", "additionalProperties": {"type": "string", "format": "uuid"}},
+          "states": {"type": "object", "additionalProperties": {"$ref": "#/components/schemas/TransactionState"}},
+          "amount": {"type": "number"},
+          "kind": {"type": "string", "enum": ["charge", "payment"]},
+          "description": {"type": "string"},
+          "created_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "TransactionList": {
+        "type": "object",
+        "properties": {
+          "transactions": {"type": "array", "items": {"$ref": "#/components/schemas/Transaction"}},
+          "total": {"type": "integer"},
+          "limit": {"type": "integer"},
+          "offset": {"type": "integer"}
+        }
+      }
+    }
+  }
+}
+`
+
+// getOpenAPI handles GET /openapi.json
+func (s *Server) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write([]byte(openAPI)); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to write spec", err)
+	}
+}
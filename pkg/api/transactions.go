@@ -0,0 +1,253 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+)
+
+const (
+	// defaultLimit is the page size used when a request doesn't provide one
+	defaultLimit = 50
+
+	// maxLimit is the largest page size a request can ask for
+	maxLimit = 500
+)
+
+// transactionList is a page of transactions
+type transactionList struct {
+	Transactions []*account.Transaction `json:"transactions"`
+	Total        int                    `json:"total"`
+	Limit        int                    `json:"limit"`
+	Offset       int                    `json:"offset"`
+}
+
+// createTransactionRequest is the body of POST /transactions
+type createTransactionRequest struct {
+	// Users are the IDs of the users to split the amount between
+	Users       []uuid.UUID `json:"users"`
+	Amount      float64     `json:"amount"`
+	Description string      `json:"description"`
+}
+
+// createPaymentRequest is the body of POST /payments
+type createPaymentRequest struct {
+	// User is the ID of the user that was paid
+	User        uuid.UUID `json:"user"`
+	Amount      float64   `json:"amount"`
+	Description string    `json:"description"`
+}
+
+// parseFilter parses the query parameters of GET /transactions
+func parseFilter(r *http.Request) (*account.TransactionFilter, string) {
+	q := r.URL.Query()
+	f := &account.TransactionFilter{Limit: defaultLimit}
+
+	if v := q.Get("with"); v != "" {
+		id, err := uuid.FromString(v)
+		if err != nil {
+			return nil, "invalid with, expected a user id"
+		}
+		f.With = &id
+	}
+
+	for name, t := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, "invalid " + name + ", expected an RFC3339 time"
+		}
+		*t = parsed
+	}
+
+	switch state := account.TransactionState(q.Get("state")); state {
+	case "", account.TransactionPending, account.TransactionAccepted, account.TransactionDisputed, account.TransactionVoided:
+		f.State = state
+	default:
+		return nil, "invalid state"
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return nil, "invalid limit, expected 1-" + strconv.Itoa(maxLimit)
+		}
+		f.Limit = limit
+	}
+
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return nil, "invalid offset"
+		}
+		f.Offset = offset
+	}
+
+	return f, ""
+}
+
+// listTransactions handles GET /transactions
+func (s *Server) listTransactions(w http.ResponseWriter, r *http.Request) {
+	f, reason := parseFilter(r)
+	if reason != "" {
+		writeError(w, http.StatusBadRequest, reason, nil)
+		return
+	}
+
+	trans, total, err := s.a.ListTransactions(r.Context(), userFrom(r), f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list transactions", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, transactionList{
+		Transactions: trans,
+		Total:        total,
+		Limit:        f.Limit,
+		Offset:       f.Offset,
+	})
+}
+
+// findTransaction returns the transaction in the path of a request, if the
+// authenticated user is involved in it
+func (s *Server) findTransaction(w http.ResponseWriter, r *http.Request) *account.Transaction {
+	if _, err := uuid.FromString(pathID(r)); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid transaction id", nil)
+		return nil
+	}
+
+	t, err := s.a.GetTransaction(r.Context(), pathID(r))
+	if err == account.ErrTransactionNotFound {
+		writeError(w, http.StatusNotFound, "transaction not found", nil)
+		return nil
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to get transaction", err)
+		return nil
+	}
+
+	// don't leak transactions that other users are involved in
+	u := userFrom(r)
+	if _, ok := t.Accounts[u.Id]; !ok && t.CreatedBy != u.Id {
+		writeError(w, http.StatusNotFound, "transaction not found", nil)
+		return nil
+	}
+
+	return t
+}
+
+// getTransaction handles GET /transactions/{id}
+func (s *Server) getTransaction(w http.ResponseWriter, r *http.Request) {
+	if t := s.findTransaction(w, r); t != nil {
+		writeJSON(w, http.StatusOK, t)
+	}
+}
+
+// voidTransaction handles POST /transactions/{id}/void
+func (s *Server) voidTransaction(w http.ResponseWriter, r *http.Request) {
+	t := s.findTransaction(w, r)
+	if t == nil {
+		return
+	}
+
+	err := s.a.VoidTransaction(r.Context(), t, userFrom(r))
+	if errors.Is(err, account.ErrNotCreator) {
+		writeError(w, http.StatusForbidden, "only the creator of a transaction can void it", nil)
+		return
+	} else if errors.Is(err, account.ErrInvalidTransition) {
+		writeError(w, http.StatusConflict, "transaction is already voided", nil)
+		return
+	} else if errors.Is(err, account.ErrTransactionNotFound) {
+		writeError(w, http.StatusNotFound, "transaction not found", nil)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to void transaction", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, t)
+}
+
+// createTransaction handles POST /transactions
+func (s *Server) createTransaction(w http.ResponseWriter, r *http.Request) {
+	var req createTransactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body", nil)
+		return
+	}
+
+	if req.Amount == 0 {
+		writeError(w, http.StatusBadRequest, "amount cannot be 0", nil)
+		return
+	}
+
+	s.create(w, r, req.Users, &account.Transaction{
+		Amount:      req.Amount,
+		Kind:        account.TransactionCharge,
+		Description: req.Description,
+	})
+}
+
+// createPayment handles POST /payments
+func (s *Server) createPayment(w http.ResponseWriter, r *http.Request) {
+	var req createPaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body", nil)
+		return
+	}
+
+	if req.Amount <= 0 {
+		writeError(w, http.StatusBadRequest, "amount must be positive", nil)
+		return
+	}
+
+	s.create(w, r, []uuid.UUID{req.User}, &account.Transaction{
+		Amount:      req.Amount,
+		Kind:        account.TransactionPayment,
+		Description: req.Description,
+	})
+}
+
+// create creates a transaction between the authenticated user and ids, and
+// asks each of them to approve it
+func (s *Server) create(w http.ResponseWriter, r *http.Request, ids []uuid.UUID, t *account.Transaction) {
+	from := userFrom(r)
+	if len(ids) == 0 {
+		writeError(w, http.StatusBadRequest, "provide at least one user", nil)
+		return
+	}
+
+	users := make([]account.User, 0, len(ids))
+	for _, id := range ids {
+		if id == from.Id {
+			writeError(w, http.StatusBadRequest, "cannot create a transaction with yourself", nil)
+			return
+		}
+
+		u, err := s.a.GetUser(r.Context(), id)
+		if err == account.ErrUserNotFound {
+			writeError(w, http.StatusBadRequest, "user "+id.String()+" not found", nil)
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to get user", err)
+			return
+		}
+		users = append(users, *u)
+	}
+
+	if err := s.a.CreateTransaction(r.Context(), t, from, users); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create transaction", err)
+		return
+	}
+
+	s.h.NotifyTransaction(r.Context(), from, users, t, s.Platform)
+	writeJSON(w, http.StatusCreated, t)
+}
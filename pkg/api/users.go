@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+)
+
+// publicUser is what a user can see about the users they share an account with
+type publicUser struct {
+	Id                uuid.UUID                       `json:"id"`
+	PlatformUsernames map[account.PlatformName]string `json:"platform_usernames"`
+	Placeholder       bool                            `json:"placeholder"`
+}
+
+// newPublicUser returns the public fields of a user
+func newPublicUser(u *account.User) *publicUser {
+	if u == nil {
+		return nil
+	}
+
+	return &publicUser{
+		Id:                u.Id,
+		PlatformUsernames: u.PlatformUsernames,
+		Placeholder:       u.Placeholder,
+	}
+}
+
+// publicAccount is an account, with only the public fields of its users
+type publicAccount struct {
+	Id        uuid.UUID   `json:"id"`
+	CreatorId uuid.UUID   `json:"creator_id"`
+	Creator   *publicUser `json:"creator"`
+	SubjectId uuid.UUID   `json:"subject_id"`
+	Subject   *publicUser `json:"subject"`
+	Balance   float64     `json:"balance"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// balance is the balance between the authenticated user and another user
+type balance struct {
+	User *publicUser `json:"user"`
+
+	// Balance is how much User owes the authenticated user, negative if the
+	// authenticated user owes them
+	Balance float64 `json:"balance"`
+}
+
+// counterparties returns the users that u shares an account with
+func (s *Server) counterparties(ctx context.Context, u *account.User) ([]*account.User, error) {
+	accts, err := s.a.FindAccounts(ctx, u)
+	if err != nil && err != account.ErrAccountNotFound {
+		return nil, err
+	}
+
+	users := make([]*account.User, 0, len(accts))
+	for _, a := range accts {
+		other := a.Creator
+		if a.CreatorId == u.Id {
+			other = a.Subject
+		}
+		if other != nil {
+			users = append(users, other)
+		}
+	}
+
+	return users, nil
+}
+
+// getMe handles GET /me
+func (s *Server) getMe(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, userFrom(r))
+}
+
+// listUsers handles GET /users
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := s.counterparties(r.Context(), userFrom(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list users", err)
+		return
+	}
+
+	public := make([]*publicUser, 0, len(users))
+	for _, u := range users {
+		public = append(public, newPublicUser(u))
+	}
+
+	writeJSON(w, http.StatusOK, public)
+}
+
+// getUser handles GET /users/{id}, only users that share an account with the
+// authenticated user can be seen
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.FromString(pathID(r))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid user id", nil)
+		return
+	}
+
+	me := userFrom(r)
+	if id == me.Id {
+		writeJSON(w, http.StatusOK, newPublicUser(me))
+		return
+	}
+
+	users, err := s.counterparties(r.Context(), me)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to get user", err)
+		return
+	}
+
+	for _, u := range users {
+		if u.Id == id {
+			writeJSON(w, http.StatusOK, newPublicUser(u))
+			return
+		}
+	}
+
+	writeError(w, http.StatusNotFound, "user not found", nil)
+}
+
+// listAccounts handles GET /accounts
+func (s *Server) listAccounts(w http.ResponseWriter, r *http.Request) {
+	accts, err := s.a.FindAccounts(r.Context(), userFrom(r))
+	if err != nil && err != account.ErrAccountNotFound {
+		writeError(w, http.StatusInternalServerError, "failed to list accounts", err)
+		return
+	}
+
+	public := make([]publicAccount, 0, len(accts))
+	for _, a := range accts {
+		public = append(public, publicAccount{
+			Id:        a.Id,
+			CreatorId: a.CreatorId,
+			Creator:   newPublicUser(a.Creator),
+			SubjectId: a.SubjectId,
+			Subject:   newPublicUser(a.Subject),
+			Balance:   a.Balance,
+			CreatedAt: a.CreatedAt,
+			UpdatedAt: a.UpdatedAt,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, public)
+}
+
+// listBalances handles GET /balances
+func (s *Server) listBalances(w http.ResponseWriter, r *http.Request) {
+	u := userFrom(r)
+	accts, err := s.a.FindAccounts(r.Context(), u)
+	if err != nil && err != account.ErrAccountNotFound {
+		writeError(w, http.StatusInternalServerError, "failed to list balances", err)
+		return
+	}
+
+	balances := make([]balance, 0, len(accts))
+	for _, a := range accts {
+		// account balances are what the subject owes the creator
+		b := balance{User: newPublicUser(a.Subject), Balance: a.Balance}
+		if a.SubjectId == u.Id {
+			b = balance{User: newPublicUser(a.Creator), Balance: -a.Balance}
+		}
+		balances = append(balances, b)
+	}
+
+	writeJSON(w, http.StatusOK, balances)
+}
@@ -0,0 +1,107 @@
+// Package dispatcher handles messages concurrently, while keeping the messages
+// of each chat in the order they were sent
+package dispatcher
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jaredallard/balance/pkg/metrics"
+	"github.com/jaredallard/balance/pkg/social"
+)
+
+// HandlerFunc handles a message
+type HandlerFunc func(msg *social.Message)
+
+// job is a message waiting to be handled
+type job struct {
+	msg    social.Message
+	queued time.Time
+}
+
+// Dispatcher hands messages to a pool of workers. Every chat is assigned to a
+// single worker, so messages in a chat are handled one at a time, in order,
+// while messages in different chats can be handled at the same time.
+type Dispatcher struct {
+	fn     HandlerFunc
+	queues []chan job
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher with workers workers, each with a queue
+// of queueSize messages, and starts them
+func NewDispatcher(workers, queueSize int, fn HandlerFunc) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+
+	d := &Dispatcher{
+		fn:     fn,
+		queues: make([]chan job, workers),
+	}
+
+	for i := range d.queues {
+		d.queues[i] = make(chan job, queueSize)
+
+		d.wg.Add(1)
+		go d.work(strconv.Itoa(i), d.queues[i])
+	}
+
+	return d
+}
+
+// work handles the messages in a queue until it's closed
+func (d *Dispatcher) work(worker string, queue <-chan job) {
+	defer d.wg.Done()
+
+	for j := range queue {
+		metrics.QueueDepth.WithLabelValues(worker).Dec()
+		metrics.QueueWait.Observe(time.Since(j.queued).Seconds())
+		d.fn(&j.msg)
+	}
+}
+
+// queue returns the index of the queue a chat's messages go to
+func (d *Dispatcher) queue(msg *social.Message) int {
+	h := fnv.New32a()
+	h.Write([]byte(string(msg.PlatformName) + ":" + msg.ChatID))
+	return int(h.Sum32() % uint32(len(d.queues)))
+}
+
+// Dispatch queues a message to be handled. When its chat's queue is full,
+// Dispatch blocks until there's room, or ctx is done, so that we stop reading
+// messages faster than we can handle them.
+func (d *Dispatcher) Dispatch(ctx context.Context, msg social.Message) error {
+	i := d.queue(&msg)
+	depth := metrics.QueueDepth.WithLabelValues(strconv.Itoa(i))
+	j := job{msg: msg, queued: time.Now()}
+
+	// count the message before it's queued, so a worker can't take it first
+	depth.Inc()
+	select {
+	case d.queues[i] <- j:
+		return nil
+	default:
+	}
+
+	metrics.QueueFull.WithLabelValues(strconv.Itoa(i)).Inc()
+	select {
+	case d.queues[i] <- j:
+		return nil
+	case <-ctx.Done():
+		depth.Dec()
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting messages, and waits for every queued message to be
+// handled. Dispatch must not be called after Close.
+func (d *Dispatcher) Close() {
+	for _, q := range d.queues {
+		close(q)
+	}
+	d.wg.Wait()
+}
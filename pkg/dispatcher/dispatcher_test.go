@@ -0,0 +1,170 @@
+package dispatcher_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/dispatcher"
+	"github.com/jaredallard/balance/pkg/social"
+	"github.com/jaredallard/balance/pkg/social/socialtest"
+	"github.com/jaredallard/balance/pkg/social/telegram/telegramtest"
+)
+
+// wait waits for wg, failing if it takes too long
+func wait(t *testing.T, wg *sync.WaitGroup) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(socialtest.Timeout):
+		t.Fatalf("messages weren't handled after %v", socialtest.Timeout)
+	}
+}
+
+func TestDispatchKeepsChatOrder(t *testing.T) {
+	const chats, messages = 10, 50
+
+	var mu sync.Mutex
+	handled := make(map[string][]int)
+
+	d := dispatcher.NewDispatcher(4, 2, func(msg *social.Message) {
+		i, _ := strconv.Atoi(msg.ID)
+
+		mu.Lock()
+		handled[msg.ChatID] = append(handled[msg.ChatID], i)
+		mu.Unlock()
+	})
+
+	for i := 0; i < messages; i++ {
+		for c := 0; c < chats; c++ {
+			msg := social.Message{ID: strconv.Itoa(i), ChatID: strconv.Itoa(c), PlatformName: account.PlatformTelegram}
+			if err := d.Dispatch(context.Background(), msg); err != nil {
+				t.Fatalf("failed to dispatch message: %v", err)
+			}
+		}
+	}
+	d.Close()
+
+	for c := 0; c < chats; c++ {
+		got := handled[strconv.Itoa(c)]
+		if len(got) != messages {
+			t.Fatalf("expected %d messages in chat %d, got %d", messages, c, len(got))
+		}
+		for i, id := range got {
+			if id != i {
+				t.Fatalf("expected the messages of chat %d in order, got %v", c, got)
+			}
+		}
+	}
+}
+
+func TestDispatchFullQueue(t *testing.T) {
+	release := make(chan struct{})
+	d := dispatcher.NewDispatcher(1, 1, func(msg *social.Message) { <-release })
+	defer d.Close()
+	defer close(release)
+
+	// one message is being handled, and one is queued
+	msg := social.Message{ChatID: "1001", PlatformName: account.PlatformTelegram}
+	for i := 0; i < 2; i++ {
+		if err := d.Dispatch(context.Background(), msg); err != nil {
+			t.Fatalf("failed to dispatch message: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// the queue could have room if the worker hasn't taken the first message yet
+	err := d.Dispatch(ctx, msg)
+	if err == nil {
+		err = d.Dispatch(ctx, msg)
+	}
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected dispatching to a full queue to wait until ctx is done, got %v", err)
+	}
+}
+
+// TestDispatchSameUser handles messages from one user in many chats at once,
+// like main does. Handlers change the user of their message, which must not
+// change the user that other messages, or the provider's cache, see. Run with
+// -race.
+func TestDispatchSameUser(t *testing.T) {
+	const chats, messages = 8, 100
+
+	accounts := socialtest.NewAccounts()
+	alice := accounts.AddUser(&account.User{
+		PlatformIds:       map[account.PlatformName]string{account.PlatformTelegram: "1001"},
+		PlatformUsernames: map[account.PlatformName]string{account.PlatformTelegram: "alice"},
+	})
+
+	p, platform := telegramtest.NewProvider(t, accounts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := p.CreateStream(ctx)
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(messages)
+
+	var mu sync.Mutex
+	var wrongUser int
+
+	d := dispatcher.NewDispatcher(4, 10, func(msg *social.Message) {
+		defer wg.Done()
+
+		u := msg.From
+		if u == nil || u.Id != alice.Id || u.PlatformUsernames[account.PlatformTelegram] != "alice" {
+			mu.Lock()
+			wrongUser++
+			mu.Unlock()
+			return
+		}
+
+		// like /reminders and /link do
+		u.RemindersDisabled = !u.RemindersDisabled
+		u.PlatformUsernames["other"] = msg.ChatID
+	})
+	defer d.Close()
+
+	go func() {
+		for msg := range stream {
+			if err := d.Dispatch(ctx, msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < messages; i++ {
+		platform.Deliver(socialtest.Incoming{
+			ChatID:   strconv.Itoa(2000 + i%chats),
+			UserID:   "1001",
+			Username: "alice",
+			Text:     "/status",
+		})
+	}
+	wait(t, &wg)
+
+	if wrongUser != 0 {
+		t.Errorf("expected every message to be from alice, %d weren't", wrongUser)
+	}
+
+	// only the first message looked alice up, the rest came from the cache
+	if lookups := accounts.Lookups(); lookups != 1 {
+		t.Errorf("expected 1 lookup, got %d", lookups)
+	}
+}
@@ -0,0 +1,258 @@
+// Package export exports a user's transaction history
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+	log "github.com/sirupsen/logrus"
+)
+
+// Format is a file format that history can be exported as
+type Format string
+
+const (
+	FormatCSV  Format = "csv"
+	FormatJSON Format = "json"
+)
+
+// Currency is the currency of every amount
+// TODO: don't depend on USD
+const Currency = "USD"
+
+// ParseFormat parses the name of a format
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatCSV, FormatJSON, FormatLedger, FormatHLedger, FormatBeancount:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format '%s'", s)
+	}
+}
+
+// Extension returns the file extension of a format
+func (f Format) Extension() string {
+	switch f {
+	case FormatLedger, FormatHLedger:
+		return ".journal"
+	default:
+		return "." + string(f)
+	}
+}
+
+// Record is a single transaction in an export, from the perspective of the
+// user whose history is being exported
+type Record struct {
+	Id          uuid.UUID                `json:"id"`
+	Date        time.Time                `json:"date"`
+	Kind        account.TransactionKind  `json:"kind"`
+	From        string                   `json:"from"`
+	To          []string                 `json:"to"`
+	Description string                   `json:"description"`
+	Currency    string                   `json:"currency"`
+	State       account.TransactionState `json:"state"`
+
+	// Amount is how much the user is owed because of this transaction, negative
+	// if they owe it. This is the full amount, even if it isn't accepted yet.
+	Amount float64 `json:"amount"`
+
+	// Balance is the running balance of the user, only accepted legs are counted
+	Balance float64 `json:"balance"`
+}
+
+// Store is the part of the account client transactions are exported from
+type Store interface {
+	GetUser(ctx context.Context, id uuid.UUID) (*account.User, error)
+	GetAllTransactionsByUser(ctx context.Context, u *account.User, filterUser *account.User) ([]*account.Transaction, error)
+}
+
+// Exporter exports transaction history
+type Exporter struct {
+	a Store
+
+	// Platform is the platform whose usernames are used in exports
+	Platform account.PlatformName
+}
+
+// NewExporter creates a new exporter
+func NewExporter(a Store, p account.PlatformName) *Exporter {
+	return &Exporter{a: a, Platform: p}
+}
+
+// name returns the name of a user in an export
+func (e *Exporter) name(ctx context.Context, id uuid.UUID) string {
+	u, err := e.a.GetUser(ctx, id)
+	if err != nil {
+		log.Warnf("failed to find user %s for export: %v", id, err)
+		return id.String()
+	}
+
+	if username := u.PlatformUsernames[e.Platform]; username != "" {
+		return username
+	}
+	return id.String()
+}
+
+// leg is a leg of a transaction between the user being exported and another user
+type leg struct {
+	other uuid.UUID
+	state account.TransactionState
+
+	// amount is how much the other user owes because of this leg, negative if
+	// they're owed it
+	amount float64
+}
+
+// legs returns the legs of t that are between u and another user, or only
+// with if it's set
+func legs(u *account.User, with *account.User, t *account.Transaction) []leg {
+	ls := []leg{}
+	for uid := range t.Accounts {
+		l := leg{other: uid, state: t.State(uid), amount: t.Share()}
+		if uid == u.Id {
+			l.other = t.CreatedBy
+			l.amount = -l.amount
+		} else if t.CreatedBy != u.Id {
+			continue
+		}
+
+		if with != nil && l.other != with.Id {
+			continue
+		}
+		ls = append(ls, l)
+	}
+
+	// map iteration order is random, keep output stable
+	sort.Slice(ls, func(i, j int) bool {
+		return ls[i].other.String() < ls[j].other.String()
+	})
+
+	return ls
+}
+
+// transactions returns u's transactions, oldest first
+func (e *Exporter) transactions(ctx context.Context, u *account.User, with *account.User) ([]*account.Transaction, error) {
+	trans, err := e.a.GetAllTransactionsByUser(ctx, u, with)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(trans, func(i, j int) bool {
+		return trans[i].CreatedAt.Before(trans[j].CreatedAt)
+	})
+
+	return trans, nil
+}
+
+// Records returns u's transactions since since as records, oldest first. If
+// with is set, only transactions between u and with are included.
+func (e *Exporter) Records(ctx context.Context, u *account.User, with *account.User, since time.Time) ([]Record, error) {
+	trans, err := e.transactions(ctx, u, with)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []Record{}
+	balance := 0.0
+	for _, t := range trans {
+		r := Record{
+			Id:          t.Id,
+			Date:        t.CreatedAt,
+			Kind:        t.Kind,
+			From:        e.name(ctx, t.CreatedBy),
+			Description: t.Description,
+			Currency:    Currency,
+			State:       account.TransactionAccepted,
+		}
+		if r.Kind == "" {
+			r.Kind = account.TransactionCharge
+		}
+
+		for uid := range t.Accounts {
+			r.To = append(r.To, e.name(ctx, uid))
+		}
+
+		for _, l := range legs(u, with, t) {
+			if l.state != account.TransactionAccepted && r.State == account.TransactionAccepted {
+				r.State = l.state
+			}
+
+			r.Amount += l.amount
+			if l.state == account.TransactionAccepted {
+				balance += l.amount
+			}
+		}
+		sort.Strings(r.To)
+		r.Balance = balance
+
+		if t.CreatedAt.Before(since) {
+			continue
+		}
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+// Export writes u's transactions since since to w in format f. If with is set,
+// only transactions between u and with are included.
+func (e *Exporter) Export(ctx context.Context, w io.Writer, f Format, u *account.User, with *account.User, since time.Time) error {
+	if f.isJournal() {
+		return e.writeJournal(ctx, w, f, u, with, since)
+	}
+
+	records, err := e.Records(ctx, u, with, since)
+	if err != nil {
+		return err
+	}
+
+	switch f {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case FormatCSV:
+		return writeCSV(w, records)
+	default:
+		return fmt.Errorf("unknown format '%s'", f)
+	}
+}
+
+// writeCSV writes records as a CSV with a header
+func writeCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"date", "id", "kind", "from", "to", "description", "amount", "currency", "state", "balance"})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		err := cw.Write([]string{
+			r.Date.UTC().Format(time.RFC3339),
+			r.Id.String(),
+			string(r.Kind),
+			r.From,
+			strings.Join(r.To, ";"),
+			r.Description,
+			strconv.FormatFloat(r.Amount, 'f', 2, 64),
+			r.Currency,
+			string(r.State),
+			strconv.FormatFloat(r.Balance, 'f', 2, 64),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,312 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+)
+
+const (
+	FormatLedger    Format = "ledger"
+	FormatHLedger   Format = "hledger"
+	FormatBeancount Format = "beancount"
+)
+
+const (
+	// receivableAccount holds what other users owe the user being exported
+	receivableAccount = "Assets:Receivable"
+
+	// payableAccount holds what the user being exported owes other users
+	payableAccount = "Liabilities:Payable"
+
+	// sharedAccount is what charges are balanced against, what the user paid
+	// for other users, or what other users paid for them
+	sharedAccount = "Expenses:Shared"
+
+	// cashAccount is what payments are balanced against
+	cashAccount = "Assets:Cash"
+
+	// openingAccount is what balances from before an export are balanced against
+	openingAccount = "Equity:Opening-Balances"
+)
+
+// isJournal returns if a format is a plain text accounting journal
+func (f Format) isJournal() bool {
+	return f == FormatLedger || f == FormatHLedger || f == FormatBeancount
+}
+
+// posting is a line of a journal entry
+type posting struct {
+	account string
+	amount  float64
+}
+
+// entry is a journal entry
+type entry struct {
+	id          uuid.UUID
+	date        time.Time
+	payee       string
+	description string
+	postings    []posting
+}
+
+// accountName returns a journal account for a user, beancount only allows
+// capitalized letters, numbers and dashes in account names
+func accountName(f Format, parent, name string) string {
+	if f != FormatBeancount {
+		return parent + ":" + strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) || r == ':' || r == ';' {
+				return '_'
+			}
+			return r
+		}, name)
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return '-'
+		}
+		return r
+	}, name)
+	if name == "" || !unicode.IsLetter(rune(name[0])) && !unicode.IsDigit(rune(name[0])) {
+		name = "U" + name
+	}
+
+	return parent + ":" + strings.ToUpper(name[:1]) + name[1:]
+}
+
+// entries returns the journal entries of u's accepted transactions. Anything
+// from before since is collapsed into an opening balance entry.
+func (e *Exporter) entries(ctx context.Context, f Format, u *account.User, with *account.User, since time.Time) ([]entry, error) {
+	trans, err := e.transactions(ctx, u, with)
+	if err != nil {
+		return nil, err
+	}
+
+	// opening is the balance of each user before since
+	opening := make(map[uuid.UUID]float64)
+	entries := []entry{}
+	for _, t := range trans {
+		en := entry{
+			id:          t.Id,
+			date:        t.CreatedAt,
+			payee:       e.name(ctx, t.CreatedBy),
+			description: t.Description,
+		}
+
+		total := 0.0
+		for _, l := range legs(u, with, t) {
+			if l.state != account.TransactionAccepted {
+				continue
+			}
+
+			if t.CreatedAt.Before(since) {
+				opening[l.other] += l.amount
+				continue
+			}
+
+			// a payment to someone pays off what you owe them, and a payment from
+			// someone pays off what they owe you
+			parent := receivableAccount
+			if (l.amount < 0) != (t.Kind == account.TransactionPayment) {
+				parent = payableAccount
+			}
+
+			en.postings = append(en.postings, posting{accountName(f, parent, e.name(ctx, l.other)), l.amount})
+			total += l.amount
+		}
+
+		if len(en.postings) == 0 {
+			continue
+		}
+
+		balancing := sharedAccount
+		if t.Kind == account.TransactionPayment {
+			balancing = cashAccount
+		}
+		en.postings = append(en.postings, posting{balancing, -total})
+		entries = append(entries, en)
+	}
+
+	if len(opening) != 0 {
+		ids := make([]uuid.UUID, 0, len(opening))
+		for id := range opening {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			return ids[i].String() < ids[j].String()
+		})
+
+		en := entry{date: since, description: "Opening balances"}
+		total := 0.0
+		for _, id := range ids {
+			amount := opening[id]
+			if amount == 0 {
+				continue
+			}
+
+			parent := receivableAccount
+			if amount < 0 {
+				parent = payableAccount
+			}
+			en.postings = append(en.postings, posting{accountName(f, parent, e.name(ctx, id)), amount})
+			total += amount
+		}
+
+		if len(en.postings) != 0 {
+			en.postings = append(en.postings, posting{openingAccount, -total})
+			entries = append([]entry{en}, entries...)
+		}
+	}
+
+	return entries, nil
+}
+
+// writeJournal writes u's transactions as a plain text accounting journal
+func (e *Exporter) writeJournal(ctx context.Context, w io.Writer, f Format, u *account.User, with *account.User, since time.Time) error {
+	entries, err := e.entries(ctx, f, u, with, since)
+	if err != nil {
+		return err
+	}
+
+	switch f {
+	case FormatBeancount:
+		return writeBeancount(w, entries)
+	default:
+		return writeLedger(w, f, entries)
+	}
+}
+
+// amount formats an amount of money for a journal, rounded to cents so that
+// entries always balance
+func amount(f Format, v float64) string {
+	s := fmt.Sprintf("%.2f", v)
+	if s == "-0.00" {
+		s = "0.00"
+	}
+
+	if f == FormatBeancount {
+		return s + " " + Currency
+	}
+
+	if strings.HasPrefix(s, "-") {
+		return "-$" + s[1:]
+	}
+	return "$" + s
+}
+
+// round rounds every posting to cents, with the last posting absorbing any
+// difference so that the entry still balances
+func (en *entry) round() {
+	total := 0.0
+	for i := range en.postings[:len(en.postings)-1] {
+		p := &en.postings[i]
+		p.amount = math.Round(p.amount*100) / 100
+		total += p.amount
+	}
+	en.postings[len(en.postings)-1].amount = -total
+}
+
+// clean removes anything from text that would break a journal line
+func clean(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// writeLedger writes entries in ledger or hledger syntax
+func writeLedger(w io.Writer, f Format, entries []entry) error {
+	dateFormat := "2006-01-02"
+	if f == FormatLedger {
+		dateFormat = "2006/01/02"
+	}
+
+	for _, en := range entries {
+		en.round()
+
+		desc := clean(en.description)
+		if en.payee != "" {
+			desc = clean(en.payee) + " | " + desc
+			if en.description == "" {
+				desc = clean(en.payee)
+			}
+		}
+
+		if _, err := fmt.Fprintf(w, "%s * %s\n", en.date.UTC().Format(dateFormat), desc); err != nil {
+			return err
+		}
+		if en.id != uuid.Nil {
+			if _, err := fmt.Fprintf(w, "    ; id: %s\n", en.id); err != nil {
+				return err
+			}
+		}
+		for _, p := range en.postings {
+			if _, err := fmt.Fprintf(w, "    %-50s  %s\n", p.account, amount(f, p.amount)); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// quote returns text as a beancount string
+func quote(text string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(clean(text)) + `"`
+}
+
+// writeBeancount writes entries in beancount syntax, opening every account
+// the first time it's used
+func writeBeancount(w io.Writer, entries []entry) error {
+	if _, err := fmt.Fprintf(w, "option \"operating_currency\" %s\n\n", quote(Currency)); err != nil {
+		return err
+	}
+
+	opened := make(map[string]bool)
+	for _, en := range entries {
+		en.round()
+
+		for _, p := range en.postings {
+			if opened[p.account] {
+				continue
+			}
+			opened[p.account] = true
+
+			if _, err := fmt.Fprintf(w, "%s open %s %s\n", en.date.UTC().Format("2006-01-02"), p.account, Currency); err != nil {
+				return err
+			}
+		}
+
+		header := quote(en.description)
+		if en.payee != "" {
+			header = quote(en.payee) + " " + header
+		}
+		if _, err := fmt.Fprintf(w, "%s * %s\n", en.date.UTC().Format("2006-01-02"), header); err != nil {
+			return err
+		}
+		if en.id != uuid.Nil {
+			if _, err := fmt.Fprintf(w, "  id: %s\n", quote(en.id.String())); err != nil {
+				return err
+			}
+		}
+		for _, p := range en.postings {
+			if _, err := fmt.Fprintf(w, "  %-50s  %s\n", p.account, amount(FormatBeancount, p.amount)); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
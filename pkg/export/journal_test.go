@@ -0,0 +1,328 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+)
+
+// store is an in-memory Store
+type store struct {
+	users map[uuid.UUID]*account.User
+	trans []*account.Transaction
+}
+
+func (s *store) GetUser(ctx context.Context, id uuid.UUID) (*account.User, error) {
+	u, ok := s.users[id]
+	if !ok {
+		return nil, account.ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (s *store) GetAllTransactionsByUser(ctx context.Context, u *account.User, filterUser *account.User) ([]*account.Transaction, error) {
+	trans := []*account.Transaction{}
+	for _, t := range s.trans {
+		if _, ok := t.Accounts[u.Id]; !ok && t.CreatedBy != u.Id {
+			continue
+		}
+		if filterUser != nil {
+			if _, ok := t.Accounts[filterUser.Id]; !ok && t.CreatedBy != filterUser.Id {
+				continue
+			}
+		}
+		trans = append(trans, t)
+	}
+	return trans, nil
+}
+
+func (s *store) addUser(name string) *account.User {
+	u := &account.User{
+		Id:                uuid.Must(uuid.NewV4()),
+		PlatformUsernames: map[account.PlatformName]string{account.PlatformTelegram: name},
+	}
+	s.users[u.Id] = u
+	return u
+}
+
+// add adds a transaction created by from, with a leg for each user in states
+func (s *store) add(from *account.User, kind account.TransactionKind, amount float64, at time.Time, states map[*account.User]account.TransactionState) {
+	t := &account.Transaction{
+		Id:          uuid.Must(uuid.NewV4()),
+		CreatedBy:   from.Id,
+		Accounts:    make(map[uuid.UUID]uuid.UUID),
+		States:      make(map[uuid.UUID]account.TransactionState),
+		Amount:      amount,
+		Kind:        kind,
+		Description: fmt.Sprintf("%s of %v", kind, amount),
+		CreatedAt:   at,
+	}
+	for u, state := range states {
+		t.Accounts[u.Id] = uuid.Must(uuid.NewV4())
+		t.States[u.Id] = state
+	}
+	s.trans = append(s.trans, t)
+}
+
+// journalEntry is an entry parsed back out of a journal
+type journalEntry struct {
+	date     string
+	header   string
+	id       string
+	postings map[string]float64
+
+	// cents is the sum of the postings, which have to balance
+	cents int64
+}
+
+var (
+	ledgerHeader    = regexp.MustCompile(`^(\d{4}[/-]\d{2}[/-]\d{2}) \* (.*)$`)
+	ledgerPosting   = regexp.MustCompile(`^ {4}(\S(?:.*\S)?) {2,}(-?)\$(\d+\.\d{2})$`)
+	beancountOpen   = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}) open (\S+) USD$`)
+	beancountHeader = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}) \* (".*")$`)
+	beancountMeta   = regexp.MustCompile(`^ {2}id: "([0-9a-f-]{36})"$`)
+	beancountPost   = regexp.MustCompile(`^ {2}(\S+) {2,}(-?\d+\.\d{2}) USD$`)
+
+	// beancountAccount is what beancount accepts as an account name
+	beancountAccount = regexp.MustCompile(`^(Assets|Liabilities|Equity|Income|Expenses)(:[A-Z0-9][A-Za-z0-9-]*)+$`)
+)
+
+// cents parses an amount into cents
+func cents(t *testing.T, sign, v string) int64 {
+	t.Helper()
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		t.Fatalf("invalid amount '%s': %v", v, err)
+	}
+	c := int64(math.Round(f * 100))
+	if sign == "-" {
+		c = -c
+	}
+	return c
+}
+
+// parseLedger parses a ledger or hledger journal
+func parseLedger(t *testing.T, journal string) []*journalEntry {
+	t.Helper()
+
+	var entries []*journalEntry
+	var en *journalEntry
+	s := bufio.NewScanner(strings.NewReader(journal))
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case line == "":
+			en = nil
+		case ledgerHeader.MatchString(line):
+			m := ledgerHeader.FindStringSubmatch(line)
+			en = &journalEntry{date: m[1], header: m[2], postings: make(map[string]float64)}
+			entries = append(entries, en)
+		case en != nil && strings.HasPrefix(line, "    ; id: "):
+			en.id = strings.TrimPrefix(line, "    ; id: ")
+		case en != nil && ledgerPosting.MatchString(line):
+			m := ledgerPosting.FindStringSubmatch(line)
+			c := cents(t, m[2], m[3])
+			en.postings[m[1]] += float64(c) / 100
+			en.cents += c
+		default:
+			t.Fatalf("unexpected journal line: %q", line)
+		}
+	}
+
+	return entries
+}
+
+// parseBeancount parses a beancount file, checking every account is opened
+// before it's used
+func parseBeancount(t *testing.T, journal string) []*journalEntry {
+	t.Helper()
+
+	opened := make(map[string]string)
+	var entries []*journalEntry
+	var en *journalEntry
+	s := bufio.NewScanner(strings.NewReader(journal))
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case line == "":
+			en = nil
+		case line == `option "operating_currency" "USD"`:
+		case beancountOpen.MatchString(line):
+			m := beancountOpen.FindStringSubmatch(line)
+			if _, ok := opened[m[2]]; ok {
+				t.Errorf("account %s is opened twice", m[2])
+			}
+			opened[m[2]] = m[1]
+		case beancountHeader.MatchString(line):
+			m := beancountHeader.FindStringSubmatch(line)
+			en = &journalEntry{date: m[1], header: m[2], postings: make(map[string]float64)}
+			entries = append(entries, en)
+		case en != nil && beancountMeta.MatchString(line):
+			en.id = beancountMeta.FindStringSubmatch(line)[1]
+		case en != nil && beancountPost.MatchString(line):
+			m := beancountPost.FindStringSubmatch(line)
+			if !beancountAccount.MatchString(m[1]) {
+				t.Errorf("invalid beancount account name '%s'", m[1])
+			}
+			if date, ok := opened[m[1]]; !ok || date > en.date {
+				t.Errorf("account %s is used on %s before it's opened", m[1], en.date)
+			}
+
+			c := cents(t, "", m[2])
+			en.postings[m[1]] += float64(c) / 100
+			en.cents += c
+		default:
+			t.Fatalf("unexpected beancount line: %q", line)
+		}
+	}
+
+	return entries
+}
+
+func TestJournalRoundTrip(t *testing.T) {
+	s := &store{users: make(map[uuid.UUID]*account.User)}
+	me := s.addUser("me")
+	bob := s.addUser("Bob Smith")
+	carol := s.addUser("josé:carol;")
+	dave := s.addUser("42")
+
+	since := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	before := since.AddDate(0, 0, -10)
+	after := since.AddDate(0, 0, 5)
+
+	// before since, bob owes 20 and dave is owed 5
+	s.add(me, account.TransactionCharge, 40, before, map[*account.User]account.TransactionState{bob: account.TransactionAccepted, carol: account.TransactionPending})
+	s.add(dave, account.TransactionCharge, 5, before.Add(time.Hour), map[*account.User]account.TransactionState{me: account.TransactionAccepted})
+	s.add(me, account.TransactionCharge, 100, before.Add(2*time.Hour), map[*account.User]account.TransactionState{bob: account.TransactionVoided})
+
+	// after since, thirds don't round to cents
+	s.add(me, account.TransactionCharge, 10, after, map[*account.User]account.TransactionState{
+		bob: account.TransactionAccepted, carol: account.TransactionAccepted, dave: account.TransactionAccepted,
+	})
+	s.add(bob, account.TransactionPayment, 20, after.Add(time.Hour), map[*account.User]account.TransactionState{me: account.TransactionAccepted})
+	s.add(me, account.TransactionPayment, 5, after.Add(2*time.Hour), map[*account.User]account.TransactionState{dave: account.TransactionAccepted})
+	s.add(carol, account.TransactionCharge, 7, after.Add(3*time.Hour), map[*account.User]account.TransactionState{me: account.TransactionDisputed})
+	s.add(me, account.TransactionCharge, -3, after.Add(4*time.Hour), map[*account.User]account.TransactionState{carol: account.TransactionAccepted})
+
+	for _, f := range []Format{FormatLedger, FormatHLedger, FormatBeancount} {
+		t.Run(string(f), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := NewExporter(s, account.PlatformTelegram).Export(context.Background(), &buf, f, me, nil, since); err != nil {
+				t.Fatalf("failed to export: %v", err)
+			}
+
+			var entries []*journalEntry
+			if f == FormatBeancount {
+				entries = parseBeancount(t, buf.String())
+			} else {
+				entries = parseLedger(t, buf.String())
+			}
+
+			// opening balances, the 10 split three ways, bob's payment, the
+			// payment to dave, and the credit to carol
+			if len(entries) != 5 {
+				t.Fatalf("expected 5 entries, got %d:\n%s", len(entries), buf.String())
+			}
+
+			for _, en := range entries {
+				if en.cents != 0 {
+					t.Errorf("entry %q doesn't balance, off by %d cents", en.header, en.cents)
+				}
+				if date := strings.Replace(en.date, "/", "-", -1); date < since.Format("2006-01-02") {
+					t.Errorf("entry %q is dated %s, before the export", en.header, en.date)
+				}
+			}
+
+			opening := entries[0]
+			if !strings.Contains(opening.header, "Opening balances") || opening.id != "" {
+				t.Errorf("expected the first entry to be the opening balances, got %q (id %q)", opening.header, opening.id)
+			}
+			wantDate := since.Format("2006-01-02")
+			if f == FormatLedger {
+				wantDate = since.Format("2006/01/02")
+			}
+			if opening.date != wantDate {
+				t.Errorf("expected opening balances on %s, got %s", wantDate, opening.date)
+			}
+			wantOpening := map[string]float64{
+				accountName(f, receivableAccount, "Bob Smith"): 20,
+				accountName(f, payableAccount, "42"):           -5,
+				openingAccount:                                 -15,
+			}
+			for name, want := range wantOpening {
+				if got := opening.postings[name]; got != want {
+					t.Errorf("expected opening balance of %v for %s, got %v (%v)", want, name, got, opening.postings)
+				}
+			}
+			if len(opening.postings) != len(wantOpening) {
+				t.Errorf("expected opening postings %v, got %v", wantOpening, opening.postings)
+			}
+
+			for _, en := range entries[1:] {
+				if _, err := uuid.FromString(en.id); err != nil {
+					t.Errorf("entry %q has an invalid id %q", en.header, en.id)
+				}
+			}
+
+			// every receivable and payable, added up, is what each user owes
+			owed := make(map[string]int64)
+			for _, en := range entries {
+				for name, v := range en.postings {
+					if strings.HasPrefix(name, receivableAccount+":") || strings.HasPrefix(name, payableAccount+":") {
+						owed[name[strings.Index(name, ":")+1:]] += int64(math.Round(v * 100))
+					}
+				}
+			}
+			net := func(name string) int64 {
+				recv := strings.TrimPrefix(accountName(f, receivableAccount, name), receivableAccount+":")
+				return owed["Receivable:"+recv] + owed["Payable:"+recv]
+			}
+			for name, want := range map[string]int64{"Bob Smith": 333, "josé:carol;": 33, "42": 333} {
+				if got := net(name); got != want {
+					t.Errorf("expected %s to owe %d cents, got %d", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestBeancountAccountNames(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "bob", want: "Assets:Receivable:Bob"},
+		{name: "Bob Smith", want: "Assets:Receivable:Bob-Smith"},
+		{name: "josé", want: "Assets:Receivable:Jos-"},
+		{name: "42", want: "Assets:Receivable:42"},
+		{name: "_bob", want: "Assets:Receivable:U-bob"},
+		{name: "", want: "Assets:Receivable:U"},
+		{name: "a:b;c", want: "Assets:Receivable:A-b-c"},
+		{name: "Ünïcode", want: "Assets:Receivable:U-n-code"},
+	}
+
+	for _, tt := range tests {
+		got := accountName(FormatBeancount, receivableAccount, tt.name)
+		if got != tt.want {
+			t.Errorf("%q: expected '%s', got '%s'", tt.name, tt.want, got)
+		}
+		if !beancountAccount.MatchString(got) {
+			t.Errorf("%q: '%s' isn't a valid beancount account", tt.name, got)
+		}
+	}
+
+	if got := accountName(FormatLedger, payableAccount, "Bob Smith:x;y"); got != "Liabilities:Payable:Bob_Smith_x_y" {
+		t.Errorf("expected ledger account names to replace separators, got '%s'", got)
+	}
+}
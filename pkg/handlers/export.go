@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/export"
+	"github.com/jaredallard/balance/pkg/social"
+	"github.com/pkg/errors"
+)
+
+// HandleExport handles /export [FORMAT] [USERNAME] [SINCE], which sends the
+// user their history as a csv, json, ledger, hledger or beancount file
+func (h *Handlers) HandleExport(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error) {
+	if h.s == nil {
+		return social.TextReply("Exports aren't supported here"), nil
+	}
+
+	format := export.FormatCSV
+	var with *account.User
+	var since time.Time
+	var warning social.Paragraph
+
+	for _, token := range tokens[1:] {
+		if f, err := export.ParseFormat(token); err == nil {
+			format = f
+			continue
+		}
+
+		if t, err := time.Parse("2006-01-02", token); err == nil {
+			since = t
+			continue
+		}
+
+		if with != nil {
+			return social.TextReply("Usage: /export [csv|json|ledger|hledger|beancount] [USERNAME] [SINCE], e.g. /export csv USERNAME 2020-01-31"), nil
+		}
+
+		var err error
+		with, warning, err = h.lookupUser(ctx, msg, token)
+		if err != nil {
+			return social.TextReply(fmt.Sprintf("Failed to find user %s", token)), nil
+		}
+	}
+
+	var buf bytes.Buffer
+	e := export.NewExporter(h.a, msg.PlatformName)
+	if err := e.Export(ctx, &buf, format, msg.From, with, since); err != nil {
+		return social.TextReply("Failed to export your history, please try again later"), errors.Wrap(err, "failed to export history")
+	}
+
+	name := "balance-" + time.Now().UTC().Format("2006-01-02")
+	if with != nil {
+		name += "-" + with.PlatformUsernames[msg.PlatformName]
+	}
+
+	chatID := msg.ChatID
+	if !msg.Private {
+		chatID = msg.From.PlatformIds[msg.PlatformName]
+	}
+
+	err := h.s.Send(&social.Message{
+		ChatID:       chatID,
+		PlatformName: msg.PlatformName,
+	}, social.TextReply("Here's your history").Attach(&social.Document{
+		Name: name + format.Extension(),
+		Data: buf.Bytes(),
+	}))
+	if err == social.ErrUnsupported {
+		return social.TextReply("Exports aren't supported here"), nil
+	} else if err != nil {
+		return social.TextReply("Failed to send your export, please try again later"), errors.Wrap(err, "failed to send export")
+	}
+
+	if msg.Private {
+		return social.NewReply(warning...), nil
+	}
+	return withWarnings(social.TextReply("I've sent you your history privately"), []social.Paragraph{warning}), nil
+}
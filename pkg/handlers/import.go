@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jaredallard/balance/pkg/importer"
+	"github.com/jaredallard/balance/pkg/social"
+	"github.com/pkg/errors"
+)
+
+// maxImportSize is the largest file that can be imported
+const maxImportSize = 5 * 1024 * 1024
+
+// HandleImport handles /import [dry-run] "NAME=USERNAME"..., sent as the caption
+// of a Splitwise CSV export. Each NAME in the export has to be mapped to a user.
+func (h *Handlers) HandleImport(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error) {
+	usage := social.TextReply("Send a Splitwise export with the caption /import [dry-run] \"NAME=USERNAME\"..., mapping each person in the export to a user")
+	if msg.Attachment == nil {
+		return usage, nil
+	}
+
+	if msg.Attachment.Size > maxImportSize {
+		return social.TextReply(fmt.Sprintf("That file is too big, the limit is %dMB", maxImportSize/1024/1024)), nil
+	}
+
+	dryRun := false
+	m := make(importer.Mapping)
+	for _, token := range tokens[1:] {
+		if strings.EqualFold(token, "dry-run") {
+			dryRun = true
+			continue
+		}
+
+		if err := m.Add(unquote(token)); err != nil {
+			return usage, nil
+		}
+	}
+
+	data, err := msg.Attachment.Download()
+	if err != nil {
+		return social.TextReply("Failed to download the export, please try again later"), errors.Wrap(err, "failed to download import")
+	}
+
+	report, err := importer.NewImporter(h.a, msg.PlatformName).ImportSplitwise(ctx, bytes.NewReader(data), m, dryRun)
+	if err == importer.ErrUnmapped {
+		return social.TextReply("Some people in the export aren't mapped to a user, nothing was imported").Add(social.Text(report.String())), nil
+	} else if err != nil {
+		return social.TextReply(fmt.Sprintf("Failed to import: %v", err)), errors.Wrap(err, "failed to import splitwise export")
+	}
+
+	return social.TextReply(report.String()), nil
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"strconv"
@@ -14,229 +15,475 @@ import (
 
 type Handlers struct {
 	a *account.Client
+	s social.Sender
+
+	// Reminders configures when users are reminded about what they owe
+	Reminders ReminderConfig
 }
 
 // NewHandlers creates a new message handler
-func NewHandlers(a *account.Client) *Handlers {
+func NewHandlers(a *account.Client, s social.Sender) *Handlers {
 	return &Handlers{
-		a: a,
+		a:         a,
+		s:         s,
+		Reminders: DefaultReminderConfig,
 	}
 }
 
-// HandleNewUser handles an new user event
-func (h *Handlers) HandleNewUser(msg *social.Message) (string, error) {
-	err := h.a.CreateUser(&account.User{
+// HandleRegister handles /register, creating an account for the sender
+func (h *Handlers) HandleRegister(ctx context.Context, msg *social.Message) (*social.Reply, error) {
+	if msg.From != nil {
+		return social.TextReply("You already have an account! If you need help, run /help"), nil
+	}
+
+	u := &account.User{
 		PlatformIds: map[account.PlatformName]string{
 			msg.PlatformName: msg.UserID,
 		},
 		PlatformUsernames: map[account.PlatformName]string{
 			msg.PlatformName: msg.Username,
 		},
-	})
-	if err != nil {
-		return "", errors.Wrap(err, "failed to ensure msg.From")
 	}
+	err := h.a.CreateUser(ctx, u)
+	if err == account.ErrUserExists {
+		return social.TextReply("You already have an account! If you need help, run /help"), nil
+	} else if err != nil {
+		return social.TextReply("Failed to create your account, please try again later"), errors.Wrap(err, "failed to create user")
+	}
+	msg.From = u
 
-	return "Hello! I've created you an account. If you need help, or want to know how to use this bot, run /help!", nil
+	return social.TextReply("Hello! I've created you an account. If you need help, or want to know how to use this bot, run /help!"), nil
 }
 
-func (h *Handlers) HandleAdd(msg *social.Message, tokens []string) (string, error) {
-	tokens = tokens[1:]
+// HandleUnregister handles /unregister, deleting the sender's account
+func (h *Handlers) HandleUnregister(ctx context.Context, msg *social.Message) (*social.Reply, error) {
+	err := h.a.DeleteUser(ctx, msg.From)
+	if err == account.ErrOutstandingBalance {
+		return social.TextReply("You can't delete your account while you have outstanding balances or pending transactions, run /status and /pending to see them"), nil
+	} else if err != nil {
+		return social.TextReply("Failed to delete your account, please try again later"), errors.Wrap(err, "failed to delete user")
+	}
+
+	return social.TextReply("Your account has been deleted. If you want to use this bot again, run /register"), nil
+}
+
+// HandleAdd handles /add USERNAME... BALANCE ["DESCRIPTION"]
+func (h *Handlers) HandleAdd(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error) {
+	c, reply := h.parseCharge(ctx, msg, tokens[1:])
+	if reply != nil {
+		return reply, nil
+	}
+
+	reply, err := h.createBalance(ctx, msg.From, c.users, &account.Transaction{
+		Amount:       float64(c.balance),
+		Description:  c.description,
+		PlatformName: msg.PlatformName,
+		ChatID:       msg.ChatID,
+	}, msg.PlatformName)
+
+	return withWarnings(reply, c.warnings), err
+}
 
-	balance := 0
-	users := make([]account.User, 0)
+// charge is a parsed request to charge users
+type charge struct {
+	users       []account.User
+	balance     int
+	description string
+
+	// warnings should be shown to the user alongside the result of the charge
+	warnings []social.Paragraph
+}
+
+// parseCharge parses the users, balance, and optional description out of a
+// charge, if reply is set it should be returned to the user
+func (h *Handlers) parseCharge(ctx context.Context, msg *social.Message, tokens []string) (*charge, *social.Reply) {
+	c := &charge{
+		users: make([]account.User, 0),
+	}
 	for _, user := range tokens {
-		var err error
-		balance, err = strconv.Atoi(user)
+		if isQuoted(user) {
+			c.description = unquote(user)
+			continue
+		}
+
+		balance, err := strconv.Atoi(user)
 		if err == nil {
+			c.balance = balance
 			continue
 		}
 
-		u, err := h.a.FindUserByUsernam(msg.PlatformName, strings.ToLower(user))
+		u, warning, err := h.lookupUser(ctx, msg, user)
+		if err == account.ErrUserNotFound {
+			u, warning, err = h.invite(ctx, msg, user)
+		}
 		if err != nil {
-			return fmt.Sprintf("Failed to find user %s", user), nil
+			return nil, social.TextReply(fmt.Sprintf("Failed to find user %s", user))
+		}
+		if warning != nil {
+			c.warnings = append(c.warnings, warning)
 		}
 
-		users = append(users, *u)
+		c.users = append(c.users, *u)
 	}
 
-	if balance == 0 {
-		return "Balance cannot be 0", nil
-	}
+	return c, nil
+}
 
-	// if we're the only user, don't allow adding ourself
-	if len(users) == 1 && users[0].Id == msg.From.Id {
-		return "Cannot create a balance with yourself", nil
+// lookupUser finds a user on the platform a message came from. Users can be
+// referenced by their username, with or without an @, by a mention that the
+// platform resolved, or by their platform ID as id:ID. If the username is one
+// the user used to have, a warning is returned that should be shown alongside
+// the reply.
+func (h *Handlers) lookupUser(ctx context.Context, msg *social.Message, username string) (*account.User, social.Paragraph, error) {
+	username = strings.TrimPrefix(strings.ToLower(username), "@")
+	if strings.HasPrefix(username, "id:") {
+		u, err := h.a.FindUser(ctx, msg.PlatformName, strings.TrimPrefix(username, "id:"))
+		return u, nil, err
 	}
 
-	log.Infof("creating a balance of '%d' across '%d' users: %v", balance, len(users), users)
-	due := float64(balance / len(users))
-	for _, user := range users {
-		err := h.a.NewTransaction(msg.From, &user, due)
-		if err == account.ErrAccountNotFound {
-			log.Infof("creating account between user %s and %s", msg.From.Id, user.Id)
-			err := h.a.CreateAccount(&account.Account{
-				Creator: msg.From,
-				Subject: &user,
-				Balance: due,
-			})
-			if err != nil {
-				return "Failed to create transaction, please try again later", fmt.Errorf("failed to create account for transaction: %v", err)
+	// prefer the ID of a mentioned user, since usernames can go stale
+	for _, m := range msg.Mentions {
+		if m.UserID != "" && m.Username == username {
+			if u, err := h.a.FindUser(ctx, msg.PlatformName, m.UserID); err == nil {
+				return u, nil, nil
 			}
-		} else if err != nil {
-			return "Failed to create transaction, please try again later", fmt.Errorf("failed to create transaction: %v", err)
 		}
 	}
 
-	if err := h.a.CreateTransaction(msg.From, users, due); err != nil {
-		log.Errorf("failed to create transaction log: %v", err)
+	u, previous, err := h.a.LookupUsername(ctx, msg.PlatformName, username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var warning social.Paragraph
+	if previous {
+		log.Warnf("resolved previous username %s to user %s", username, u.Id)
+		warning = social.Paragraph{
+			social.Text("Note: "), social.Bold(username),
+			social.Text(" is now known as "), social.Bold(u.PlatformUsernames[msg.PlatformName]),
+		}
 	}
 
-	return "Balance Created", nil
+	return u, warning, nil
 }
 
-func (h *Handlers) HandleBalance(msg *social.Message) (string, error) {
-	accts, err := h.a.FindAccounts(msg.From)
+// invite creates a placeholder for a user that was mentioned, but hasn't talked
+// to us yet, so that they can be charged before they register. Only mentions
+// are invited, so that typos don't create users.
+func (h *Handlers) invite(ctx context.Context, msg *social.Message, token string) (*account.User, social.Paragraph, error) {
+	name := strings.TrimPrefix(strings.ToLower(token), "@")
+	if name == strings.ToLower(token) {
+		return nil, nil, account.ErrUserNotFound
+	}
+
+	var mention *social.Mention
+	for i := range msg.Mentions {
+		m := &msg.Mentions[i]
+		if m.Username == name || (m.UserID != "" && "id:"+m.UserID == name) {
+			mention = m
+			break
+		}
+	}
+	if mention == nil {
+		return nil, nil, account.ErrUserNotFound
+	}
+
+	u := &account.User{
+		PlatformIds:       map[account.PlatformName]string{},
+		PlatformUsernames: map[account.PlatformName]string{msg.PlatformName: mention.Username},
+		Placeholder:       true,
+	}
+	if mention.UserID != "" {
+		u.PlatformIds[msg.PlatformName] = mention.UserID
+	}
+
+	log.Infof("creating placeholder for mentioned user %s (%s)", mention.Username, mention.UserID)
+	if err := h.a.CreateUser(ctx, u); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create placeholder")
+	}
+
+	return u, social.Paragraph{
+		social.Text("Note: "), social.Bold(mention.Username),
+		social.Text(" hasn't talked to me yet, I'll keep track of this until they do"),
+	}, nil
+}
+
+// displayName returns how a user should be shown on a platform, labeling
+// placeholders for users that haven't talked to us yet
+func displayName(u *account.User, p account.PlatformName) string {
+	if u.Placeholder {
+		return u.PlatformUsernames[p] + " (invited)"
+	}
+
+	return u.PlatformUsernames[p]
+}
+
+// withWarnings appends warnings to a reply
+func withWarnings(reply *social.Reply, warnings []social.Paragraph) *social.Reply {
+	if reply.Empty() {
+		return reply
+	}
+
+	for _, w := range warnings {
+		if len(w) != 0 {
+			reply.Add(w...)
+		}
+	}
+	return reply
+}
+
+// onlyYourself returns if from is the only one of users, balances can't be
+// created with yourself
+func onlyYourself(from *account.User, users []account.User) bool {
+	return len(users) == 1 && users[0].Id == from.Id
+}
+
+// createBalance creates a transaction between from and users, and asks each user
+// to approve it. Used by /add, and anything else that needs to create a balance.
+func (h *Handlers) createBalance(ctx context.Context, from *account.User, users []account.User, t *account.Transaction, p account.PlatformName) (*social.Reply, error) {
+	if t.Amount == 0 {
+		return social.TextReply("Balance cannot be 0"), nil
+	}
+
+	if len(users) == 0 {
+		return social.TextReply("Please provide at least one user"), nil
+	}
+
+	if onlyYourself(from, users) {
+		return social.TextReply("Cannot create a balance with yourself"), nil
+	}
+
+	log.Infof("creating a balance of '%v' across '%d' users: %v", t.Amount, len(users), users)
+	err := h.a.CreateTransaction(ctx, t, from, users)
+	if err == account.ErrTransactionExists {
+		return nil, err
+	} else if err != nil {
+		return social.TextReply("Failed to create transaction, please try again later"), fmt.Errorf("failed to create transaction: %v", err)
+	}
+
+	h.NotifyTransaction(ctx, from, users, t, p)
+	return social.TextReply("Balance Created, waiting for approval"), nil
+}
+
+// NotifyTransaction asks each user involved in a new transaction to approve it
+func (h *Handlers) NotifyTransaction(ctx context.Context, from *account.User, users []account.User, t *account.Transaction, p account.PlatformName) {
+	forText := ""
+	if t.Description != "" {
+		forText = fmt.Sprintf(" for \"%s\"", t.Description)
+	}
+
+	op := fmt.Sprintf("requested $%v from you", t.Share())
+	if t.Kind == account.TransactionPayment {
+		op = fmt.Sprintf("says they paid you $%v", t.Share())
+	}
+
+	for i := range users {
+		err := h.notify(&users[i], p, social.NewReply(
+			social.Bold(from.PlatformUsernames[p]), social.Textf(" %s%s.", op, forText),
+		).Add(
+			social.Textf("Run /accept %s to accept it, or /dispute %s to dispute it.", t.ShortID(), t.ShortID()),
+		).AddButton("Accept", "/accept "+t.ShortID()).AddButton("Dispute", "/dispute "+t.ShortID()))
+		if err != nil {
+			log.Warnf("failed to notify subject of transaction %s: %v", t.Id, err)
+		}
+	}
+}
+
+func (h *Handlers) HandleBalance(ctx context.Context, msg *social.Message) (*social.Reply, error) {
+	accts, err := h.a.FindAccounts(ctx, msg.From)
 	if err != nil {
-		return "Failed to retrieve your balances", err
+		return social.TextReply("Failed to retrieve your balances"), err
 	}
 
-	m := fmt.Sprintf("Your Accounts (%d Accounts):\n\n", len(accts))
+	r, err := h.formatBalances(ctx, msg.From, accts, msg.PlatformName)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Add(social.Text("To get my details behind a balance, run /history USERNAME")), nil
+}
+
+// formatBalances formats a list of accounts from the perspective of u
+func (h *Handlers) formatBalances(ctx context.Context, u *account.User, accts []*account.Account, p account.PlatformName) (*social.Reply, error) {
+	r := social.NewReply(social.Textf("Your Accounts (%d Accounts):", len(accts)))
+	l := r.List()
 	for _, a := range accts {
-		isNeg := a.Balance < 0
+		balance := a.Balance
+		isNeg := balance < 0
 		if isNeg {
-			a.Balance = math.Abs(a.Balance)
+			balance = math.Abs(balance)
 		}
 
-		creator, err := h.a.GetUser(a.CreatorId)
+		creator, err := h.a.GetUser(ctx, a.CreatorId)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		subject, err := h.a.GetUser(a.SubjectId)
+		subject, err := h.a.GetUser(ctx, a.SubjectId)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		owe := false
 
 		var otherUser *account.User
-		if a.CreatorId == msg.From.Id && isNeg {
+		if a.CreatorId == u.Id && isNeg {
 			owe = true
 			otherUser = subject
-		} else if a.CreatorId == msg.From.Id && !isNeg {
+		} else if a.CreatorId == u.Id && !isNeg {
 			otherUser = subject
 		}
 
-		if a.SubjectId == msg.From.Id && !isNeg {
+		if a.SubjectId == u.Id && !isNeg {
 			owe = true
 			otherUser = creator
-		} else if a.SubjectId == msg.From.Id && isNeg {
+		} else if a.SubjectId == u.Id && isNeg {
 			owe = false
 			otherUser = creator
 		}
 
 		// TODO(jaredallard): hard dep on USD
-		m += " •"
 		if owe {
-			m = m + fmt.Sprintf("	You owe *%s* $%v", otherUser.PlatformUsernames[msg.PlatformName], a.Balance)
+			l.Add(social.Text("You owe "), social.Bold(displayName(otherUser, p)), social.Textf(" $%v", balance))
 		} else {
-			m = m + fmt.Sprintf("	*%s* owes you $%v", otherUser.PlatformUsernames[msg.PlatformName], a.Balance)
+			l.Add(social.Bold(displayName(otherUser, p)), social.Textf(" owes you $%v", balance))
 		}
-		m += "\n"
 	}
-	m = m + "\nTo get my details behind a balance, run /history USERNAME"
 
-	return m, nil
+	return r, nil
 }
 
-func (h *Handlers) HandleListUsers(msg *social.Message) (string, error) {
-	users, err := h.a.ListUsers()
+func (h *Handlers) HandleListUsers(ctx context.Context, msg *social.Message) (*social.Reply, error) {
+	users, err := h.a.ListUsers(ctx)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to list users")
+		return nil, errors.Wrap(err, "failed to list users")
 	}
 
-	resp := "Available Users:\n"
+	r := social.TextReply("Available Users:")
+	l := r.List()
 	for _, u := range users {
-		resp += fmt.Sprintf("• %s\n", u.PlatformUsernames[msg.PlatformName])
+		l.Add(social.Text(displayName(u, msg.PlatformName)))
 	}
 
-	return resp, nil
+	return r, nil
 }
 
-func (h *Handlers) HandleHistory(msg *social.Message, tokens []string) (string, error) {
+func (h *Handlers) HandleHistory(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error) {
 	userName := ""
 	if len(tokens) > 1 {
 		userName = tokens[1]
 	}
-	u, err := h.a.FindUserByUsernam(msg.PlatformName, userName)
-	if err != nil && userName != "" { // we ignore empty input since it'll nil the filter
-		return "", err
+	var u *account.User
+	var warning social.Paragraph
+	if userName != "" { // we ignore empty input since it'll nil the filter
+		var err error
+		u, warning, err = h.lookupUser(ctx, msg, userName)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	trans, err := h.a.GetAllTransactionsByUser(msg.From, u)
+	trans, err := h.a.GetAllTransactionsByUser(ctx, msg.From, u)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to list transactions")
+		return nil, errors.Wrap(err, "failed to list transactions")
 	}
 
-	ctx := ""
+	title := "Account History"
 	if u != nil {
-		ctx = "(" + u.PlatformUsernames[msg.PlatformName] + ")"
+		title += " (" + u.PlatformUsernames[msg.PlatformName] + ")"
 	}
 
-	resp := fmt.Sprintf("*Account History %s*\n\n", ctx)
-	for _, t := range trans {
-		amount := t.Amount / float64(len(t.Accounts))
+	r := social.NewReply(social.Bold(title))
+	r.Append(h.formatHistory(ctx, msg.From, u, trans, msg.PlatformName))
+
+	return withWarnings(r, []social.Paragraph{warning}), nil
+}
 
+// formatHistory formats a list of transactions from the perspective of viewer,
+// only showing filter as the subject if it's set. If viewer is nil, transactions
+// are formatted from the perspective of a third party.
+func (h *Handlers) formatHistory(ctx context.Context, viewer *account.User, filter *account.User, trans []*account.Transaction, p account.PlatformName) *social.Reply {
+	r := &social.Reply{}
+	l := r.List()
+	for _, t := range trans {
 		// TODO(jaredallard): don't depend on USD
-		op := fmt.Sprintf("requested $%v from", amount)
+		op := fmt.Sprintf("requested $%v from", t.Share())
+		if t.Kind == account.TransactionPayment {
+			op = fmt.Sprintf("paid $%v to", t.Share())
+		}
 
-		createdByUser, err := h.a.GetUser(t.CreatedBy)
+		createdByUser, err := h.a.GetUser(ctx, t.CreatedBy)
 		if err != nil {
 			log.Warnf("failed to show invalid transaction, createdByUser not found: %v", err)
 			continue
 		}
 
-		if createdByUser.Id == msg.From.Id {
-			if u != nil {
-				op += " " + u.PlatformUsernames[msg.PlatformName]
+		if viewer == nil || createdByUser.Id == viewer.Id {
+			if filter != nil {
+				op += " " + filter.PlatformUsernames[p]
 			} else {
 				for uid := range t.Accounts {
-					user, err := h.a.GetUser(uid)
+					user, err := h.a.GetUser(ctx, uid)
 					if err != nil {
 						log.Warnf("failed to show invalid transaction, invalid user %s: %v", uid, err)
+						continue
 					}
-					op += " " + user.PlatformUsernames[msg.PlatformName]
+					op += " " + user.PlatformUsernames[p]
 				}
 			}
 		} else {
 			op += " you"
 		}
 
-		str := fmt.Sprintf("_%s_: %s %s", t.CreatedAt.UTC().Format("01-02 15:04"), createdByUser.PlatformUsernames[msg.PlatformName], op)
-		resp += str
+		if t.Description != "" {
+			op += fmt.Sprintf(" for \"%s\"", t.Description)
+		}
+
+		str := fmt.Sprintf(": %s %s", createdByUser.PlatformUsernames[p], op)
+		if viewer != nil && createdByUser.Id != viewer.Id {
+			if state := t.State(viewer.Id); state != account.TransactionAccepted {
+				str += fmt.Sprintf(" (%s)", state)
+			}
+		} else if t.Voided() {
+			str += fmt.Sprintf(" (%s)", account.TransactionVoided)
+		}
+		l.Add(social.Italic(t.CreatedAt.UTC().Format("01-02 15:04")), social.Text(str))
 	}
 
-	return resp, nil
+	return r
 }
 
-// HandleHelp handles /help
-func (h *Handlers) HandleHelp(msg *social.Message) (string, error) {
-	return fmt.Sprintf(`
-Hi! I'm a Bot that will help you track balances between people!
-
-If you want to create a transaction between you and another user, just run /add USERNAME BALANCE
-
-If you want to create a transaction between you and multiple people, run /add USERNAME USERNAME... BALANCE
-
-To view all transactions relating to you, run /history
-
-To view transactions between you and a user, run /history USERNAME
-
-To list all registered users, run /list
+// helpText is sent by /help, each entry is a paragraph
+var helpText = []string{
+	"Hi! I'm a Bot that will help you track balances between people!",
+	"To get started, run /register to create an account. To delete it, run /unregister",
+	"If you want to create a transaction between you and another user, just run /add USERNAME BALANCE",
+	"If you want to create a transaction between you and multiple people, run /add USERNAME USERNAME... BALANCE",
+	"Users can be referenced by their username, by mentioning them, or by their ID with id:ID",
+	`To describe a transaction, add a description in quotes, e.g. /add USERNAME BALANCE "pizza"`,
+	`To create a transaction that repeats, run /recurring add USERNAME... BALANCE "DESCRIPTION" daily|weekly|monthly [on DAY]`,
+	"To manage your recurring transactions, run /recurring list|pause|resume|delete",
+	"To view all transactions relating to you, run /history",
+	"To view transactions between you and a user, run /history USERNAME",
+	"To list all registered users, run /list",
+	"To list all account balances, run /status",
+	"To list transactions waiting on your approval, run /pending",
+	"To remind someone that they owe you, run /remind USERNAME",
+	"To turn reminders on or off, or set quiet hours, run /reminders",
+	"To use the same account on another platform, run /link, then /link CODE from the other platform",
+	"To get a weekly or monthly statement, run /statement weekly|monthly, or /statement off to stop them. Run it in a group to get a statement for the group.",
+	"To accept or dispute a transaction, run /accept ID or /dispute ID",
+	"To export your history as a file, run /export [csv|json|ledger|hledger|beancount] [USERNAME] [SINCE]",
+	"To get a token for the API, run /token, or /token revoke to revoke your tokens",
+	"If you need any help, message @jaredallard!",
+}
 
-To list all account balances, run /status
+// HandleHelp handles /help
+func (h *Handlers) HandleHelp(ctx context.Context, msg *social.Message) (*social.Reply, error) {
+	r := &social.Reply{}
+	for _, p := range helpText {
+		r.Add(social.Text(p))
+	}
 
-If you need any help, message @jaredallard!
-	`), nil
+	return r, nil
 }
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/social"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// linkCodeTTL is how long a link code can be redeemed for
+const linkCodeTTL = 10 * time.Minute
+
+// HandleLink handles /link, which creates a link code, and /link CODE, which
+// merges the current user into the user that created the code
+func (h *Handlers) HandleLink(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error) {
+	if len(tokens) < 2 {
+		return h.handleLinkCreate(ctx, msg)
+	}
+
+	owner, err := h.a.RedeemLinkCode(ctx, tokens[1])
+	if err == account.ErrLinkCodeNotFound {
+		return social.TextReply("That link code is invalid or has expired, run /link on your other account to get a new one"), nil
+	} else if err != nil {
+		return social.TextReply("Failed to link your accounts, please try again later"), errors.Wrap(err, "failed to redeem link code")
+	}
+
+	if owner.Id == msg.From.Id {
+		return social.TextReply("That link code is for this account, run /link CODE from your other account"), nil
+	}
+
+	err = h.a.MergeUsers(ctx, owner, msg.From)
+	if err == account.ErrPlatformConflict {
+		return social.TextReply(fmt.Sprintf("That account is already linked to a %s account", msg.PlatformName)), nil
+	} else if err == account.ErrMergeBalance {
+		return social.TextReply("Your accounts still owe each other money, settle up between them before linking them"), nil
+	} else if err == account.ErrSharedTransaction {
+		return social.TextReply("Both of your accounts are part of the same transaction, so they can't be linked until it's voided"), nil
+	} else if err != nil {
+		return social.TextReply("Failed to link your accounts, please try again later"), errors.Wrap(err, "failed to merge users")
+	}
+
+	return social.TextReply("Linked! Your accounts, balances, and history are now shared between platforms"), nil
+}
+
+// handleLinkCreate creates a link code and sends it to the user privately,
+// anyone with the code can link their account to this user
+func (h *Handlers) handleLinkCreate(ctx context.Context, msg *social.Message) (*social.Reply, error) {
+	l, err := h.a.CreateLinkCode(ctx, msg.From, linkCodeTTL)
+	if err != nil {
+		return social.TextReply("Failed to create a link code, please try again later"), errors.Wrap(err, "failed to create link code")
+	}
+
+	r := social.NewReply(social.Text("Your link code is "), social.Code(l.Code)).Add(
+		social.Textf("Run /link %s from your account on another platform within %v to link them. Don't share this code with anyone!", l.Code, linkCodeTTL),
+	)
+	if msg.Private {
+		return r, nil
+	}
+
+	if err := h.notify(msg.From, msg.PlatformName, r); err != nil {
+		log.Warnf("failed to send link code: %v", err)
+		return social.TextReply("I couldn't message you privately, start a chat with me and run /link there"), nil
+	}
+	return social.TextReply("I've sent you a link code privately"), nil
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/social"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// notify sends a message directly to a user on a platform
+func (h *Handlers) notify(u *account.User, p account.PlatformName, r *social.Reply) error {
+	if h.s == nil {
+		return fmt.Errorf("unable to notify user %s, no sender is configured", u.Id)
+	}
+
+	// private chats on our platforms share an ID with the user
+	chatID, ok := u.PlatformIds[p]
+	if !ok {
+		return fmt.Errorf("unable to notify user %s, not on platform %s", u.Id, p)
+	}
+
+	err := h.s.Send(&social.Message{
+		ChatID:       chatID,
+		PlatformName: p,
+	}, r)
+	return errors.Wrapf(err, "failed to notify user %s", u.Id)
+}
+
+// findTransaction finds a transaction by the (short) ID in the first token
+// of a command, limited to transactions where the user's leg is in state
+func (h *Handlers) findTransaction(ctx context.Context, msg *social.Message, tokens []string, states ...account.TransactionState) (*account.Transaction, *social.Reply, error) {
+	trans := make([]*account.Transaction, 0)
+	for _, state := range states {
+		t, err := h.a.GetTransactionsByState(ctx, msg.From, state)
+		if err != nil {
+			return nil, social.TextReply("Failed to retrieve your transactions"), errors.Wrap(err, "failed to list transactions")
+		}
+		trans = append(trans, t...)
+	}
+
+	id := ""
+	if len(tokens) > 1 {
+		id = tokens[1]
+	}
+
+	t, reply := matchTransaction(trans, id)
+	return t, reply, nil
+}
+
+// matchTransaction finds the only transaction whose ID starts with id,
+// otherwise it returns a reply explaining why there wasn't one
+func matchTransaction(trans []*account.Transaction, id string) (*account.Transaction, *social.Reply) {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if id == "" {
+		return nil, social.TextReply("Please provide the ID of a transaction, run /pending to see transactions waiting on you")
+	}
+
+	matches := make([]*account.Transaction, 0)
+	for _, t := range trans {
+		if strings.HasPrefix(t.Id.String(), id) {
+			matches = append(matches, t)
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil, social.TextReply("No matching transactions found, run /pending to see transactions waiting on you")
+	}
+
+	if len(matches) > 1 {
+		return nil, social.TextReply(fmt.Sprintf("Found %d matching transactions, please provide an ID from /pending", len(matches)))
+	}
+
+	return matches[0], nil
+}
+
+// HandlePending handles /pending
+func (h *Handlers) HandlePending(ctx context.Context, msg *social.Message) (*social.Reply, error) {
+	trans, err := h.a.GetTransactionsByState(ctx, msg.From, account.TransactionPending)
+	if err != nil {
+		return social.TextReply("Failed to retrieve your transactions"), errors.Wrap(err, "failed to list transactions")
+	}
+
+	if len(trans) == 0 {
+		return social.TextReply("Nothing is waiting on your approval"), nil
+	}
+
+	r := social.NewReply(social.Bold(fmt.Sprintf("Waiting On Your Approval (%d)", len(trans))))
+	l := r.List()
+	for _, t := range trans {
+		createdByUser, err := h.a.GetUser(ctx, t.CreatedBy)
+		if err != nil {
+			log.Warnf("failed to show invalid transaction, createdByUser not found: %v", err)
+			continue
+		}
+
+		// TODO: don't depend on USD
+		l.Add(
+			social.Code(t.ShortID()), social.Text(" "), social.Italic(t.CreatedAt.UTC().Format("01-02 15:04")),
+			social.Textf(": %s requested $%v from you", createdByUser.PlatformUsernames[msg.PlatformName], t.Share()),
+		)
+	}
+	r.Add(social.Text("To accept or dispute a transaction, run /accept ID or /dispute ID"))
+
+	return r, nil
+}
+
+// HandleAccept handles /accept ID
+func (h *Handlers) HandleAccept(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error) {
+	t, reply, err := h.findTransaction(ctx, msg, tokens, account.TransactionPending, account.TransactionDisputed)
+	if t == nil {
+		return reply, err
+	}
+
+	if err := h.a.AcceptTransaction(ctx, t, msg.From); err != nil {
+		return social.TextReply("Failed to accept transaction, please try again later"), errors.Wrap(err, "failed to accept transaction")
+	}
+
+	return social.TextReply(fmt.Sprintf("Accepted transaction %s", t.ShortID())), nil
+}
+
+// HandleDispute handles /dispute ID, and notifies the creator of the transaction
+func (h *Handlers) HandleDispute(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error) {
+	t, reply, err := h.findTransaction(ctx, msg, tokens, account.TransactionPending)
+	if t == nil {
+		return reply, err
+	}
+
+	if err := h.a.DisputeTransaction(ctx, t, msg.From); err != nil {
+		return social.TextReply("Failed to dispute transaction, please try again later"), errors.Wrap(err, "failed to dispute transaction")
+	}
+
+	createdByUser, err := h.a.GetUser(ctx, t.CreatedBy)
+	if err != nil {
+		log.Warnf("failed to notify creator of disputed transaction %s: %v", t.Id, err)
+	} else {
+		err = h.notify(createdByUser, msg.PlatformName, social.NewReply(
+			social.Bold(msg.From.PlatformUsernames[msg.PlatformName]),
+			social.Textf(" disputed your request of $%v (%s)", t.Share(), t.ShortID()),
+		))
+		if err != nil {
+			log.Warnf("failed to notify creator of disputed transaction %s: %v", t.Id, err)
+		}
+	}
+
+	return social.TextReply(fmt.Sprintf("Disputed transaction %s, I've let the creator know", t.ShortID())), nil
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+)
+
+func TestMatchTransaction(t *testing.T) {
+	trans := []*account.Transaction{
+		{Id: uuid.Must(uuid.FromString("1234abcd-0000-4000-8000-000000000001"))},
+		{Id: uuid.Must(uuid.FromString("1234ef01-0000-4000-8000-000000000002"))},
+		{Id: uuid.Must(uuid.FromString("9876abcd-0000-4000-8000-000000000003"))},
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		want *account.Transaction
+	}{
+		{name: "empty", id: ""},
+		{name: "whitespace", id: "  "},
+		{name: "ambiguous", id: "1234"},
+		{name: "no match", id: "ffff"},
+		{name: "short ID", id: "1234abcd", want: trans[0]},
+		{name: "uppercase", id: "1234EF01", want: trans[1]},
+		{name: "unique prefix", id: "98", want: trans[2]},
+		{name: "full ID", id: trans[2].Id.String(), want: trans[2]},
+	}
+
+	for _, tt := range tests {
+		got, reply := matchTransaction(trans, tt.id)
+		if got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+		if tt.want == nil && (reply == nil || reply.Empty()) {
+			t.Errorf("%s: expected a reply explaining why nothing matched", tt.name)
+		}
+		if tt.want != nil && reply != nil {
+			t.Errorf("%s: expected no reply, got '%s'", tt.name, reply)
+		}
+	}
+
+	if got, _ := matchTransaction(nil, "1234"); got != nil {
+		t.Errorf("expected no match without transactions, got %v", got)
+	}
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/social"
+	"github.com/pkg/errors"
+)
+
+// weekdays maps the names of weekdays to time.Weekday
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// HandleRecurring handles /recurring add|list|pause|resume|delete
+func (h *Handlers) HandleRecurring(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error) {
+	subcommand := ""
+	if len(tokens) > 1 {
+		subcommand = strings.ToLower(tokens[1])
+	}
+
+	switch subcommand {
+	case "add":
+		return h.handleRecurringAdd(ctx, msg, tokens[2:])
+	case "list", "":
+		return h.handleRecurringList(ctx, msg)
+	case "pause", "resume", "delete":
+		return h.handleRecurringUpdate(ctx, msg, subcommand, tokens[2:])
+	}
+
+	return social.TextReply(fmt.Sprintf("Unknown subcommand '%s', expected add, list, pause, resume, or delete", subcommand)), nil
+}
+
+// handleRecurringAdd handles /recurring add USERNAME... BALANCE ["DESCRIPTION"] FREQUENCY [on DAY]
+func (h *Handlers) handleRecurringAdd(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error) {
+	freqIndex := -1
+	for i, t := range tokens {
+		f := account.Frequency(strings.ToLower(t))
+		if f == account.FrequencyDaily || f == account.FrequencyWeekly || f == account.FrequencyMonthly {
+			freqIndex = i
+			break
+		}
+	}
+	if freqIndex == -1 {
+		return social.TextReply("Please provide how often this should run: daily, weekly, or monthly"), nil
+	}
+
+	c, reply := h.parseCharge(ctx, msg, tokens[:freqIndex])
+	if reply != nil {
+		return reply, nil
+	}
+
+	if c.balance == 0 {
+		return social.TextReply("Balance cannot be 0"), nil
+	}
+
+	if len(c.users) == 0 {
+		return social.TextReply("Please provide at least one user"), nil
+	}
+
+	// every occurrence would be refused, so refuse it now
+	if onlyYourself(msg.From, c.users) {
+		return social.TextReply("Cannot create a recurring transaction with only yourself"), nil
+	}
+
+	r := &account.RecurringTransaction{
+		CreatedBy:    msg.From.Id,
+		Subjects:     make([]uuid.UUID, 0, len(c.users)),
+		Amount:       float64(c.balance),
+		Description:  c.description,
+		Frequency:    account.Frequency(strings.ToLower(tokens[freqIndex])),
+		PlatformName: msg.PlatformName,
+		ChatID:       msg.ChatID,
+	}
+	for _, u := range c.users {
+		r.Subjects = append(r.Subjects, u.Id)
+	}
+
+	now := time.Now().UTC()
+	switch r.Frequency {
+	case account.FrequencyWeekly:
+		r.Day = int(now.Weekday())
+	case account.FrequencyMonthly:
+		r.Day = now.Day()
+	}
+
+	schedule := tokens[freqIndex+1:]
+	if len(schedule) > 0 && strings.ToLower(schedule[0]) == "on" {
+		schedule = schedule[1:]
+	}
+	if len(schedule) > 0 && r.Frequency != account.FrequencyDaily {
+		day, ok := parseDay(r.Frequency, schedule[0])
+		if !ok {
+			return social.TextReply(fmt.Sprintf("Invalid day '%s' for a %s transaction", schedule[0], r.Frequency)), nil
+		}
+		r.Day = day
+	}
+
+	if err := h.a.CreateRecurring(ctx, r); err != nil {
+		return social.TextReply("Failed to create recurring transaction, please try again later"), errors.Wrap(err, "failed to create recurring transaction")
+	}
+
+	reply = social.NewReply(
+		social.Text("Created recurring transaction "), social.Code(r.ShortID()),
+		social.Textf(", next run is on %s", r.NextRunAt.Format("2006-01-02")),
+	)
+	return withWarnings(reply, c.warnings), nil
+}
+
+// parseDay parses a day of the week (e.g. monday) for weekly transactions, or
+// a day of the month (e.g. 1st, 15) for monthly transactions
+func parseDay(f account.Frequency, s string) (int, bool) {
+	s = strings.ToLower(s)
+	if f == account.FrequencyWeekly {
+		d, ok := weekdays[s]
+		return int(d), ok
+	}
+
+	for _, suffix := range []string{"st", "nd", "rd", "th"} {
+		s = strings.TrimSuffix(s, suffix)
+	}
+
+	d, err := strconv.Atoi(s)
+	if err != nil || d < 1 || d > 31 {
+		return 0, false
+	}
+
+	return d, true
+}
+
+// describeSchedule returns a human readable version of when a recurring transaction runs
+func describeSchedule(r *account.RecurringTransaction) string {
+	switch r.Frequency {
+	case account.FrequencyWeekly:
+		return fmt.Sprintf("weekly on %s", time.Weekday(r.Day%7))
+	case account.FrequencyMonthly:
+		return fmt.Sprintf("monthly on day %d", r.Day)
+	}
+
+	return string(r.Frequency)
+}
+
+// handleRecurringList handles /recurring list
+func (h *Handlers) handleRecurringList(ctx context.Context, msg *social.Message) (*social.Reply, error) {
+	rs, err := h.a.ListRecurring(ctx, msg.From)
+	if err != nil {
+		return social.TextReply("Failed to retrieve your recurring transactions"), errors.Wrap(err, "failed to list recurring transactions")
+	}
+
+	if len(rs) == 0 {
+		return social.TextReply("You have no recurring transactions"), nil
+	}
+
+	resp := social.NewReply(social.Bold(fmt.Sprintf("Recurring Transactions (%d)", len(rs))))
+	l := resp.List()
+	for _, r := range rs {
+		names := make([]string, 0, len(r.Subjects))
+		for _, id := range r.Subjects {
+			u, err := h.a.GetUser(ctx, id)
+			if err != nil {
+				names = append(names, "unknown")
+				continue
+			}
+			names = append(names, u.PlatformUsernames[msg.PlatformName])
+		}
+
+		status := "next run " + r.NextRunAt.Format("2006-01-02")
+		if r.Paused {
+			status = "paused"
+		}
+
+		// TODO: don't depend on USD
+		l.Add(social.Code(r.ShortID()), social.Textf(" $%v from %s \"%s\", %s (%s)",
+			r.Amount, strings.Join(names, ", "), r.Description, describeSchedule(r), status))
+	}
+
+	return resp, nil
+}
+
+// handleRecurringUpdate handles /recurring pause|resume|delete ID
+func (h *Handlers) handleRecurringUpdate(ctx context.Context, msg *social.Message, subcommand string, tokens []string) (*social.Reply, error) {
+	if len(tokens) == 0 {
+		return social.TextReply(fmt.Sprintf("Please provide the ID of the recurring transaction to %s, see /recurring list", subcommand)), nil
+	}
+
+	rs, err := h.a.ListRecurring(ctx, msg.From)
+	if err != nil {
+		return social.TextReply("Failed to retrieve your recurring transactions"), errors.Wrap(err, "failed to list recurring transactions")
+	}
+
+	var r *account.RecurringTransaction
+	for _, candidate := range rs {
+		if strings.HasPrefix(candidate.Id.String(), strings.ToLower(tokens[0])) {
+			if r != nil {
+				return social.TextReply("Found multiple matching recurring transactions, please provide more of the ID"), nil
+			}
+			r = candidate
+		}
+	}
+	if r == nil {
+		return social.TextReply(fmt.Sprintf("No recurring transaction found with ID '%s'", tokens[0])), nil
+	}
+
+	switch subcommand {
+	case "pause":
+		err = h.a.SetRecurringPaused(ctx, r, true)
+	case "resume":
+		err = h.a.SetRecurringPaused(ctx, r, false)
+	case "delete":
+		err = h.a.DeleteRecurring(ctx, r)
+	}
+	if err != nil {
+		return social.TextReply("Failed to update recurring transaction, please try again later"), errors.Wrapf(err, "failed to %s recurring transaction", subcommand)
+	}
+
+	return social.NewReply(social.Text("Recurring transaction "), social.Code(r.ShortID()), social.Textf(" %sd", subcommand)), nil
+}
+
+// RunRecurring creates the transaction for an occurrence of a recurring transaction,
+// going through the same path as /add. Returns account.ErrTransactionExists if the
+// occurrence has already been created.
+func (h *Handlers) RunRecurring(ctx context.Context, r *account.RecurringTransaction, occurrence time.Time) (*social.Reply, error) {
+	from, err := h.a.GetUser(ctx, r.CreatedBy)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find creator of recurring transaction")
+	}
+
+	users := make([]account.User, 0, len(r.Subjects))
+	for _, id := range r.Subjects {
+		u, err := h.a.GetUser(ctx, id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find subject %s of recurring transaction", id)
+		}
+		users = append(users, *u)
+	}
+
+	rid := r.Id
+	return h.createBalance(ctx, from, users, &account.Transaction{
+		Amount:       r.Amount,
+		Description:  r.Description,
+		RecurringId:  &rid,
+		ScheduledFor: occurrence,
+		PlatformName: r.PlatformName,
+		ChatID:       r.ChatID,
+	}, r.PlatformName)
+}
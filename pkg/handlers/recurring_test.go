@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+)
+
+func TestOnlyYourself(t *testing.T) {
+	alice := account.User{Id: uuid.Must(uuid.NewV4())}
+	bob := account.User{Id: uuid.Must(uuid.NewV4())}
+
+	tests := []struct {
+		name  string
+		users []account.User
+		want  bool
+	}{
+		{name: "only yourself", users: []account.User{alice}, want: true},
+		{name: "someone else", users: []account.User{bob}},
+		{name: "yourself and someone else", users: []account.User{alice, bob}},
+		{name: "no one", users: nil},
+	}
+
+	for _, tt := range tests {
+		if got := onlyYourself(&alice, tt.users); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestParseDay(t *testing.T) {
+	tests := []struct {
+		f    account.Frequency
+		day  string
+		want int
+		ok   bool
+	}{
+		{f: account.FrequencyWeekly, day: "Monday", want: 1, ok: true},
+		{f: account.FrequencyWeekly, day: "sunday", want: 0, ok: true},
+		{f: account.FrequencyWeekly, day: "someday"},
+		{f: account.FrequencyMonthly, day: "1st", want: 1, ok: true},
+		{f: account.FrequencyMonthly, day: "22nd", want: 22, ok: true},
+		{f: account.FrequencyMonthly, day: "31", want: 31, ok: true},
+		{f: account.FrequencyMonthly, day: "32nd"},
+		{f: account.FrequencyMonthly, day: "0"},
+		{f: account.FrequencyMonthly, day: "monday"},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseDay(tt.f, tt.day)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseDay(%s, %s): expected %d, %v, got %d, %v", tt.f, tt.day, tt.want, tt.ok, got, ok)
+		}
+	}
+}
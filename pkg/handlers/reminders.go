@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/social"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReminderConfig configures when users are reminded about balances they owe
+type ReminderConfig struct {
+	// Threshold is the balance that an account has to reach before it's reminded about
+	Threshold float64
+
+	// Age is how long an account has to go without changing before it's reminded
+	// about, regardless of the balance
+	Age time.Duration
+
+	// Interval is the minimum amount of time between reminders to a user
+	Interval time.Duration
+}
+
+// DefaultReminderConfig is the reminder configuration used by NewHandlers
+var DefaultReminderConfig = ReminderConfig{
+	Threshold: 50,
+	Age:       7 * 24 * time.Hour,
+	Interval:  24 * time.Hour,
+}
+
+// debt is a balance one user owes another
+type debt struct {
+	creditor *account.User
+	amount   float64
+}
+
+// canRemind returns if a user can be sent a reminder at t, and if not a reason why
+func (h *Handlers) canRemind(u *account.User, t time.Time) (bool, string) {
+	if u.RemindersDisabled {
+		return false, "has opted out of reminders"
+	}
+
+	if u.InQuietHours(t) {
+		return false, "is in their quiet hours"
+	}
+
+	if t.Sub(u.LastRemindedAt) < h.Reminders.Interval {
+		return false, "was reminded recently"
+	}
+
+	return true, ""
+}
+
+// remind sends a reminder to a user about their debts
+func (h *Handlers) remind(ctx context.Context, u *account.User, debts []debt, p account.PlatformName, t time.Time) error {
+	r := social.TextReply("Friendly reminder, you have outstanding balances:")
+	l := r.List()
+	for _, d := range debts {
+		// TODO: hard dep on USD
+		l.Add(social.Text("You owe "), social.Bold(d.creditor.PlatformUsernames[p]), social.Textf(" $%v", d.amount))
+	}
+	r.Add(social.Text("To stop getting reminders, run /reminders off"))
+	r.AddButton("Stop reminders", "/reminders off")
+
+	// users we couldn't reach are tried again on the next run
+	if err := h.notify(u, p, r); err != nil {
+		return err
+	}
+	return h.a.MarkReminded(ctx, u, t)
+}
+
+// RunReminders reminds every user that has a balance that is over the threshold,
+// or hasn't changed in a while, about it
+func (h *Handlers) RunReminders(ctx context.Context, t time.Time) error {
+	accts, err := h.a.FindOutstandingAccounts(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list outstanding accounts")
+	}
+
+	debtors := make(map[uuid.UUID]*account.User)
+	debts := make(map[uuid.UUID][]debt)
+	for _, a := range accts {
+		debtor, creditor := a.Debtor()
+		if debtor == nil || creditor == nil {
+			continue
+		}
+
+		amount := math.Abs(a.Balance)
+		overThreshold := h.Reminders.Threshold > 0 && amount >= h.Reminders.Threshold
+		tooOld := h.Reminders.Age > 0 && t.Sub(a.UpdatedAt) >= h.Reminders.Age
+		if !overThreshold && !tooOld {
+			continue
+		}
+
+		debtors[debtor.Id] = debtor
+		debts[debtor.Id] = append(debts[debtor.Id], debt{creditor: creditor, amount: amount})
+	}
+
+	for id, debtor := range debtors {
+		if ok, reason := h.canRemind(debtor, t); !ok {
+			log.Debugf("not reminding user %s, user %s", id, reason)
+			continue
+		}
+
+		for p := range debtor.PlatformIds {
+			if err := h.remind(ctx, debtor, debts[id], p, t); err != nil {
+				log.Warnf("failed to remind user %s: %v", id, err)
+			}
+
+			// only remind a user on one platform
+			break
+		}
+	}
+
+	return nil
+}
+
+// HandleRemind handles /remind USERNAME, nudging a user about what they owe you
+func (h *Handlers) HandleRemind(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error) {
+	if len(tokens) < 2 {
+		return social.TextReply("Please provide the user to remind, e.g. /remind USERNAME"), nil
+	}
+
+	u, _, err := h.lookupUser(ctx, msg, tokens[1])
+	if err != nil {
+		return social.TextReply(fmt.Sprintf("Failed to find user %s", tokens[1])), nil
+	}
+
+	a, err := h.a.FindAccountBetween(ctx, msg.From, u)
+	if err == account.ErrAccountNotFound {
+		return social.NewReply(social.Bold(tokens[1]), social.Text(" doesn't owe you anything")), nil
+	} else if err != nil {
+		return social.TextReply("Failed to retrieve your balances"), errors.Wrap(err, "failed to find account")
+	}
+
+	debtor, creditor := a.Debtor()
+	if debtor == nil || debtor.Id != u.Id || creditor.Id != msg.From.Id {
+		return social.NewReply(social.Bold(tokens[1]), social.Text(" doesn't owe you anything")), nil
+	}
+
+	now := time.Now()
+	if ok, reason := h.canRemind(u, now); !ok {
+		return social.NewReply(social.Text("Not reminding "), social.Bold(tokens[1]), social.Textf(", they %s", reason)), nil
+	}
+
+	if err := h.remind(ctx, u, []debt{{creditor: msg.From, amount: math.Abs(a.Balance)}}, msg.PlatformName, now); err != nil {
+		return social.TextReply("Failed to send reminder, please try again later"), errors.Wrap(err, "failed to remind user")
+	}
+
+	return social.NewReply(social.Text("Reminded "), social.Bold(tokens[1])), nil
+}
+
+// HandleReminders handles /reminders on|off and /reminders quiet START END [TIMEZONE]
+func (h *Handlers) HandleReminders(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error) {
+	subcommand := ""
+	if len(tokens) > 1 {
+		subcommand = strings.ToLower(tokens[1])
+	}
+
+	u := msg.From
+	switch subcommand {
+	case "on":
+		u.RemindersDisabled = false
+	case "off":
+		u.RemindersDisabled = true
+	case "quiet":
+		if len(tokens) < 4 {
+			return social.TextReply("Please provide the hours to be quiet between, e.g. /reminders quiet 22 8 America/Los_Angeles"), nil
+		}
+
+		start, err := strconv.Atoi(tokens[2])
+		if err != nil || start < 0 || start > 23 {
+			return social.TextReply(fmt.Sprintf("Invalid hour '%s', expected 0-23", tokens[2])), nil
+		}
+
+		end, err := strconv.Atoi(tokens[3])
+		if err != nil || end < 0 || end > 23 {
+			return social.TextReply(fmt.Sprintf("Invalid hour '%s', expected 0-23", tokens[3])), nil
+		}
+
+		if len(tokens) > 4 {
+			if _, err := time.LoadLocation(tokens[4]); err != nil {
+				return social.TextReply(fmt.Sprintf("Unknown timezone '%s'", tokens[4])), nil
+			}
+			u.Timezone = tokens[4]
+		}
+
+		u.QuietHoursStart = start
+		u.QuietHoursEnd = end
+	default:
+		status := "on"
+		if u.RemindersDisabled {
+			status = "off"
+		}
+
+		quiet := "none"
+		if u.QuietHoursStart != u.QuietHoursEnd {
+			quiet = fmt.Sprintf("%d:00 to %d:00 (%s)", u.QuietHoursStart, u.QuietHoursEnd, u.Location())
+		}
+
+		return social.NewReply(
+			social.Text("Reminders are "), social.Bold(status), social.Textf(", quiet hours: %s", quiet),
+		).Add(
+			social.Text("Run /reminders on|off, or /reminders quiet START END [TIMEZONE] to change them"),
+		), nil
+	}
+
+	if err := h.a.UpdateReminderSettings(ctx, u); err != nil {
+		return social.TextReply("Failed to update your reminder settings, please try again later"), errors.Wrap(err, "failed to update reminder settings")
+	}
+
+	return social.TextReply("Updated your reminder settings"), nil
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/social"
+)
+
+// failingSender fails to send every message
+type failingSender struct {
+	sent int
+}
+
+func (s *failingSender) Send(to *social.Message, r *social.Reply) error {
+	s.sent++
+	return errors.New("chat not found")
+}
+
+func TestRemindFailedSend(t *testing.T) {
+	s := &failingSender{}
+
+	// without an account client, marking the user as reminded would panic
+	h := &Handlers{s: s, Reminders: DefaultReminderConfig}
+
+	u := &account.User{
+		Id:          uuid.Must(uuid.NewV4()),
+		PlatformIds: map[account.PlatformName]string{account.PlatformTelegram: "1001"},
+	}
+	creditor := &account.User{
+		Id:                uuid.Must(uuid.NewV4()),
+		PlatformUsernames: map[account.PlatformName]string{account.PlatformTelegram: "alice"},
+	}
+
+	err := h.remind(context.Background(), u, []debt{{creditor: creditor, amount: 60}}, account.PlatformTelegram, time.Now())
+	if err == nil {
+		t.Fatalf("expected an error when the reminder couldn't be sent")
+	}
+	if s.sent != 1 {
+		t.Errorf("expected 1 send attempt, got %d", s.sent)
+	}
+	if !u.LastRemindedAt.IsZero() {
+		t.Errorf("expected user not to be marked as reminded, got %v", u.LastRemindedAt)
+	}
+
+	// users that aren't on the platform can't be reminded there
+	err = h.remind(context.Background(), creditor, nil, account.PlatformTelegram, time.Now())
+	if err == nil {
+		t.Errorf("expected an error reminding a user that isn't on the platform")
+	}
+	if s.sent != 1 {
+		t.Errorf("expected no send attempt for a user that isn't on the platform, got %d", s.sent-1)
+	}
+}
+
+func TestCanRemind(t *testing.T) {
+	h := &Handlers{Reminders: DefaultReminderConfig}
+	now := time.Date(2020, 1, 31, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		u    account.User
+		want bool
+	}{
+		{name: "never reminded", u: account.User{}, want: true},
+		{name: "opted out", u: account.User{RemindersDisabled: true}},
+		{name: "quiet hours", u: account.User{QuietHoursStart: 9, QuietHoursEnd: 17}},
+		{name: "reminded recently", u: account.User{LastRemindedAt: now.Add(-time.Hour)}},
+		{name: "reminded a while ago", u: account.User{LastRemindedAt: now.Add(-48 * time.Hour)}, want: true},
+	}
+
+	for _, tt := range tests {
+		ok, reason := h.canRemind(&tt.u, now)
+		if ok != tt.want {
+			t.Errorf("%s: expected canRemind to be %v, got %v (%s)", tt.name, tt.want, ok, reason)
+		}
+		if !ok && reason == "" {
+			t.Errorf("%s: expected a reason the user can't be reminded", tt.name)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jaredallard/balance/pkg/social"
+	"github.com/jaredallard/balance/pkg/tracing"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// DefaultTimeout is how long a message can be handled for by default
+const DefaultTimeout = 1 * time.Minute
+
+// HandlerFunc handles a command, tokens[0] is the command that was run
+type HandlerFunc func(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error)
+
+// Router routes messages to the handler for their command
+type Router struct {
+	h      *Handlers
+	routes map[string]HandlerFunc
+
+	// ImplicitRegistration creates an account for unregistered users the first
+	// time they run a command that needs one, instead of asking them to /register
+	ImplicitRegistration bool
+
+	// Admins are the users that can run admin commands, as PLATFORM:USERID,
+	// e.g. telegram:12345
+	Admins []string
+
+	// Timeout is how long a message can be handled for before it's cancelled,
+	// zero means no timeout
+	Timeout time.Duration
+}
+
+// NewRouter creates a router with every command registered
+func NewRouter(h *Handlers) *Router {
+	r := &Router{
+		h:       h,
+		routes:  make(map[string]HandlerFunc),
+		Timeout: DefaultTimeout,
+	}
+
+	withoutTokens := func(fn func(context.Context, *social.Message) (*social.Reply, error)) HandlerFunc {
+		return func(ctx context.Context, msg *social.Message, _ []string) (*social.Reply, error) {
+			return fn(ctx, msg)
+		}
+	}
+
+	// commands that can be run by anyone
+	r.routes["help"] = withoutTokens(h.HandleHelp)
+	r.routes["start"] = withoutTokens(h.HandleHelp)
+	r.routes["register"] = r.lookupSucceeded(withoutTokens(h.HandleRegister))
+
+	// commands that need an account
+	r.routes["unregister"] = r.registered(withoutTokens(h.HandleUnregister))
+	r.routes["list"] = r.registered(withoutTokens(h.HandleListUsers))
+	r.routes["history"] = r.registered(h.HandleHistory)
+	r.routes["add"] = r.registered(h.HandleAdd)
+	r.routes["status"] = r.registered(withoutTokens(h.HandleBalance))
+	r.routes["pending"] = r.registered(withoutTokens(h.HandlePending))
+	r.routes["accept"] = r.registered(h.HandleAccept)
+	r.routes["dispute"] = r.registered(h.HandleDispute)
+	r.routes["recurring"] = r.registered(h.HandleRecurring)
+	r.routes["remind"] = r.registered(h.HandleRemind)
+	r.routes["reminders"] = r.registered(h.HandleReminders)
+	r.routes["statement"] = r.registered(h.HandleStatement)
+	r.routes["link"] = r.registered(h.HandleLink)
+	r.routes["token"] = r.registered(h.HandleToken)
+	r.routes["export"] = r.registered(h.HandleExport)
+
+	// commands that only admins can run
+	r.routes["import"] = r.admin(h.HandleImport)
+
+	return r
+}
+
+// lookupSucceeded is middleware that stops a message from being handled when
+// we failed to find out if its sender is registered
+func (r *Router) lookupSucceeded(fn HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error) {
+		if msg.Error != nil {
+			return social.TextReply("Something went wrong looking up your account, please try again later"), errors.Wrap(msg.Error, "failed to lookup user")
+		}
+
+		return fn(ctx, msg, tokens)
+	}
+}
+
+// registered is middleware that only allows registered users to run a command,
+// registering them first if implicit registration is enabled
+func (r *Router) registered(fn HandlerFunc) HandlerFunc {
+	return r.lookupSucceeded(func(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error) {
+		if msg.From != nil {
+			return fn(ctx, msg, tokens)
+		}
+
+		if !r.ImplicitRegistration {
+			return social.TextReply("You don't have an account yet, run /register to create one"), nil
+		}
+
+		welcome, err := r.h.HandleRegister(ctx, msg)
+		if msg.From == nil {
+			return welcome, err
+		}
+
+		reply, err := fn(ctx, msg, tokens)
+		return welcome.Append(reply), err
+	})
+}
+
+// admin is middleware that only allows admins to run a command
+func (r *Router) admin(fn HandlerFunc) HandlerFunc {
+	return r.lookupSucceeded(func(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error) {
+		for _, admin := range r.Admins {
+			if admin == fmt.Sprintf("%s:%s", msg.PlatformName, msg.UserID) {
+				return fn(ctx, msg, tokens)
+			}
+		}
+
+		log.Warnf("user %s:%s tried to run admin command '%s'", msg.PlatformName, msg.UserID, tokens[0])
+		return social.TextReply("Only admins can run that command"), nil
+	})
+}
+
+// parseCommand returns the command of a token, commands in groups can be
+// addressed to a bot, e.g. /add@balancebot
+func parseCommand(token string) string {
+	return strings.ToLower(strings.SplitN(strings.Replace(token, "/", "", 1), "@", 2)[0])
+}
+
+// Command returns the command a message is for, "unknown" if it isn't a
+// command we know, or "" if the message is empty
+func (r *Router) Command(msg *social.Message) string {
+	tokens := Tokenize(msg.Text)
+	if len(tokens) == 0 {
+		return ""
+	}
+
+	command := parseCommand(tokens[0])
+	if _, ok := r.routes[command]; !ok {
+		return "unknown"
+	}
+	return command
+}
+
+// Handle routes a message to the handler for its command, the handler runs in
+// a span that's a child of ctx's
+func (r *Router) Handle(ctx context.Context, msg *social.Message) (*social.Reply, error) {
+	// TODO(jaredallard): better entity handling
+	tokens := Tokenize(msg.Text)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	tokens[0] = parseCommand(tokens[0])
+
+	fn, ok := r.routes[tokens[0]]
+	if !ok {
+		log.Infof("unknown command '%s'", tokens[0])
+		return social.TextReply(fmt.Sprintf("Unknown command '%s'", msg.Text)), nil
+	}
+
+	if r.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "command "+tokens[0])
+	defer span.End()
+
+	reply, err := fn(ctx, msg, tokens)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return reply, err
+}
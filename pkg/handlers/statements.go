@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/social"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// statement is a summary of what changed between Since and Until
+type statement struct {
+	Title        string
+	Since        time.Time
+	Until        time.Time
+	Transactions []*account.Transaction
+	Settlements  []*account.Transaction
+	Accounts     []*account.Account
+}
+
+// renderStatement renders a statement from the perspective of viewer, or a
+// third party if viewer is nil
+func (h *Handlers) renderStatement(ctx context.Context, s *statement, viewer *account.User, trans []*account.Transaction, p account.PlatformName) (*social.Reply, error) {
+	for _, t := range trans {
+		if t.IsSettlement() {
+			s.Settlements = append(s.Settlements, t)
+		} else {
+			s.Transactions = append(s.Transactions, t)
+		}
+	}
+
+	r := social.NewReply(
+		social.Bold(s.Title), social.Text("\n"),
+		social.Italic(s.Since.Format("2006-01-02")+" to "+s.Until.Format("2006-01-02")),
+	)
+
+	r.Add(social.Bold(fmt.Sprintf("New Transactions (%d)", len(s.Transactions))))
+	if len(s.Transactions) != 0 {
+		r.Append(h.formatHistory(ctx, viewer, nil, s.Transactions, p))
+	} else {
+		r.Add(social.Text("No new transactions"))
+	}
+
+	r.Add(social.Bold(fmt.Sprintf("Settlements (%d)", len(s.Settlements))))
+	if len(s.Settlements) != 0 {
+		r.Append(h.formatHistory(ctx, viewer, nil, s.Settlements, p))
+	} else {
+		r.Add(social.Text("No settlements"))
+	}
+
+	r.Add(social.Bold("Net Changes"))
+	if changes := h.netChanges(ctx, viewer, trans, p); len(changes) != 0 {
+		l := r.List()
+		for _, c := range changes {
+			l.Add(c...)
+		}
+	} else {
+		r.Add(social.Text("No changes"))
+	}
+
+	if len(s.Accounts) != 0 {
+		balances, err := h.formatBalances(ctx, viewer, s.Accounts, p)
+		if err != nil {
+			return nil, err
+		}
+		r.Append(balances)
+	}
+
+	return r, nil
+}
+
+// netChanges returns how much each pair of users' balance changed from the
+// accepted legs of trans, from the perspective of viewer if it's set
+func (h *Handlers) netChanges(ctx context.Context, viewer *account.User, trans []*account.Transaction, p account.PlatformName) []social.Paragraph {
+	// pairs maps [a, b] to how much more b owes a, where a sorts before b
+	pairs := make(map[[2]uuid.UUID]float64)
+	for _, t := range trans {
+		for uid := range t.Accounts {
+			if t.State(uid) != account.TransactionAccepted {
+				continue
+			}
+
+			key := [2]uuid.UUID{t.CreatedBy, uid}
+			amount := t.Share()
+			if key[0].String() > key[1].String() {
+				key = [2]uuid.UUID{uid, t.CreatedBy}
+				amount = -amount
+			}
+			pairs[key] += amount
+		}
+	}
+
+	name := func(id uuid.UUID) social.Span {
+		if viewer != nil && viewer.Id == id {
+			return social.Text("you")
+		}
+
+		u, err := h.a.GetUser(ctx, id)
+		if err != nil {
+			log.Warnf("failed to find user %s for statement: %v", id, err)
+			return social.Text("unknown")
+		}
+		return social.Bold(u.PlatformUsernames[p])
+	}
+
+	changes := make([]social.Paragraph, 0, len(pairs))
+	for key, amount := range pairs {
+		if amount == 0 {
+			continue
+		}
+
+		creditor, debtor := key[0], key[1]
+		if amount < 0 {
+			creditor, debtor = debtor, creditor
+		}
+
+		// TODO: hard dep on USD
+		verb := "owes"
+		if viewer != nil && viewer.Id == debtor {
+			verb = "owe"
+		}
+		first := name(debtor)
+		first.Text = capitalize(first.Text)
+		changes = append(changes, social.Paragraph{
+			first, social.Textf(" %s ", verb), name(creditor), social.Textf(" $%v more", math.Abs(amount)),
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return social.NewReply(changes[i]...).String() < social.NewReply(changes[j]...).String()
+	})
+
+	return changes
+}
+
+// capitalize upper cases the first letter of text
+func capitalize(text string) string {
+	r, size := utf8.DecodeRuneInString(text)
+	if r == utf8.RuneError {
+		return text
+	}
+
+	return string(unicode.ToUpper(r)) + text[size:]
+}
+
+// buildStatement renders the statement for a subscription, covering everything since it was last sent
+func (h *Handlers) buildStatement(ctx context.Context, sub *account.StatementSubscription, until time.Time) (*social.Reply, error) {
+	s := &statement{
+		Title: fmt.Sprintf("Your %s Statement", capitalize(string(sub.Frequency))),
+		Since: sub.LastSentAt,
+		Until: until,
+	}
+
+	// group statements only include what happened in the group
+	if sub.UserId == nil {
+		s.Title = fmt.Sprintf("%s Group Statement", capitalize(string(sub.Frequency)))
+		trans, err := h.a.GetTransactionsInChat(ctx, sub.PlatformName, sub.ChatID, sub.LastSentAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list transactions")
+		}
+
+		return h.renderStatement(ctx, s, nil, trans, sub.PlatformName)
+	}
+
+	u, err := h.a.GetUser(ctx, *sub.UserId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find user")
+	}
+
+	trans, err := h.a.GetTransactionsByUserSince(ctx, u, sub.LastSentAt)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list transactions")
+	}
+
+	s.Accounts, err = h.a.FindAccounts(ctx, u)
+	if err != nil && err != account.ErrAccountNotFound {
+		return nil, errors.Wrap(err, "failed to list accounts")
+	}
+
+	return h.renderStatement(ctx, s, u, trans, sub.PlatformName)
+}
+
+// RunStatements sends every statement that is due
+func (h *Handlers) RunStatements(ctx context.Context, t time.Time) error {
+	subs, err := h.a.GetDueStatements(ctx, t)
+	if err != nil {
+		return errors.Wrap(err, "failed to list due statements")
+	}
+
+	for _, sub := range subs {
+		r, err := h.buildStatement(ctx, sub, t)
+		if err != nil {
+			log.Errorf("failed to build statement %s: %v", sub.Id, err)
+			continue
+		}
+
+		if h.s != nil {
+			err := h.s.Send(&social.Message{
+				ChatID:       sub.ChatID,
+				PlatformName: sub.PlatformName,
+			}, r)
+			if err != nil {
+				log.Errorf("failed to send statement %s: %v", sub.Id, err)
+				continue
+			}
+		}
+
+		if err := h.a.AdvanceStatement(ctx, sub, t); err != nil {
+			log.Errorf("failed to advance statement %s: %v", sub.Id, err)
+		}
+	}
+
+	return nil
+}
+
+// HandleStatement handles /statement weekly|monthly|off
+func (h *Handlers) HandleStatement(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error) {
+	subcommand := ""
+	if len(tokens) > 1 {
+		subcommand = strings.ToLower(tokens[1])
+	}
+
+	target := "you"
+	if !msg.Private {
+		target = "this group"
+	}
+
+	switch account.Frequency(subcommand) {
+	case account.FrequencyWeekly, account.FrequencyMonthly:
+	case "off":
+		if err := h.a.Unsubscribe(ctx, msg.PlatformName, msg.ChatID); err != nil {
+			return social.TextReply("Failed to turn off statements, please try again later"), errors.Wrap(err, "failed to unsubscribe")
+		}
+		return social.TextReply(fmt.Sprintf("Statements turned off for %s", target)), nil
+	default:
+		return social.TextReply("Please provide how often to send statements: /statement weekly|monthly|off"), nil
+	}
+
+	sub := &account.StatementSubscription{
+		PlatformName: msg.PlatformName,
+		ChatID:       msg.ChatID,
+		Frequency:    account.Frequency(subcommand),
+	}
+	if msg.Private {
+		sub.UserId = &msg.From.Id
+	}
+
+	if err := h.a.Subscribe(ctx, sub); err != nil {
+		return social.TextReply("Failed to turn on statements, please try again later"), errors.Wrap(err, "failed to subscribe")
+	}
+
+	return social.TextReply(fmt.Sprintf("I'll send %s a %s statement, the next one is on %s", target, subcommand, sub.NextRunAt.Format("2006-01-02"))), nil
+}
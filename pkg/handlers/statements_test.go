@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCapitalize(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "", want: ""},
+		{text: "alice", want: "Alice"},
+		{text: "Alice", want: "Alice"},
+		{text: "a", want: "A"},
+		{text: "émile", want: "Émile"},
+		{text: "łukasz", want: "Łukasz"},
+		{text: "日本", want: "日本"},
+		{text: "1user", want: "1user"},
+		{text: "weekly", want: "Weekly"},
+		{text: "\xffbad", want: "\xffbad"},
+	}
+
+	for _, tt := range tests {
+		got := capitalize(tt.text)
+		if got != tt.want {
+			t.Errorf("capitalize(%q): expected %q, got %q", tt.text, tt.want, got)
+		}
+		if utf8.ValidString(tt.text) && !utf8.ValidString(got) {
+			t.Errorf("capitalize(%q): expected valid UTF-8, got %q", tt.text, got)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jaredallard/balance/pkg/social"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// HandleToken handles /token, which creates an API token, and /token revoke,
+// which revokes every API token the user has
+func (h *Handlers) HandleToken(ctx context.Context, msg *social.Message, tokens []string) (*social.Reply, error) {
+	if len(tokens) > 1 && strings.ToLower(tokens[1]) == "revoke" {
+		if err := h.a.RevokeAPITokens(ctx, msg.From); err != nil {
+			return social.TextReply("Failed to revoke your tokens, please try again later"), errors.Wrap(err, "failed to revoke api tokens")
+		}
+
+		return social.TextReply("Revoked all of your API tokens"), nil
+	}
+
+	token, _, err := h.a.CreateAPIToken(ctx, msg.From)
+	if err != nil {
+		return social.TextReply("Failed to create a token, please try again later"), errors.Wrap(err, "failed to create api token")
+	}
+
+	r := social.NewReply(social.Text("Your API token is "), social.Code(token)).Add(
+		social.Text("Send it in the Authorization header as "), social.Code("Bearer TOKEN"),
+		social.Text(". Don't share this token with anyone! Run /token revoke to revoke it."),
+	)
+	if msg.Private {
+		return r, nil
+	}
+
+	if err := h.notify(msg.From, msg.PlatformName, r); err != nil {
+		log.Warnf("failed to send API token: %v", err)
+		return social.TextReply("I couldn't message you privately, start a chat with me and run /token there"), nil
+	}
+	return social.TextReply("I've sent you an API token privately"), nil
+}
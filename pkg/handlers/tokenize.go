@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Tokenize splits a message into tokens on whitespace. Text wrapped in double
+// quotes is kept as a single token, including the quotes, so that handlers
+// can tell descriptions apart from usernames.
+func Tokenize(text string) []string {
+	tokens := make([]string, 0)
+
+	var cur strings.Builder
+	quoted := false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			cur.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if cur.Len() != 0 {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+
+	if cur.Len() != 0 {
+		tokens = append(tokens, cur.String())
+	}
+
+	return tokens
+}
+
+// isQuoted returns if a token was quoted
+func isQuoted(token string) bool {
+	return len(token) >= 2 && strings.HasPrefix(token, `"`) && strings.HasSuffix(token, `"`)
+}
+
+// unquote removes the quotes from a quoted token
+func unquote(token string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(token, `"`), `"`)
+}
@@ -0,0 +1,110 @@
+// Package health serves liveness and readiness checks
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/social"
+	log "github.com/sirupsen/logrus"
+)
+
+// status is the body of a health check response
+type status struct {
+	Status string `json:"status"`
+
+	// Checks are the result of each readiness check, "ok" or an error
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Checker checks if the bot is alive and ready to handle messages
+type Checker struct {
+	a *account.Client
+
+	mu        sync.RWMutex
+	providers map[string]social.Provider
+}
+
+// NewChecker creates a new health checker
+func NewChecker(a *account.Client) *Checker {
+	return &Checker{
+		a:         a,
+		providers: make(map[string]social.Provider),
+	}
+}
+
+// AddProvider adds a provider to the readiness checks, providers that don't
+// implement social.HealthReporter are assumed to be connected
+func (c *Checker) AddProvider(name string, p social.Provider) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.providers[name] = p
+}
+
+// checks runs every readiness check
+func (c *Checker) checks(ctx context.Context) map[string]error {
+	checks := map[string]error{
+		"database":   c.a.Ping(ctx),
+		"migrations": c.a.CheckMigrations(ctx),
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for name, p := range c.providers {
+		if hr, ok := p.(social.HealthReporter); ok {
+			checks["provider:"+name] = hr.Health()
+		} else {
+			checks["provider:"+name] = nil
+		}
+	}
+
+	return checks
+}
+
+// writeStatus writes a health check response
+func writeStatus(w http.ResponseWriter, code int, s status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		log.Warnf("failed to write health check response: %v", err)
+	}
+}
+
+// ServeLive handles /healthz, which only checks that the process is serving requests
+func (c *Checker) ServeLive(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, status{Status: "ok"})
+}
+
+// ServeReady handles /readyz, which checks that the database is reachable and
+// migrated, and that every provider is connected
+func (c *Checker) ServeReady(w http.ResponseWriter, r *http.Request) {
+	s := status{Status: "ok", Checks: make(map[string]string)}
+	code := http.StatusOK
+
+	checks := c.checks(r.Context())
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := checks[name]; err != nil {
+			log.Warnf("readiness check %s failed: %v", name, err)
+			s.Checks[name] = err.Error()
+			s.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+			continue
+		}
+		s.Checks[name] = "ok"
+	}
+
+	writeStatus(w, code, s)
+}
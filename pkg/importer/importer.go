@@ -0,0 +1,270 @@
+// Package importer imports transaction history from other services
+package importer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+)
+
+// ErrUnmapped is returned when an import references people that aren't in the mapping
+var ErrUnmapped error = errors.New("People in the import aren't mapped to users")
+
+// Currency is the only currency that can be imported
+// TODO: don't depend on USD
+const Currency = "USD"
+
+// Mapping maps the names of people in an import to users, either by their
+// username or by their ID as id:ID
+type Mapping map[string]string
+
+// ParseMapping parses a mapping, one NAME=USERNAME per line. Blank lines, and
+// lines starting with #, are ignored.
+func ParseMapping(r io.Reader) (Mapping, error) {
+	m := make(Mapping)
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		if err := m.Add(text); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+
+	return m, s.Err()
+}
+
+// Add adds a NAME=USERNAME entry to a mapping
+func (m Mapping) Add(entry string) error {
+	parts := strings.SplitN(entry, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return fmt.Errorf("expected NAME=USERNAME, got '%s'", entry)
+	}
+
+	m[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	return nil
+}
+
+// Report is the outcome of an import
+type Report struct {
+	// DryRun is set when nothing was actually imported
+	DryRun bool
+
+	// Expenses is how many expenses were in the import
+	Expenses int
+
+	// Created is how many transactions were, or would be, created
+	Created int
+
+	// Existing is how many transactions had already been imported
+	Existing int
+
+	// Skipped are the expenses that couldn't be imported, and why
+	Skipped []string
+
+	// Unmapped are the people that aren't in the mapping
+	Unmapped []string
+
+	// Net is how much each person is owed from the transactions that were
+	// created, in cents
+	Net map[string]int64
+}
+
+// String returns a human readable summary of a report
+func (r *Report) String() string {
+	s := ""
+	if r.DryRun {
+		s += "Dry run, nothing was imported\n\n"
+	}
+
+	s += fmt.Sprintf("Expenses: %d\nTransactions created: %d\nAlready imported: %d\n", r.Expenses, r.Created, r.Existing)
+
+	if len(r.Unmapped) != 0 {
+		s += "\nNot mapped to a user:\n"
+		for _, name := range r.Unmapped {
+			s += fmt.Sprintf(" •	%s\n", name)
+		}
+	}
+
+	if len(r.Skipped) != 0 {
+		s += "\nSkipped:\n"
+		for _, reason := range r.Skipped {
+			s += fmt.Sprintf(" •	%s\n", reason)
+		}
+	}
+
+	if len(r.Net) != 0 {
+		names := make([]string, 0, len(r.Net))
+		for name := range r.Net {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		s += "\nNet change:\n"
+		for _, name := range names {
+			s += fmt.Sprintf(" •	%s: %+.2f\n", name, float64(r.Net[name])/100)
+		}
+	}
+
+	return s
+}
+
+// Store is the part of the account client transactions are imported into
+type Store interface {
+	GetUser(ctx context.Context, id uuid.UUID) (*account.User, error)
+	LookupUsername(ctx context.Context, p account.PlatformName, username string) (*account.User, bool, error)
+	IsImported(ctx context.Context, importID string) (bool, error)
+	ImportTransaction(ctx context.Context, t *account.Transaction, createdBy *account.User, involved []account.User) error
+}
+
+// Importer imports transaction history
+type Importer struct {
+	a Store
+
+	// Platform is the platform of the usernames in mappings
+	Platform account.PlatformName
+}
+
+// NewImporter creates a new importer
+func NewImporter(a Store, p account.PlatformName) *Importer {
+	return &Importer{a: a, Platform: p}
+}
+
+// resolve returns the users of every person in names, and the names that
+// couldn't be resolved
+func (i *Importer) resolve(ctx context.Context, m Mapping, names []string) (map[string]*account.User, []string, error) {
+	users := make(map[string]*account.User)
+	unmapped := []string{}
+	for _, name := range names {
+		v, ok := m[name]
+		if !ok {
+			unmapped = append(unmapped, name)
+			continue
+		}
+
+		var u *account.User
+		var err error
+		if strings.HasPrefix(v, "id:") {
+			id, perr := uuid.FromString(strings.TrimPrefix(v, "id:"))
+			if perr != nil {
+				return nil, nil, fmt.Errorf("invalid user id for %s: %v", name, perr)
+			}
+			u, err = i.a.GetUser(ctx, id)
+		} else {
+			u, _, err = i.a.LookupUsername(ctx, i.Platform, strings.ToLower(strings.TrimPrefix(v, "@")))
+		}
+
+		if err == account.ErrUserNotFound {
+			unmapped = append(unmapped, fmt.Sprintf("%s (user '%s' not found)", name, v))
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		users[name] = u
+	}
+
+	return users, unmapped, nil
+}
+
+// ImportSplitwise imports a Splitwise CSV export, creating an accepted
+// transaction for each debt of each expense. Debts that were already imported
+// are skipped, so an export can be imported more than once. If dryRun is set,
+// nothing is created and the report is of what would have been.
+func (i *Importer) ImportSplitwise(ctx context.Context, r io.Reader, m Mapping, dryRun bool) (*Report, error) {
+	expenses, err := ParseSplitwise(r)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		DryRun:   dryRun,
+		Expenses: len(expenses),
+		Net:      make(map[string]int64),
+	}
+
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, e := range expenses {
+		for name := range e.Balances {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	users, unmapped, err := i.resolve(ctx, m, names)
+	if err != nil {
+		return nil, err
+	}
+
+	// don't import anything unless everyone can be, otherwise balances would be wrong
+	if len(unmapped) != 0 {
+		report.Unmapped = unmapped
+		return report, ErrUnmapped
+	}
+
+	for _, e := range expenses {
+		if !strings.EqualFold(e.Currency, Currency) {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("row %d (%s): currency %s isn't supported", e.Row, e.Description, e.Currency))
+			continue
+		}
+
+		for _, d := range e.Debts() {
+			creditor, debtor := users[d.Creditor], users[d.Debtor]
+			if creditor.Id == debtor.Id {
+				report.Skipped = append(report.Skipped, fmt.Sprintf("row %d (%s): %s and %s are the same user", e.Row, e.Description, d.Creditor, d.Debtor))
+				continue
+			}
+
+			importID := e.ImportID(d)
+			exists, err := i.a.IsImported(ctx, importID)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				report.Existing++
+				continue
+			}
+
+			if !dryRun {
+				t := &account.Transaction{
+					Amount:      float64(d.Amount) / 100,
+					Kind:        account.TransactionCharge,
+					Description: e.Description,
+					ImportId:    importID,
+					CreatedAt:   e.Date,
+				}
+				if e.IsPayment() {
+					t.Kind = account.TransactionPayment
+				}
+
+				err := i.a.ImportTransaction(ctx, t, creditor, []account.User{*debtor})
+				if err == account.ErrTransactionExists {
+					report.Existing++
+					continue
+				} else if err != nil {
+					return nil, fmt.Errorf("failed to import row %d: %v", e.Row, err)
+				}
+			}
+
+			report.Created++
+			report.Net[d.Creditor] += d.Amount
+			report.Net[d.Debtor] -= d.Amount
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,186 @@
+package importer
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+)
+
+// store is an in-memory Store
+type store struct {
+	users    []*account.User
+	imported []*account.Transaction
+}
+
+func (s *store) addUser(username string) *account.User {
+	u := &account.User{
+		Id:                uuid.Must(uuid.NewV4()),
+		PlatformUsernames: map[account.PlatformName]string{account.PlatformTelegram: username},
+	}
+	s.users = append(s.users, u)
+	return u
+}
+
+func (s *store) GetUser(ctx context.Context, id uuid.UUID) (*account.User, error) {
+	for _, u := range s.users {
+		if u.Id == id {
+			return u, nil
+		}
+	}
+	return nil, account.ErrUserNotFound
+}
+
+func (s *store) LookupUsername(ctx context.Context, p account.PlatformName, username string) (*account.User, bool, error) {
+	for _, u := range s.users {
+		if u.PlatformUsernames[p] == username {
+			return u, false, nil
+		}
+	}
+	return nil, false, account.ErrUserNotFound
+}
+
+func (s *store) IsImported(ctx context.Context, importID string) (bool, error) {
+	for _, t := range s.imported {
+		if t.ImportId == importID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *store) ImportTransaction(ctx context.Context, t *account.Transaction, createdBy *account.User, involved []account.User) error {
+	if ok, _ := s.IsImported(ctx, t.ImportId); ok {
+		return account.ErrTransactionExists
+	}
+
+	t.CreatedBy = createdBy.Id
+	t.Accounts = map[uuid.UUID]uuid.UUID{involved[0].Id: uuid.Nil}
+	s.imported = append(s.imported, t)
+	return nil
+}
+
+const export = splitwiseHeader +
+	"2021-01-02,Pizza,Dining out,30.00,USD,20.00,-10.00,-10.00\n" +
+	"2021-01-03,Payment,Payment,10.00,USD,-10.00,10.00,\n" +
+	"2021-01-04,Museum,Entertainment,20.00,EUR,10.00,,-10.00\n" +
+	",Total balance,,,USD,10.00,0.00,-10.00\n"
+
+// newTestImporter creates an importer for alice, bob and carol, and the mapping
+// of the people in export to them
+func newTestImporter() (*Importer, *store, Mapping) {
+	s := &store{}
+	s.addUser("alice")
+	s.addUser("bob")
+	carol := s.addUser("carol")
+
+	m := Mapping{"Alice": "@Alice", "Bob": "bob", "Carol": "id:" + carol.Id.String()}
+	return NewImporter(s, account.PlatformTelegram), s, m
+}
+
+func TestImportSplitwise(t *testing.T) {
+	i, s, m := newTestImporter()
+	ctx := context.Background()
+
+	report, err := i.ImportSplitwise(ctx, strings.NewReader(export), m, false)
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+
+	if report.Expenses != 3 || report.Created != 3 || report.Existing != 0 || len(report.Skipped) != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if want := map[string]int64{"Alice": 1000, "Bob": 0, "Carol": -1000}; !reflect.DeepEqual(report.Net, want) {
+		t.Errorf("expected net changes %v, got %v", want, report.Net)
+	}
+
+	if len(s.imported) != 3 {
+		t.Fatalf("expected 3 transactions to be imported, got %d", len(s.imported))
+	}
+	for _, tr := range s.imported[:2] {
+		if tr.Kind != account.TransactionCharge || tr.Amount != 10 || tr.Description != "Pizza" || tr.CreatedBy != s.users[0].Id {
+			t.Errorf("expected alice to charge 10 for pizza, got %+v", tr)
+		}
+	}
+	if tr := s.imported[2]; tr.Kind != account.TransactionPayment || tr.CreatedBy != s.users[1].Id {
+		t.Errorf("expected bob's payment to be imported as a payment from him, got %+v", tr)
+	}
+
+	// importing again doesn't create anything twice
+	report, err = i.ImportSplitwise(ctx, strings.NewReader(export), m, false)
+	if err != nil {
+		t.Fatalf("failed to import again: %v", err)
+	}
+	if report.Created != 0 || report.Existing != 3 || len(s.imported) != 3 {
+		t.Errorf("expected everything to already be imported, got %+v", report)
+	}
+}
+
+func TestImportSplitwiseDryRun(t *testing.T) {
+	i, s, m := newTestImporter()
+
+	report, err := i.ImportSplitwise(context.Background(), strings.NewReader(export), m, true)
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+
+	if !report.DryRun || report.Created != 3 {
+		t.Errorf("expected a report of what would be imported, got %+v", report)
+	}
+	if !strings.HasPrefix(report.String(), "Dry run, nothing was imported") {
+		t.Errorf("expected the report to say it's a dry run, got '%s'", report.String())
+	}
+	if len(s.imported) != 0 {
+		t.Errorf("expected a dry run not to import anything, got %d transactions", len(s.imported))
+	}
+}
+
+func TestImportSplitwiseUnmapped(t *testing.T) {
+	i, s, m := newTestImporter()
+	delete(m, "Bob")
+	m["Carol"] = "dave"
+
+	report, err := i.ImportSplitwise(context.Background(), strings.NewReader(export), m, false)
+	if err != ErrUnmapped {
+		t.Fatalf("expected people that aren't mapped to fail the import, got %v", err)
+	}
+
+	if want := []string{"Bob", "Carol (user 'dave' not found)"}; !reflect.DeepEqual(report.Unmapped, want) {
+		t.Errorf("expected %v to be unmapped, got %v", want, report.Unmapped)
+	}
+	if len(s.imported) != 0 {
+		t.Errorf("expected nothing to be imported, got %d transactions", len(s.imported))
+	}
+}
+
+func TestImportSplitwiseSameUser(t *testing.T) {
+	i, s, m := newTestImporter()
+	m["Bob"] = "alice"
+
+	report, err := i.ImportSplitwise(context.Background(), strings.NewReader(export), m, false)
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+
+	// alice can't charge herself, or pay herself back
+	if report.Created != 1 || len(report.Skipped) != 3 || len(s.imported) != 1 {
+		t.Errorf("expected debts between the same user to be skipped, got %+v", report)
+	}
+}
+
+func TestParseMapping(t *testing.T) {
+	m, err := ParseMapping(strings.NewReader("# splitwise names\nAlice = @alice\n\nBob Smith=id:1234\n"))
+	if err != nil {
+		t.Fatalf("failed to parse mapping: %v", err)
+	}
+	if want := (Mapping{"Alice": "@alice", "Bob Smith": "id:1234"}); !reflect.DeepEqual(m, want) {
+		t.Errorf("expected %v, got %v", want, m)
+	}
+
+	if _, err := ParseMapping(strings.NewReader("Alice=alice\nBob\n")); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("expected an invalid line to fail, got %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package importer
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// splitwiseColumns are the columns of a Splitwise export before the columns
+// of each person's balance
+var splitwiseColumns = []string{"Date", "Description", "Category", "Cost", "Currency"}
+
+// SplitwiseExpense is a row of a Splitwise export
+type SplitwiseExpense struct {
+	// Row is the row of the export this expense is on, the header is row 1
+	Row int
+
+	Date        time.Time
+	Description string
+	Category    string
+	Cost        float64
+	Currency    string
+
+	// Balances is how much each person is owed because of this expense, in
+	// cents, negative if they owe it
+	Balances map[string]int64
+
+	// Occurrence is how many identical expenses came before this one in the
+	// export, so that they can be told apart
+	Occurrence int
+}
+
+// Debt is an amount one person owes another because of an expense
+type Debt struct {
+	Creditor string
+	Debtor   string
+
+	// Amount is in cents
+	Amount int64
+}
+
+// IsPayment returns if this expense is someone paying someone back
+func (e *SplitwiseExpense) IsPayment() bool {
+	return strings.EqualFold(e.Category, "Payment")
+}
+
+// key returns a stable identifier of the contents of this expense
+func (e *SplitwiseExpense) key() string {
+	names := make([]string, 0, len(e.Balances))
+	for name := range e.Balances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s|%s|%v|%s", e.Date.Format("2006-01-02"), e.Description, e.Category, e.Cost, e.Currency)
+	for _, name := range names {
+		fmt.Fprintf(h, "|%s=%d", name, e.Balances[name])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// ImportID returns the ID a debt of this expense is imported as
+func (e *SplitwiseExpense) ImportID(d Debt) string {
+	return fmt.Sprintf("splitwise:%s:%d:%s:%s", e.key(), e.Occurrence, d.Creditor, d.Debtor)
+}
+
+// Debts returns who owes who because of this expense. Expenses that more than
+// one person paid for are split between the payers in name order.
+func (e *SplitwiseExpense) Debts() []Debt {
+	var creditors, debtors []string
+	remaining := make(map[string]int64, len(e.Balances))
+	for name, amount := range e.Balances {
+		remaining[name] = amount
+		if amount > 0 {
+			creditors = append(creditors, name)
+		} else if amount < 0 {
+			debtors = append(debtors, name)
+		}
+	}
+	sort.Strings(creditors)
+	sort.Strings(debtors)
+
+	debts := []Debt{}
+	for _, debtor := range debtors {
+		for _, creditor := range creditors {
+			owed := -remaining[debtor]
+			if owed == 0 {
+				break
+			}
+
+			amount := remaining[creditor]
+			if amount == 0 {
+				continue
+			}
+			if owed < amount {
+				amount = owed
+			}
+
+			remaining[creditor] -= amount
+			remaining[debtor] += amount
+			debts = append(debts, Debt{Creditor: creditor, Debtor: debtor, Amount: amount})
+		}
+	}
+
+	return debts
+}
+
+// ParseSplitwise parses a Splitwise CSV export
+func ParseSplitwise(r io.Reader) ([]*SplitwiseExpense, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %v", err)
+	}
+
+	if len(header) <= len(splitwiseColumns) {
+		return nil, fmt.Errorf("expected at least %d columns, got %d", len(splitwiseColumns)+1, len(header))
+	}
+	for i, col := range splitwiseColumns {
+		if strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff")) != col {
+			return nil, fmt.Errorf("expected column %d to be '%s', got '%s'", i+1, col, header[i])
+		}
+	}
+	people := header[len(splitwiseColumns):]
+
+	seen := make(map[string]int)
+	expenses := []*SplitwiseExpense{}
+	for row := 2; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		// the last row is the total balance of each person, which has no date
+		if len(record) == 0 || strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+
+		if len(record) != len(header) {
+			return nil, fmt.Errorf("row %d: expected %d columns, got %d", row, len(header), len(record))
+		}
+
+		e := &SplitwiseExpense{
+			Row:         row,
+			Description: record[1],
+			Category:    record[2],
+			Currency:    record[4],
+			Balances:    make(map[string]int64),
+		}
+
+		e.Date, err = time.Parse("2006-01-02", record[0])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid date '%s'", row, record[0])
+		}
+
+		e.Cost, err = strconv.ParseFloat(record[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid cost '%s'", row, record[3])
+		}
+
+		var total int64
+		for i, name := range people {
+			v := strings.TrimSpace(record[len(splitwiseColumns)+i])
+			if v == "" {
+				continue
+			}
+
+			amount, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: invalid balance '%s' for %s", row, v, name)
+			}
+
+			cents := int64(math.Round(amount * 100))
+			if cents != 0 {
+				e.Balances[name] = cents
+				total += cents
+			}
+		}
+
+		// Splitwise rounds each balance, so they can be off by a cent or two
+		if total > int64(len(people)) || total < -int64(len(people)) {
+			return nil, fmt.Errorf("row %d: balances don't add up to 0", row)
+		}
+
+		key := e.key()
+		e.Occurrence = seen[key]
+		seen[key]++
+
+		expenses = append(expenses, e)
+	}
+
+	return expenses, nil
+}
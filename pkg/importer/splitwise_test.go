@@ -0,0 +1,156 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const splitwiseHeader = "\ufeffDate,Description,Category,Cost,Currency,Alice,Bob,Carol\n"
+
+func TestParseSplitwise(t *testing.T) {
+	export := splitwiseHeader +
+		"2021-01-02,Pizza,Dining out,10.00,USD,6.67,-3.33,-3.33\n" +
+		"2021-01-03,Payment,Payment,3.33,USD,-3.33,3.33,\n" +
+		"\n" +
+		",Total balance,,,USD,3.34,0.00,-3.33\n"
+
+	expenses, err := ParseSplitwise(strings.NewReader(export))
+	if err != nil {
+		t.Fatalf("failed to parse export: %v", err)
+	}
+
+	if len(expenses) != 2 {
+		t.Fatalf("expected the total balance not to be an expense, got %d expenses", len(expenses))
+	}
+
+	pizza := expenses[0]
+	if pizza.Row != 2 || pizza.Description != "Pizza" || pizza.Cost != 10 || pizza.Currency != "USD" {
+		t.Errorf("unexpected expense %+v", pizza)
+	}
+	if !pizza.Date.Equal(time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the expense to be on 2021-01-02, got %s", pizza.Date)
+	}
+	if want := map[string]int64{"Alice": 667, "Bob": -333, "Carol": -333}; !reflect.DeepEqual(pizza.Balances, want) {
+		t.Errorf("expected balances %v, got %v", want, pizza.Balances)
+	}
+	if pizza.IsPayment() {
+		t.Errorf("expected pizza not to be a payment")
+	}
+
+	payment := expenses[1]
+	if !payment.IsPayment() {
+		t.Errorf("expected a payment")
+	}
+	if want := map[string]int64{"Alice": -333, "Bob": 333}; !reflect.DeepEqual(payment.Balances, want) {
+		t.Errorf("expected people without a balance to be left out, got %v", payment.Balances)
+	}
+}
+
+func TestParseSplitwiseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		export string
+		err    string
+	}{
+		{"empty", "", "failed to read header"},
+		{"no people", "Date,Description,Category,Cost,Currency\n", "expected at least 6 columns"},
+		{"wrong columns", "Date,Description,Cost,Category,Currency,Alice\n", "expected column 3 to be 'Category'"},
+		{"missing column", splitwiseHeader + "2021-01-02,Pizza,Dining out,10.00,USD,5.00,-5.00\n", "row 2: expected 8 columns, got 7"},
+		{"invalid date", splitwiseHeader + "01/02/2021,Pizza,Dining out,10.00,USD,5.00,-5.00,\n", "row 2: invalid date"},
+		{"invalid cost", splitwiseHeader + "2021-01-02,Pizza,Dining out,ten,USD,5.00,-5.00,\n", "row 2: invalid cost"},
+		{"invalid balance", splitwiseHeader + "2021-01-02,Pizza,Dining out,10.00,USD,5.00,-five,\n", "row 2: invalid balance '-five' for Bob"},
+		{"unbalanced", splitwiseHeader + "2021-01-02,Pizza,Dining out,10.00,USD,5.00,-4.00,\n", "row 2: balances don't add up to 0"},
+		{
+			// Splitwise's rounding is off by at most a cent per person
+			name:   "off by more than rounding",
+			export: splitwiseHeader + "2021-01-02,Pizza,Dining out,10.00,USD,6.67,-3.32,-3.31\n",
+			err:    "row 2: balances don't add up to 0",
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseSplitwise(strings.NewReader(tt.export))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: expected an error containing '%s', got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestDebts(t *testing.T) {
+	tests := []struct {
+		name     string
+		balances map[string]int64
+		want     []Debt
+	}{
+		{
+			name:     "one payer",
+			balances: map[string]int64{"Alice": 667, "Bob": -333, "Carol": -333},
+			// Alice absorbs the rounding
+			want: []Debt{{"Alice", "Bob", 333}, {"Alice", "Carol", 333}},
+		},
+		{
+			name:     "several payers",
+			balances: map[string]int64{"Alice": 400, "Bob": 200, "Carol": -300, "Dave": -300},
+			want:     []Debt{{"Alice", "Carol", 300}, {"Alice", "Dave", 100}, {"Bob", "Dave", 200}},
+		},
+		{
+			name:     "several payers, one debtor",
+			balances: map[string]int64{"Alice": 300, "Bob": 200, "Carol": -500},
+			want:     []Debt{{"Alice", "Carol", 300}, {"Bob", "Carol", 200}},
+		},
+		{
+			name:     "nobody owes anything",
+			balances: map[string]int64{},
+			want:     []Debt{},
+		},
+	}
+
+	for _, tt := range tests {
+		e := &SplitwiseExpense{Balances: tt.balances}
+		if got := e.Debts(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+// importIDs returns the import ID of every debt in an export
+func importIDs(t *testing.T, export string) []string {
+	t.Helper()
+
+	expenses, err := ParseSplitwise(strings.NewReader(export))
+	if err != nil {
+		t.Fatalf("failed to parse export: %v", err)
+	}
+
+	ids := []string{}
+	for _, e := range expenses {
+		for _, d := range e.Debts() {
+			ids = append(ids, e.ImportID(d))
+		}
+	}
+	return ids
+}
+
+func TestImportIDs(t *testing.T) {
+	coffee := "2021-01-02,Coffee,Dining out,5.00,USD,-5.00,5.00,\n"
+	tea := "2021-01-02,Tea,Dining out,5.00,USD,-5.00,5.00,\n"
+
+	// bob bought alice the same coffee twice on the same day
+	ids := importIDs(t, splitwiseHeader+coffee+coffee)
+	if len(ids) != 2 || ids[0] == ids[1] {
+		t.Fatalf("expected identical expenses to have different import IDs, got %v", ids)
+	}
+
+	if again := importIDs(t, splitwiseHeader+coffee+coffee); !reflect.DeepEqual(again, ids) {
+		t.Errorf("expected the same export to have the same import IDs, got %v and %v", ids, again)
+	}
+
+	// IDs don't depend on where an expense is in the export, so newer exports
+	// can be imported over older ones
+	moved := importIDs(t, splitwiseHeader+tea+coffee+tea+coffee)
+	if moved[1] != ids[0] || moved[3] != ids[1] {
+		t.Errorf("expected import IDs not to change when other expenses are added, got %v and %v", ids, moved)
+	}
+}
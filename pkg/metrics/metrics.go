@@ -0,0 +1,216 @@
+// Package metrics exposes Prometheus metrics about the bot
+package metrics
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+const namespace = "balance"
+
+var (
+	// MessagesReceived counts messages received, by platform and command
+	MessagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_received_total",
+		Help:      "Messages received, by platform and command.",
+	}, []string{"platform", "command"})
+
+	// HandlerDuration is how long handlers take to handle a message, by command
+	HandlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "handler_duration_seconds",
+		Help:      "Time taken to handle a message, by command.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command"})
+
+	// HandlerErrors counts handlers that returned an error, by command
+	HandlerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "handler_errors_total",
+		Help:      "Handlers that returned an error, by command.",
+	}, []string{"command"})
+
+	// ReplyFailures counts replies that failed to send, by platform
+	ReplyFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reply_failures_total",
+		Help:      "Replies that failed to send, by platform.",
+	}, []string{"platform"})
+
+	// QueryDuration is how long database queries take, by operation and status
+	QueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Time taken by database queries, by operation and status.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "status"})
+
+	// CacheRequests counts cache lookups, by cache and whether they hit or missed
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Cache lookups, by cache and result (hit or miss).",
+	}, []string{"cache", "result"})
+
+	// UpdatesSkipped counts updates from a provider that couldn't be processed,
+	// by platform and reason
+	UpdatesSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_updates_skipped_total",
+		Help:      "Updates that couldn't be processed, by platform and reason (malformed or panic).",
+	}, []string{"platform", "reason"})
+
+	// ProviderErrors counts failed attempts to receive messages, by platform
+	ProviderErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_errors_total",
+		Help:      "Failed attempts to receive messages, by platform.",
+	}, []string{"platform"})
+
+	// ProviderStreaming is 1 while a provider's message stream is running, by platform
+	ProviderStreaming = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "provider_streaming",
+		Help:      "1 while a provider's message stream is running, by platform.",
+	}, []string{"platform"})
+
+	// QueueDepth is how many messages are waiting to be handled, by worker
+	QueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dispatcher_queue_depth",
+		Help:      "Messages waiting to be handled, by worker.",
+	}, []string{"worker"})
+
+	// QueueFull counts messages that had to wait for room in a full queue, by worker
+	QueueFull = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dispatcher_queue_full_total",
+		Help:      "Messages that had to wait for room in a full queue, by worker.",
+	}, []string{"worker"})
+
+	// QueueWait is how long messages wait in a queue before they're handled
+	QueueWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dispatcher_queue_wait_seconds",
+		Help:      "Time messages wait in a queue before they're handled.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+// CacheLookup records a lookup in a cache
+func CacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	CacheRequests.WithLabelValues(cache, result).Inc()
+}
+
+// operations are the query operations that are labelled, anything else is
+// labelled as other to keep cardinality down
+var operations = map[string]bool{
+	"SELECT": true, "INSERT": true, "UPDATE": true, "DELETE": true,
+	"BEGIN": true, "COMMIT": true, "ROLLBACK": true,
+	"CREATE": true, "ALTER": true, "WITH": true, "SET": true,
+}
+
+// QueryHook is a go-pg query hook that records the duration of every query
+type QueryHook struct{}
+
+// BeforeQuery implements pg.QueryHook
+func (QueryHook) BeforeQuery(ctx context.Context, ev *pg.QueryEvent) (context.Context, error) {
+	return ctx, nil
+}
+
+// AfterQuery implements pg.QueryHook
+func (QueryHook) AfterQuery(ctx context.Context, ev *pg.QueryEvent) error {
+	operation := "OTHER"
+	if q, err := ev.UnformattedQuery(); err == nil {
+		if fields := strings.Fields(q); len(fields) != 0 && operations[strings.ToUpper(fields[0])] {
+			operation = strings.ToUpper(fields[0])
+		}
+	}
+
+	status := "ok"
+	if ev.Err != nil && ev.Err != pg.ErrNoRows {
+		status = "error"
+	}
+
+	QueryDuration.WithLabelValues(operation, status).Observe(time.Since(ev.StartTime).Seconds())
+	return nil
+}
+
+// collectTimeout is how long stats can take to collect on each scrape
+const collectTimeout = 10 * time.Second
+
+// Stats is a source of metrics about the data the bot holds
+type Stats interface {
+	// OutstandingBalance returns the total of every account's balance
+	OutstandingBalance(ctx context.Context) (float64, error)
+
+	// CountActiveUsers returns how many users have been involved in a
+	// transaction since a time
+	CountActiveUsers(ctx context.Context, since time.Time) (int, error)
+}
+
+// ActiveWindow is how recently a user has to have been involved in a
+// transaction to count as active
+const ActiveWindow = 30 * 24 * time.Hour
+
+// statsCollector collects metrics from Stats whenever they're scraped
+type statsCollector struct {
+	s Stats
+
+	outstanding *prometheus.Desc
+	active      *prometheus.Desc
+}
+
+// RegisterStats registers metrics that are read from s when they're scraped
+func RegisterStats(s Stats) error {
+	return prometheus.Register(&statsCollector{
+		s: s,
+		outstanding: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "outstanding_balance"),
+			"Total amount owed across every account.",
+			nil, nil,
+		),
+		active: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "active_users"),
+			"Users that have been involved in a transaction in the last 30 days.",
+			nil, nil,
+		),
+	})
+}
+
+// Describe implements prometheus.Collector
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.outstanding
+	ch <- c.active
+}
+
+// Collect implements prometheus.Collector
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	if total, err := c.s.OutstandingBalance(ctx); err != nil {
+		log.Warnf("failed to collect outstanding balance: %v", err)
+		ch <- prometheus.NewInvalidMetric(c.outstanding, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.outstanding, prometheus.GaugeValue, total)
+	}
+
+	if n, err := c.s.CountActiveUsers(ctx, time.Now().Add(-ActiveWindow)); err != nil {
+		log.Warnf("failed to collect active users: %v", err)
+		ch <- prometheus.NewInvalidMetric(c.active, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, float64(n))
+	}
+}
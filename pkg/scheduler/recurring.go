@@ -0,0 +1,66 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/social"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// runRecurring creates a transaction for every occurrence of a recurring transaction
+// that is due. Occurrences that were missed, e.g. while we were down, are caught
+// up on, and occurrences that were already created are skipped, so it's always
+// safe to run this again.
+func (s *Scheduler) runRecurring(ctx context.Context, now time.Time) error {
+	rs, err := s.a.GetDueRecurring(ctx, now)
+	if err != nil {
+		return errors.Wrap(err, "failed to list due recurring transactions")
+	}
+
+	for _, r := range rs {
+		for !r.NextRunAt.After(now) {
+			occurrence := r.NextRunAt
+
+			reply, err := s.h.RunRecurring(ctx, r, occurrence)
+			if err == account.ErrTransactionExists {
+				log.Infof("recurring transaction %s already ran for %s, skipping", r.Id, occurrence)
+			} else if err != nil {
+				// try again on the next run, rather than skipping the occurrence
+				log.Errorf("failed to run recurring transaction %s: %v", r.Id, err)
+				break
+			} else {
+				s.post(r, reply)
+			}
+
+			if err := s.a.AdvanceRecurring(ctx, r, occurrence); err != nil {
+				log.Errorf("failed to advance recurring transaction %s: %v", r.Id, err)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// post posts the result of a recurring transaction to the chat it was created in
+func (s *Scheduler) post(r *account.RecurringTransaction, reply *social.Reply) {
+	if s.s == nil || reply.Empty() {
+		return
+	}
+
+	desc := r.Description
+	if desc == "" {
+		desc = r.ShortID()
+	}
+
+	err := s.s.Send(&social.Message{
+		ChatID:       r.ChatID,
+		PlatformName: r.PlatformName,
+	}, social.NewReply(social.Textf("Recurring transaction \"%s\" ran:", desc)).Append(reply))
+	if err != nil {
+		log.Warnf("failed to post recurring transaction %s: %v", r.Id, err)
+	}
+}
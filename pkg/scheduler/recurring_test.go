@@ -0,0 +1,236 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/social"
+)
+
+// store is an in-process Store, advancing follows the same rules as account.Client
+type store struct {
+	mu        sync.Mutex
+	recurring map[uuid.UUID]*account.RecurringTransaction
+}
+
+func newStore(rs ...*account.RecurringTransaction) *store {
+	s := &store{recurring: make(map[uuid.UUID]*account.RecurringTransaction)}
+	for _, r := range rs {
+		s.recurring[r.Id] = r
+	}
+	return s
+}
+
+func (s *store) GetDueRecurring(ctx context.Context, t time.Time) ([]*account.RecurringTransaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs := []*account.RecurringTransaction{}
+	for _, r := range s.recurring {
+		if !r.Paused && !r.NextRunAt.After(t) {
+			// like rows from the database, changing them doesn't change the store
+			c := *r
+			rs = append(rs, &c)
+		}
+	}
+	return rs, nil
+}
+
+func (s *store) AdvanceRecurring(ctx context.Context, r *account.RecurringTransaction, ran time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := r.Next(ran)
+	if stored := s.recurring[r.Id]; stored.NextRunAt.Equal(r.NextRunAt) {
+		stored.LastRunAt = ran
+		stored.NextRunAt = next
+	}
+
+	r.LastRunAt = ran
+	r.NextRunAt = next
+	return nil
+}
+
+// nextRunAt returns when a recurring transaction is next due
+func (s *store) nextRunAt(id uuid.UUID) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recurring[id].NextRunAt
+}
+
+// runner creates transactions for occurrences, refusing to create one twice
+// like the database does
+type runner struct {
+	created map[time.Time]bool
+	calls   int
+
+	// fail makes creating the transaction of this occurrence fail
+	fail time.Time
+}
+
+func (r *runner) RunRecurring(ctx context.Context, rt *account.RecurringTransaction, occurrence time.Time) (*social.Reply, error) {
+	r.calls++
+	if occurrence.Equal(r.fail) {
+		return nil, errors.New("database is down")
+	}
+	if r.created[occurrence] {
+		return nil, account.ErrTransactionExists
+	}
+
+	r.created[occurrence] = true
+	return social.TextReply("created"), nil
+}
+
+// sender counts the messages it sends
+type sender struct {
+	sent int
+}
+
+func (s *sender) Send(to *social.Message, r *social.Reply) error {
+	s.sent++
+	return nil
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// newTestScheduler creates a scheduler for a recurring transaction that runs
+// monthly on the 31st, and is next due on 2021-01-31
+func newTestScheduler() (*Scheduler, *store, *runner, *sender, *account.RecurringTransaction) {
+	r := &account.RecurringTransaction{
+		Id:        uuid.Must(uuid.NewV4()),
+		Frequency: account.FrequencyMonthly,
+		Day:       31,
+		NextRunAt: date(2021, 1, 31),
+	}
+	st := newStore(r)
+	run := &runner{created: make(map[time.Time]bool)}
+	snd := &sender{}
+
+	return &Scheduler{a: st, h: run, s: snd}, st, run, snd, r
+}
+
+// expectCreated checks that exactly the occurrences in want were created
+func expectCreated(t *testing.T, run *runner, want ...time.Time) {
+	t.Helper()
+
+	if len(run.created) != len(want) {
+		t.Errorf("expected %d occurrences to be created, got %v", len(want), run.created)
+	}
+	for _, w := range want {
+		if !run.created[w] {
+			t.Errorf("expected the occurrence on %s to be created, got %v", w, run.created)
+		}
+	}
+}
+
+func TestRunRecurringCatchesUp(t *testing.T) {
+	s, st, run, snd, r := newTestScheduler()
+	ctx := context.Background()
+
+	// nothing is due yet
+	if err := s.runRecurring(ctx, date(2021, 1, 30)); err != nil {
+		t.Fatalf("failed to run recurring transactions: %v", err)
+	}
+	expectCreated(t, run)
+
+	// we were down for a couple of months, every missed occurrence is created once
+	now := date(2021, 4, 10)
+	if err := s.runRecurring(ctx, now); err != nil {
+		t.Fatalf("failed to run recurring transactions: %v", err)
+	}
+	expectCreated(t, run, date(2021, 1, 31), date(2021, 2, 28), date(2021, 3, 31))
+	if snd.sent != 3 {
+		t.Errorf("expected every occurrence to be posted, %d were", snd.sent)
+	}
+	if next := st.nextRunAt(r.Id); !next.Equal(date(2021, 4, 30)) {
+		t.Errorf("expected the next run to be 2021-04-30, got %s", next)
+	}
+
+	// running again, e.g. after a restart, doesn't run anything twice
+	calls := run.calls
+	if err := s.runRecurring(ctx, now); err != nil {
+		t.Fatalf("failed to run recurring transactions: %v", err)
+	}
+	if run.calls != calls || snd.sent != 3 {
+		t.Errorf("expected nothing to run again, got %d more runs and %d more posts", run.calls-calls, snd.sent-3)
+	}
+}
+
+func TestRunRecurringSkipsCreatedOccurrences(t *testing.T) {
+	s, st, run, snd, r := newTestScheduler()
+
+	// we stopped after creating the transaction, but before advancing
+	run.created[date(2021, 1, 31)] = true
+
+	if err := s.runRecurring(context.Background(), date(2021, 2, 1)); err != nil {
+		t.Fatalf("failed to run recurring transactions: %v", err)
+	}
+	expectCreated(t, run, date(2021, 1, 31))
+	if snd.sent != 0 {
+		t.Errorf("expected an occurrence that already ran not to be posted again, %d were", snd.sent)
+	}
+	if next := st.nextRunAt(r.Id); !next.Equal(date(2021, 2, 28)) {
+		t.Errorf("expected the next run to be 2021-02-28, got %s", next)
+	}
+}
+
+func TestRunRecurringRetriesFailures(t *testing.T) {
+	s, st, run, _, r := newTestScheduler()
+	ctx := context.Background()
+	now := date(2021, 3, 1)
+
+	run.fail = date(2021, 2, 28)
+	if err := s.runRecurring(ctx, now); err != nil {
+		t.Fatalf("failed to run recurring transactions: %v", err)
+	}
+	expectCreated(t, run, date(2021, 1, 31))
+
+	// the failed occurrence isn't skipped
+	if next := st.nextRunAt(r.Id); !next.Equal(date(2021, 2, 28)) {
+		t.Errorf("expected the failed occurrence to still be due, next run is %s", next)
+	}
+
+	run.fail = time.Time{}
+	if err := s.runRecurring(ctx, now); err != nil {
+		t.Fatalf("failed to run recurring transactions: %v", err)
+	}
+	expectCreated(t, run, date(2021, 1, 31), date(2021, 2, 28))
+}
+
+func TestRunRecurringSkipsPaused(t *testing.T) {
+	s, _, run, _, r := newTestScheduler()
+	r.Paused = true
+
+	if err := s.runRecurring(context.Background(), date(2021, 2, 1)); err != nil {
+		t.Fatalf("failed to run recurring transactions: %v", err)
+	}
+	expectCreated(t, run)
+}
+
+func TestJobPanic(t *testing.T) {
+	ran := false
+	s := &Scheduler{jobs: []*job{
+		{name: "panics", interval: time.Minute, fn: func(ctx context.Context, now time.Time) error {
+			var names []string
+			_ = names[0]
+			return nil
+		}},
+		{name: "runs", interval: time.Minute, fn: func(ctx context.Context, now time.Time) error {
+			ran = true
+			return nil
+		}},
+	}}
+
+	// a panicking job doesn't stop the others
+	s.runJobs(context.Background())
+	if !ran {
+		t.Errorf("expected the job after a panicking job to run")
+	}
+}
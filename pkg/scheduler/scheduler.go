@@ -0,0 +1,109 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/handlers"
+	"github.com/jaredallard/balance/pkg/social"
+	log "github.com/sirupsen/logrus"
+)
+
+// job is a function that is run every interval
+type job struct {
+	name     string
+	interval time.Duration
+	lastRun  time.Time
+	fn       func(ctx context.Context, now time.Time) error
+}
+
+// Store is the part of the account client recurring transactions are run
+// from
+type Store interface {
+	GetDueRecurring(ctx context.Context, t time.Time) ([]*account.RecurringTransaction, error)
+	AdvanceRecurring(ctx context.Context, r *account.RecurringTransaction, ran time.Time) error
+}
+
+// Runner creates the transactions of recurring transactions,
+// *handlers.Handlers implements it
+type Runner interface {
+	RunRecurring(ctx context.Context, r *account.RecurringTransaction, occurrence time.Time) (*social.Reply, error)
+}
+
+// Scheduler runs jobs that happen on a schedule, rather than in response
+// to a message
+type Scheduler struct {
+	a Store
+	h Runner
+	s social.Sender
+
+	// interval is how often jobs are checked for work
+	interval time.Duration
+
+	jobs []*job
+}
+
+// NewScheduler creates a new scheduler
+func NewScheduler(a *account.Client, h *handlers.Handlers, s social.Sender) *Scheduler {
+	sched := &Scheduler{
+		a:        a,
+		h:        h,
+		s:        s,
+		interval: 1 * time.Minute,
+	}
+
+	sched.jobs = []*job{
+		{name: "recurring", interval: 1 * time.Minute, fn: sched.runRecurring},
+		{name: "reminders", interval: 1 * time.Hour, fn: h.RunReminders},
+		{name: "statements", interval: 1 * time.Hour, fn: h.RunStatements},
+	}
+
+	return sched
+}
+
+// Run runs all jobs until the context is canceled
+func (s *Scheduler) Run(ctx context.Context) {
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+
+	for {
+		s.runJobs(ctx)
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			log.Warnf("scheduler shutdown")
+			return
+		}
+	}
+}
+
+// runJobs runs every job that is due
+func (s *Scheduler) runJobs(ctx context.Context) {
+	now := time.Now()
+	for _, j := range s.jobs {
+		if now.Sub(j.lastRun) < j.interval {
+			continue
+		}
+
+		j.lastRun = now
+		if err := j.safeRun(ctx, now); err != nil {
+			log.Errorf("failed to run job %s: %v", j.name, err)
+		}
+	}
+}
+
+// safeRun runs a job, recovering from any panic so that one bad job can't
+// stop the scheduler, or take down everything else with it
+func (j *job) safeRun(ctx context.Context, now time.Time) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic running job: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	return j.fn(ctx, now)
+}
@@ -0,0 +1,38 @@
+package social
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff is an exponential backoff with jitter, used by providers to wait
+// between attempts to reconnect
+type Backoff struct {
+	// Min is how long to wait after the first failure
+	Min time.Duration
+
+	// Max is the longest we'll wait between attempts
+	Max time.Duration
+
+	attempt int
+}
+
+// Next returns how long to wait before the next attempt. The wait doubles
+// every attempt, and is randomized between half and all of it, so that
+// clients that failed at the same time don't retry at the same time.
+func (b *Backoff) Next() time.Duration {
+	d := b.Max
+	if b.attempt < 32 {
+		if e := b.Min << uint(b.attempt); e > 0 && e < b.Max {
+			d = e
+		}
+	}
+	b.attempt++
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Reset resets the backoff after a successful attempt
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
@@ -0,0 +1,46 @@
+package social
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := &Backoff{Min: time.Second, Max: time.Minute}
+
+	// the wait doubles until it reaches Max, and stays there
+	want := []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		16 * time.Second, 32 * time.Second, time.Minute, time.Minute,
+	}
+	for i, d := range want {
+		if got := b.Next(); got < d/2 || got > d {
+			t.Errorf("attempt %d: expected a wait between %v and %v, got %v", i+1, d/2, d, got)
+		}
+	}
+
+	// it doesn't overflow after failing for a long time
+	for i := 0; i < 100; i++ {
+		if got := b.Next(); got < time.Minute/2 || got > time.Minute {
+			t.Fatalf("attempt %d: expected a wait between %v and %v, got %v", len(want)+i+1, time.Minute/2, time.Minute, got)
+		}
+	}
+
+	b.Reset()
+	if got := b.Next(); got < time.Second/2 || got > time.Second {
+		t.Errorf("expected a wait between %v and %v after a reset, got %v", time.Second/2, time.Second, got)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	// clients that failed at the same time don't all wait as long
+	waits := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		b := &Backoff{Min: time.Second, Max: time.Minute}
+		waits[b.Next()] = true
+	}
+
+	if len(waits) == 1 {
+		t.Errorf("expected waits to be randomized, got %v every time", waits)
+	}
+}
@@ -0,0 +1,151 @@
+package social
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jaredallard/balance/pkg/account"
+)
+
+// Multiplexer merges the message streams of multiple providers into one, and
+// sends messages with the provider for their platform
+type Multiplexer struct {
+	providers []Provider
+	byName    map[account.PlatformName]Provider
+}
+
+// NewMultiplexer creates a multiplexer of providers, there can only be one
+// provider for each platform
+func NewMultiplexer(providers ...Provider) (*Multiplexer, error) {
+	m := &Multiplexer{
+		providers: providers,
+		byName:    make(map[account.PlatformName]Provider),
+	}
+
+	for _, p := range providers {
+		if _, dup := m.byName[p.Name()]; dup {
+			return nil, fmt.Errorf("multiple providers for platform '%s'", p.Name())
+		}
+		m.byName[p.Name()] = p
+	}
+
+	return m, nil
+}
+
+// Providers returns every provider in the multiplexer
+func (m *Multiplexer) Providers() []Provider {
+	return m.providers
+}
+
+// provider returns the provider for a platform
+func (m *Multiplexer) provider(name account.PlatformName) (Provider, error) {
+	p, ok := m.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("no provider for platform '%s'", name)
+	}
+	return p, nil
+}
+
+// Capabilities returns the features the provider for a platform supports
+func (m *Multiplexer) Capabilities(name account.PlatformName) Capabilities {
+	p, err := m.provider(name)
+	if err != nil {
+		return Capabilities{}
+	}
+	return p.Capabilities()
+}
+
+// CreateStream returns a stream of every provider's messages. Once ctx is
+// done, the stream is closed after every provider's stream is. If a provider's
+// stream can't be created, the streams that were are stopped.
+func (m *Multiplexer) CreateStream(ctx context.Context) (<-chan Message, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	streams := make([]<-chan Message, 0, len(m.providers))
+	for _, p := range m.providers {
+		s, err := p.CreateStream(ctx)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to create %s stream: %v", p.Name(), err)
+		}
+		streams = append(streams, s)
+	}
+
+	stream := make(chan Message)
+	var wg sync.WaitGroup
+	for _, s := range streams {
+		wg.Add(1)
+		go func(s <-chan Message) {
+			defer wg.Done()
+			for msg := range s {
+				stream <- msg
+			}
+		}(s)
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+		close(stream)
+	}()
+
+	return stream, nil
+}
+
+// adapt adapts a reply to what a provider supports, buttons are listed as
+// text, and attachments return ErrUnsupported if it can't send files
+func adapt(p Provider, r *Reply) (*Reply, error) {
+	c := p.Capabilities()
+	if len(r.Attachments) != 0 && !c.Files {
+		return nil, ErrUnsupported
+	}
+
+	if len(r.Buttons) != 0 && !c.Buttons {
+		r = r.ButtonsAsText()
+	}
+	return r, nil
+}
+
+// Send sends a reply with the provider for to.PlatformName
+func (m *Multiplexer) Send(to *Message, r *Reply) error {
+	p, err := m.provider(to.PlatformName)
+	if err != nil {
+		return err
+	}
+
+	r, err = adapt(p, r)
+	if err != nil {
+		return err
+	}
+	return p.Send(to, r)
+}
+
+// Reply replies to a message with the provider it came from
+func (m *Multiplexer) Reply(to *Message, r *Reply) error {
+	p, err := m.provider(to.PlatformName)
+	if err != nil {
+		return err
+	}
+
+	r, err = adapt(p, r)
+	if err != nil {
+		return err
+	}
+	return p.Reply(to, r)
+}
+
+// Close closes every provider
+func (m *Multiplexer) Close() error {
+	var errs []error
+	for _, p := range m.providers {
+		if err := p.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close %s: %v", p.Name(), err))
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
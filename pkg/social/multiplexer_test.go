@@ -0,0 +1,222 @@
+package social
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaredallard/balance/pkg/account"
+)
+
+// provider sends its messages once its stream is created, and then closes its
+// stream once ctx is done
+type provider struct {
+	name account.PlatformName
+	caps Capabilities
+	msgs []Message
+
+	// streamErr fails creating its stream, closeErr fails closing it
+	streamErr, closeErr error
+
+	// stopped is closed once its stream is
+	stopped chan struct{}
+
+	mu      sync.Mutex
+	sent    []*Reply
+	replies []*Reply
+}
+
+func newProvider(name account.PlatformName, msgs ...string) *provider {
+	p := &provider{name: name, stopped: make(chan struct{})}
+	for _, text := range msgs {
+		p.msgs = append(p.msgs, Message{PlatformName: name, Text: text})
+	}
+	return p
+}
+
+func (p *provider) Name() account.PlatformName { return p.name }
+func (p *provider) Capabilities() Capabilities { return p.caps }
+func (p *provider) Close() error               { return p.closeErr }
+
+func (p *provider) CreateStream(ctx context.Context) (<-chan Message, error) {
+	if p.streamErr != nil {
+		return nil, p.streamErr
+	}
+
+	stream := make(chan Message)
+	go func() {
+		defer close(p.stopped)
+		defer close(stream)
+		for _, msg := range p.msgs {
+			select {
+			case stream <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+		<-ctx.Done()
+	}()
+	return stream, nil
+}
+
+func (p *provider) Send(to *Message, r *Reply) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent = append(p.sent, r)
+	return nil
+}
+
+func (p *provider) Reply(to *Message, r *Reply) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.replies = append(p.replies, r)
+	return nil
+}
+
+// stopped waits for the stream of p to be closed
+func stopped(t *testing.T, p *provider) {
+	t.Helper()
+
+	select {
+	case <-p.stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the %s stream wasn't stopped", p.name)
+	}
+}
+
+func TestNewMultiplexer(t *testing.T) {
+	if _, err := NewMultiplexer(newProvider("telegram"), newProvider("discord"), newProvider("telegram")); err == nil {
+		t.Errorf("expected multiple providers for a platform to fail")
+	}
+
+	m, err := NewMultiplexer(newProvider("telegram"), newProvider("discord"))
+	if err != nil {
+		t.Fatalf("failed to create multiplexer: %v", err)
+	}
+	if got := len(m.Providers()); got != 2 {
+		t.Errorf("expected 2 providers, got %d", got)
+	}
+}
+
+func TestMultiplexerCreateStream(t *testing.T) {
+	telegram, discord := newProvider("telegram", "a", "b"), newProvider("discord", "c")
+	m, err := NewMultiplexer(telegram, discord)
+	if err != nil {
+		t.Fatalf("failed to create multiplexer: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := m.CreateStream(ctx)
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+
+	got := []string{}
+	for len(got) < 3 {
+		select {
+		case msg := <-stream:
+			got = append(got, string(msg.PlatformName)+":"+msg.Text)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only received %v", got)
+		}
+	}
+	sort.Strings(got)
+	if strings.Join(got, ",") != "discord:c,telegram:a,telegram:b" {
+		t.Errorf("expected every provider's messages, got %v", got)
+	}
+
+	// the stream is closed once every provider's is
+	cancel()
+	select {
+	case _, ok := <-stream:
+		if ok {
+			t.Errorf("expected no more messages")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stream wasn't closed")
+	}
+	stopped(t, telegram)
+	stopped(t, discord)
+}
+
+func TestMultiplexerCreateStreamFailure(t *testing.T) {
+	telegram, discord := newProvider("telegram"), newProvider("discord")
+	discord.streamErr = errors.New("invalid token")
+	m, err := NewMultiplexer(telegram, discord)
+	if err != nil {
+		t.Fatalf("failed to create multiplexer: %v", err)
+	}
+
+	// ctx is never done, the telegram stream is stopped because discord failed
+	if _, err := m.CreateStream(context.Background()); err == nil || !strings.Contains(err.Error(), "invalid token") {
+		t.Fatalf("expected creating the discord stream to fail, got %v", err)
+	}
+	stopped(t, telegram)
+}
+
+func TestMultiplexerSend(t *testing.T) {
+	telegram, discord := newProvider("telegram"), newProvider("discord")
+	telegram.caps = Capabilities{Buttons: true, Files: true}
+	m, err := NewMultiplexer(telegram, discord)
+	if err != nil {
+		t.Fatalf("failed to create multiplexer: %v", err)
+	}
+
+	r := TextReply("Pay bob?").AddButton("Accept", "/accept 1234abcd")
+	if err := m.Send(&Message{PlatformName: "telegram"}, r); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	if err := m.Reply(&Message{PlatformName: "discord"}, r); err != nil {
+		t.Fatalf("failed to reply: %v", err)
+	}
+
+	if len(telegram.sent) != 1 || len(telegram.sent[0].Buttons) != 1 || len(telegram.replies) != 0 {
+		t.Errorf("expected the reply to be sent as is with telegram, got %v and %v", telegram.sent, telegram.replies)
+	}
+	if len(discord.replies) != 1 || len(discord.sent) != 0 {
+		t.Fatalf("expected a reply with discord, got %v and %v", discord.sent, discord.replies)
+	}
+	if got := discord.replies[0]; len(got.Buttons) != 0 || !strings.Contains(got.String(), "Accept: /accept 1234abcd") {
+		t.Errorf("expected buttons to be listed as text for discord, got '%s'", got)
+	}
+
+	withFile := TextReply("Export").Attach(&Document{Name: "export.csv"})
+	if err := m.Send(&Message{PlatformName: "discord"}, withFile); err != ErrUnsupported {
+		t.Errorf("expected sending a file with discord to be unsupported, got %v", err)
+	}
+	if err := m.Send(&Message{PlatformName: "slack"}, r); err == nil {
+		t.Errorf("expected sending to a platform without a provider to fail")
+	}
+	if err := m.Reply(&Message{PlatformName: "slack"}, r); err == nil {
+		t.Errorf("expected replying on a platform without a provider to fail")
+	}
+
+	if got := m.Capabilities("telegram"); got != telegram.caps {
+		t.Errorf("expected telegram's capabilities, got %+v", got)
+	}
+	if got := m.Capabilities("slack"); got != (Capabilities{}) {
+		t.Errorf("expected a platform without a provider to support nothing, got %+v", got)
+	}
+}
+
+func TestMultiplexerClose(t *testing.T) {
+	telegram, discord := newProvider("telegram"), newProvider("discord")
+	m, err := NewMultiplexer(telegram, discord)
+	if err != nil {
+		t.Fatalf("failed to create multiplexer: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("failed to close: %v", err)
+	}
+
+	discord.closeErr = errors.New("already closed")
+	if err := m.Close(); err == nil || !strings.Contains(err.Error(), "discord") {
+		t.Errorf("expected closing discord to fail, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package social
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/jaredallard/balance/pkg/account"
+)
+
+// Factory creates a provider
+type Factory func(a *account.Client) (Provider, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[account.PlatformName]Factory)
+)
+
+// Register makes a provider available by name to Open. Providers register
+// themselves when they're imported, it panics if a provider is registered
+// twice.
+func Register(name account.PlatformName, f Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if f == nil {
+		panic("social: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("social: Register called twice for provider " + string(name))
+	}
+	factories[name] = f
+}
+
+// Registered returns the names of every registered provider, sorted
+func Registered() []account.PlatformName {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]account.PlatformName, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// Open creates a registered provider
+func Open(name account.PlatformName, a *account.Client) (Provider, error) {
+	factoriesMu.RLock()
+	f, ok := factories[name]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown provider '%s', forgotten import?", name)
+	}
+	return f(a)
+}
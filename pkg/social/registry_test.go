@@ -0,0 +1,62 @@
+package social
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jaredallard/balance/pkg/account"
+)
+
+// expectPanic checks that fn panics
+func expectPanic(t *testing.T, fn func(), msg string) {
+	t.Helper()
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf(msg)
+		}
+	}()
+	fn()
+}
+
+func TestRegistry(t *testing.T) {
+	// the registry is global, so these names can't be used by real providers
+	irc, matrix := account.PlatformName("test-irc"), account.PlatformName("test-matrix")
+	t.Cleanup(func() {
+		factoriesMu.Lock()
+		defer factoriesMu.Unlock()
+		delete(factories, irc)
+		delete(factories, matrix)
+	})
+
+	p := newProvider(irc)
+	Register(matrix, func(a *account.Client) (Provider, error) { return newProvider(matrix), nil })
+	Register(irc, func(a *account.Client) (Provider, error) { return p, nil })
+
+	names := Registered()
+	if !sort.SliceIsSorted(names, func(i, j int) bool { return names[i] < names[j] }) {
+		t.Errorf("expected providers to be sorted, got %v", names)
+	}
+	if got := fmt.Sprint(names); !strings.Contains(got, "test-irc test-matrix") {
+		t.Errorf("expected every provider to be registered, got %v", got)
+	}
+
+	got, err := Open(irc, nil)
+	if err != nil {
+		t.Fatalf("failed to open provider: %v", err)
+	}
+	if got != p {
+		t.Errorf("expected the provider the factory created, got %v", got)
+	}
+
+	if _, err := Open("test-unknown", nil); err == nil {
+		t.Errorf("expected opening a provider that isn't registered to fail")
+	}
+
+	expectPanic(t, func() {
+		Register(irc, func(a *account.Client) (Provider, error) { return nil, nil })
+	}, "expected registering a provider twice to panic")
+	expectPanic(t, func() { Register("test-nil", nil) }, "expected registering a nil factory to panic")
+}
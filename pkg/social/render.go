@@ -0,0 +1,238 @@
+package social
+
+import (
+	"html"
+	"strings"
+	"unicode/utf8"
+)
+
+// Format is a markup format replies can be rendered in
+type Format string
+
+const (
+	// FormatPlain is plain text, without any formatting
+	FormatPlain Format = "plain"
+
+	// FormatMarkdownV2 is Telegram's MarkdownV2
+	FormatMarkdownV2 Format = "markdownv2"
+
+	// FormatHTML is the subset of HTML Telegram supports
+	FormatHTML Format = "html"
+
+	// FormatDiscord is Discord's markdown
+	FormatDiscord Format = "discord"
+
+	// FormatSlack is Slack's mrkdwn
+	FormatSlack Format = "slack"
+)
+
+// markup is how a format escapes and styles text
+type markup struct {
+	// escape escapes text so it's shown as is
+	escape func(string) string
+
+	// bold and italic are put around styled text
+	bold, italic [2]string
+
+	// code and pre format a span, and a block, of monospaced text
+	code func(string) string
+	pre  func(string) string
+}
+
+// escaper returns a function that puts a backslash before any of chars
+func escaper(chars string) func(string) string {
+	return func(text string) string {
+		var b strings.Builder
+		for _, r := range text {
+			if strings.ContainsRune(chars, r) {
+				b.WriteRune('\\')
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	}
+}
+
+// slackEscape escapes the characters Slack uses for links and mentions, there's
+// no way to escape formatting characters in mrkdwn
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// discordCode wraps text in backticks, using double backticks if the text
+// has any
+func discordCode(text string) string {
+	if strings.Contains(text, "`") {
+		return "`` " + text + " ``"
+	}
+	return "`" + text + "`"
+}
+
+// markups are the markups of every format
+var markups = map[Format]markup{
+	FormatPlain: {
+		escape: func(text string) string { return text },
+		code:   func(text string) string { return text },
+		pre:    func(text string) string { return text },
+	},
+	FormatMarkdownV2: {
+		escape: escaper("\\_*[]()~`>#+-=|{}.!"),
+		bold:   [2]string{"*", "*"},
+		italic: [2]string{"_", "_"},
+		code: func(text string) string {
+			return "`" + escaper("\\`")(text) + "`"
+		},
+		pre: func(text string) string {
+			return "```\n" + escaper("\\`")(text) + "\n```"
+		},
+	},
+	FormatHTML: {
+		escape: html.EscapeString,
+		bold:   [2]string{"<b>", "</b>"},
+		italic: [2]string{"<i>", "</i>"},
+		code: func(text string) string {
+			return "<code>" + html.EscapeString(text) + "</code>"
+		},
+		pre: func(text string) string {
+			return "<pre>" + html.EscapeString(text) + "</pre>"
+		},
+	},
+	FormatDiscord: {
+		escape: escaper("\\*_~`|>[]"),
+		bold:   [2]string{"**", "**"},
+		italic: [2]string{"_", "_"},
+		code:   discordCode,
+		pre: func(text string) string {
+			return "```\n" + strings.ReplaceAll(text, "```", "`\u200b``") + "\n```"
+		},
+	},
+	FormatSlack: {
+		escape: slackEscape,
+		bold:   [2]string{"*", "*"},
+		italic: [2]string{"_", "_"},
+		code: func(text string) string {
+			return "`" + slackEscape(text) + "`"
+		},
+		pre: func(text string) string {
+			return "```" + slackEscape(text) + "```"
+		},
+	},
+}
+
+// span renders a span
+func (m *markup) span(s Span) string {
+	if s.Text == "" {
+		return ""
+	}
+
+	text := m.escape(s.Text)
+	if s.Style&StyleCode != 0 {
+		text = m.code(s.Text)
+	}
+	if s.Style&StyleItalic != 0 {
+		text = m.italic[0] + text + m.italic[1]
+	}
+	if s.Style&StyleBold != 0 {
+		text = m.bold[0] + text + m.bold[1]
+	}
+	return text
+}
+
+// spans renders a line of spans
+func (m *markup) spans(spans []Span) string {
+	var b strings.Builder
+	for _, s := range spans {
+		b.WriteString(m.span(s))
+	}
+	return b.String()
+}
+
+// table renders a table as monospaced text, with its columns lined up
+func (m *markup) table(t *Table) string {
+	rows := append([][]string{t.Header}, t.Rows...)
+	if len(t.Header) == 0 {
+		rows = t.Rows
+	}
+
+	widths := []int{}
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	lines := make([]string, 0, len(rows))
+	for _, row := range rows {
+		var b strings.Builder
+		for i, cell := range row {
+			b.WriteString(cell)
+			if i != len(row)-1 {
+				b.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)+2))
+			}
+		}
+		lines = append(lines, b.String())
+	}
+
+	return m.pre(strings.Join(lines, "\n"))
+}
+
+// Render renders the text of the reply in a format, buttons and attachments
+// aren't included
+func (r *Reply) Render(f Format) string {
+	m, ok := markups[f]
+	if !ok {
+		m = markups[FormatPlain]
+	}
+
+	parts := make([]string, 0, len(r.Blocks))
+	for _, block := range r.Blocks {
+		switch b := block.(type) {
+		case Paragraph:
+			if text := m.spans(b); text != "" {
+				parts = append(parts, text)
+			}
+		case *List:
+			lines := make([]string, 0, len(b.Items))
+			for _, item := range b.Items {
+				lines = append(lines, "• "+m.spans(item))
+			}
+			if len(lines) != 0 {
+				parts = append(parts, strings.Join(lines, "\n"))
+			}
+		case *Table:
+			if len(b.Rows) != 0 {
+				parts = append(parts, m.table(b))
+			}
+		}
+	}
+
+	return strings.Join(parts, "\n\n")
+}
+
+// String renders the reply as plain text
+func (r *Reply) String() string {
+	return r.Render(FormatPlain)
+}
+
+// ButtonsAsText returns a copy of the reply with its buttons listed as the
+// commands they run, for providers that can't show buttons
+func (r *Reply) ButtonsAsText() *Reply {
+	c := &Reply{
+		Blocks:      append([]Block{}, r.Blocks...),
+		Attachments: r.Attachments,
+	}
+
+	if len(r.Buttons) != 0 {
+		l := c.List()
+		for _, b := range r.Buttons {
+			l.Add(Text(b.Text + ": " + b.Command))
+		}
+	}
+
+	return c
+}
@@ -0,0 +1,159 @@
+package social
+
+import "testing"
+
+// special has every character one of the formats uses for markup
+const special = "a_b *c* [d](e) <f> & `g`"
+
+// link is how links are sent, as text
+const link = "https://example.com/a_b?c=(1)&d=2"
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply *Reply
+		want  map[Format]string
+	}{
+		{
+			name:  "special characters",
+			reply: NewReply(Text(special)),
+			want: map[Format]string{
+				FormatPlain:      special,
+				FormatMarkdownV2: "a\\_b \\*c\\* \\[d\\]\\(e\\) <f\\> & \\`g\\`",
+				FormatHTML:       "a_b *c* [d](e) &lt;f&gt; &amp; `g`",
+				FormatDiscord:    "a\\_b \\*c\\* \\[d\\](e) <f\\> & \\`g\\`",
+				FormatSlack:      "a_b *c* [d](e) &lt;f&gt; &amp; `g`",
+			},
+		},
+		{
+			name:  "bold",
+			reply: NewReply(Text("to "), Bold("a_b <c>")),
+			want: map[Format]string{
+				FormatPlain:      "to a_b <c>",
+				FormatMarkdownV2: "to *a\\_b <c\\>*",
+				FormatHTML:       "to <b>a_b &lt;c&gt;</b>",
+				FormatDiscord:    "to **a\\_b <c\\>**",
+				FormatSlack:      "to *a_b &lt;c&gt;*",
+			},
+		},
+		{
+			name:  "italic",
+			reply: NewReply(Italic("a*b")),
+			want: map[Format]string{
+				FormatPlain:      "a*b",
+				FormatMarkdownV2: "_a\\*b_",
+				FormatHTML:       "<i>a*b</i>",
+				FormatDiscord:    "_a\\*b_",
+				FormatSlack:      "_a*b_",
+			},
+		},
+		{
+			name:  "bold italic",
+			reply: NewReply(Span{Text: "x", Style: StyleBold | StyleItalic}),
+			want: map[Format]string{
+				FormatPlain:      "x",
+				FormatMarkdownV2: "*_x_*",
+				FormatHTML:       "<b><i>x</i></b>",
+				FormatDiscord:    "**_x_**",
+				FormatSlack:      "*_x_*",
+			},
+		},
+		{
+			name:  "code",
+			reply: NewReply(Code("x`y\\ <z> & _")),
+			want: map[Format]string{
+				FormatPlain:      "x`y\\ <z> & _",
+				FormatMarkdownV2: "`x\\`y\\\\ <z> & _`",
+				FormatHTML:       "<code>x`y\\ &lt;z&gt; &amp; _</code>",
+				FormatDiscord:    "`` x`y\\ <z> & _ ``",
+				FormatSlack:      "`x`y\\ &lt;z&gt; &amp; _`",
+			},
+		},
+		{
+			name:  "bold code",
+			reply: NewReply(Span{Text: "1234_abcd", Style: StyleBold | StyleCode}),
+			want: map[Format]string{
+				FormatPlain:      "1234_abcd",
+				FormatMarkdownV2: "*`1234_abcd`*",
+				FormatHTML:       "<b><code>1234_abcd</code></b>",
+				FormatDiscord:    "**`1234_abcd`**",
+				FormatSlack:      "*`1234_abcd`*",
+			},
+		},
+		{
+			name:  "link",
+			reply: NewReply(Text("Login: "), Text(link)),
+			want: map[Format]string{
+				FormatPlain:      "Login: " + link,
+				FormatMarkdownV2: "Login: https://example\\.com/a\\_b?c\\=\\(1\\)&d\\=2",
+				FormatHTML:       "Login: https://example.com/a_b?c=(1)&amp;d=2",
+				FormatDiscord:    "Login: https://example.com/a\\_b?c=(1)&d=2",
+				FormatSlack:      "Login: https://example.com/a_b?c=(1)&amp;d=2",
+			},
+		},
+		{
+			name: "blocks",
+			reply: func() *Reply {
+				r := NewReply(Bold("Balances"))
+				r.List().Add(Text("a_b")).Add(Text("c"))
+				r.Table("Name", "Amount").Add("alice_1", "$5.00").Add("bob", "`1`")
+				return r
+			}(),
+			want: map[Format]string{
+				FormatPlain:      "Balances\n\n• a_b\n• c\n\nName     Amount\nalice_1  $5.00\nbob      `1`",
+				FormatMarkdownV2: "*Balances*\n\n• a\\_b\n• c\n\n```\nName     Amount\nalice_1  $5.00\nbob      \\`1\\`\n```",
+				FormatHTML:       "<b>Balances</b>\n\n• a_b\n• c\n\n<pre>Name     Amount\nalice_1  $5.00\nbob      `1`</pre>",
+				FormatDiscord:    "**Balances**\n\n• a\\_b\n• c\n\n```\nName     Amount\nalice_1  $5.00\nbob      `1`\n```",
+				FormatSlack:      "*Balances*\n\n• a_b\n• c\n\n```Name     Amount\nalice_1  $5.00\nbob      `1````",
+			},
+		},
+		{
+			name:  "empty blocks",
+			reply: func() *Reply { r := NewReply(Text("")); r.List(); r.Table("Name"); return r }(),
+			want: map[Format]string{
+				FormatPlain:      "",
+				FormatMarkdownV2: "",
+				FormatHTML:       "",
+				FormatDiscord:    "",
+				FormatSlack:      "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for f, want := range tt.want {
+			if got := tt.reply.Render(f); got != want {
+				t.Errorf("%s in %s: expected %q, got %q", tt.name, f, want, got)
+			}
+		}
+	}
+}
+
+func TestRenderDiscordPre(t *testing.T) {
+	r := NewReply()
+	r.Table("Name").Add("a```b")
+
+	// the table can't close its code block early
+	if got, want := r.Render(FormatDiscord), "```\nName\na`\u200b``b\n```"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestRenderUnknownFormat(t *testing.T) {
+	r := NewReply(Bold(special))
+	if got := r.Render(Format("irc")); got != special {
+		t.Errorf("expected an unknown format to render as plain text, got %q", got)
+	}
+}
+
+func TestButtonsAsText(t *testing.T) {
+	r := NewReply(Text("Pay bob?")).AddButton("Accept", "/accept 1234abcd")
+
+	c := r.ButtonsAsText()
+	if got, want := c.Render(FormatMarkdownV2), "Pay bob?\n\n• Accept: /accept 1234abcd"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if len(r.Blocks) != 1 {
+		t.Errorf("expected the original reply not to change, got %d blocks", len(r.Blocks))
+	}
+}
@@ -0,0 +1,163 @@
+package social
+
+import "fmt"
+
+// Style is how a span of text is formatted, styles can be combined
+type Style int
+
+const (
+	// StyleBold is bold text
+	StyleBold Style = 1 << iota
+
+	// StyleItalic is italic text
+	StyleItalic
+
+	// StyleCode is monospaced text, e.g. an ID
+	StyleCode
+)
+
+// Span is a run of text in a single style
+type Span struct {
+	Text  string
+	Style Style
+}
+
+// Text returns a span of plain text
+func Text(text string) Span {
+	return Span{Text: text}
+}
+
+// Textf returns a span of plain text, formatted with fmt.Sprintf
+func Textf(format string, args ...interface{}) Span {
+	return Span{Text: fmt.Sprintf(format, args...)}
+}
+
+// Bold returns a span of bold text
+func Bold(text string) Span {
+	return Span{Text: text, Style: StyleBold}
+}
+
+// Italic returns a span of italic text
+func Italic(text string) Span {
+	return Span{Text: text, Style: StyleItalic}
+}
+
+// Code returns a span of monospaced text
+func Code(text string) Span {
+	return Span{Text: text, Style: StyleCode}
+}
+
+// Block is a part of a reply, a Paragraph, List or Table
+type Block interface {
+	block()
+}
+
+// Paragraph is a block of spans, it can contain newlines
+type Paragraph []Span
+
+// List is a bulleted list, each item is a line of spans
+type List struct {
+	Items [][]Span
+}
+
+// Add adds an item to the list
+func (l *List) Add(spans ...Span) *List {
+	l.Items = append(l.Items, spans)
+	return l
+}
+
+// Table is a table of plain text
+type Table struct {
+	Header []string
+	Rows   [][]string
+}
+
+// Add adds a row to the table
+func (t *Table) Add(cells ...string) *Table {
+	t.Rows = append(t.Rows, cells)
+	return t
+}
+
+func (Paragraph) block() {}
+func (*List) block()     {}
+func (*Table) block()    {}
+
+// Button is a button that runs a command when it's pressed
+type Button struct {
+	// Text is the label of the button
+	Text string
+
+	// Command is the command that is run, e.g. /accept 1234abcd
+	Command string
+}
+
+// Reply is a message we send, built from blocks of formatted text, buttons and
+// attachments. Providers render it in their own format.
+type Reply struct {
+	Blocks      []Block
+	Buttons     []Button
+	Attachments []*Document
+}
+
+// NewReply creates a reply with a paragraph of spans
+func NewReply(spans ...Span) *Reply {
+	r := &Reply{}
+	if len(spans) != 0 {
+		r.Add(spans...)
+	}
+	return r
+}
+
+// TextReply creates a reply with a paragraph of plain text
+func TextReply(text string) *Reply {
+	return NewReply(Text(text))
+}
+
+// Add adds a paragraph of spans to the reply
+func (r *Reply) Add(spans ...Span) *Reply {
+	r.Blocks = append(r.Blocks, Paragraph(spans))
+	return r
+}
+
+// List adds a list to the reply, and returns it so items can be added to it
+func (r *Reply) List() *List {
+	l := &List{}
+	r.Blocks = append(r.Blocks, l)
+	return l
+}
+
+// Table adds a table to the reply, and returns it so rows can be added to it
+func (r *Reply) Table(header ...string) *Table {
+	t := &Table{Header: header}
+	r.Blocks = append(r.Blocks, t)
+	return t
+}
+
+// AddButton adds a button that runs command to the reply
+func (r *Reply) AddButton(text, command string) *Reply {
+	r.Buttons = append(r.Buttons, Button{Text: text, Command: command})
+	return r
+}
+
+// Attach attaches a document to the reply
+func (r *Reply) Attach(d *Document) *Reply {
+	r.Attachments = append(r.Attachments, d)
+	return r
+}
+
+// Append adds everything in o to the end of the reply
+func (r *Reply) Append(o *Reply) *Reply {
+	if o == nil {
+		return r
+	}
+
+	r.Blocks = append(r.Blocks, o.Blocks...)
+	r.Buttons = append(r.Buttons, o.Buttons...)
+	r.Attachments = append(r.Attachments, o.Attachments...)
+	return r
+}
+
+// Empty returns if there's nothing to send
+func (r *Reply) Empty() bool {
+	return r == nil || (len(r.Blocks) == 0 && len(r.Buttons) == 0 && len(r.Attachments) == 0)
+}
@@ -1,17 +1,30 @@
 package social
 
 import (
+	"context"
+	"errors"
+
 	"github.com/jaredallard/balance/pkg/account"
 )
 
+// ErrUnsupported is returned when a provider doesn't support something, e.g.
+// sending files
+var ErrUnsupported = errors.New("not supported by this provider")
+
 // Message is a message published be a social media provider
 type Message struct {
 	// from is who this message is from
 	From *account.User
 
+	// ID is the underlying provider's messageId, it's empty for messages we send
+	ID string
+
 	// ChatID is the underlying provider's chatId
 	ChatID string
 
+	// Private is true when this message was sent in a private chat with the bot
+	Private bool
+
 	// Username is the username of the user we got this from
 	Username string
 
@@ -21,22 +34,122 @@ type Message struct {
 	// PlatformName is the social media platform this came from
 	PlatformName account.PlatformName
 
-	Replyer func(chatId, message string) error
+	// Replyer replies to this message, it's set by the provider the message came from
+	Replyer func(r *Reply) error
 
 	// Error is included if an error occurred while processing this message
 	Error error
 
 	// Text is the message text
 	Text string
+
+	// Mentions are the users that were mentioned in this message
+	Mentions []Mention
+
+	// Attachment is a file that was sent with this message, if any
+	Attachment *Attachment
+
+	// ctx carries the message's trace, see Context
+	ctx context.Context
+}
+
+// Context returns the message's context, which carries the span it's being
+// processed in
+func (m *Message) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// WithContext returns a copy of the message with its context set to ctx
+func (m Message) WithContext(ctx context.Context) Message {
+	m.ctx = ctx
+	return m
+}
+
+// Mention is a user that was mentioned in a message
+type Mention struct {
+	// Username is the (lowercased) username of the user that was mentioned
+	Username string
+
+	// UserID is the underlying provider's userId of the user that was
+	// mentioned, if known. Mentions with a UserID are included in the
+	// message text as @id:UserID.
+	UserID string
+}
+
+// Attachment is a file that was sent with a message, it's only downloaded
+// when it's needed
+type Attachment struct {
+	// Name is the file name of the attachment
+	Name string
+
+	// Size is the size of the attachment in bytes
+	Size int
+
+	// Download downloads the contents of the attachment
+	Download func() ([]byte, error)
 }
 
 // Reply is an easier to use interface for the built-in message replyer
-func (m *Message) Reply(text string) error {
-	return m.Replyer(m.ChatID, text)
+func (m *Message) Reply(r *Reply) error {
+	return m.Replyer(r)
+}
+
+// Capabilities are the features a provider supports
+type Capabilities struct {
+	// Markdown is set if messages are formatted as markdown
+	Markdown bool
+
+	// Buttons is set if messages can have buttons
+	Buttons bool
+
+	// Files is set if files can be sent and received
+	Files bool
 }
 
 // Provider is a Social Media provider that integrates with an account
 type Provider interface {
-	// CreateStream returns a message stream from a provider
-	CreateStream() (<-chan *Message, error)
+	Sender
+
+	// Name returns the platform this provider is for
+	Name() account.PlatformName
+
+	// Capabilities returns the features this provider supports
+	Capabilities() Capabilities
+
+	// CreateStream returns a message stream from a provider. Once ctx is done
+	// the provider stops receiving messages, sends any it already received,
+	// and closes the stream.
+	CreateStream(ctx context.Context) (<-chan Message, error)
+
+	// Reply replies to a message that came from this provider
+	Reply(to *Message, r *Reply) error
+
+	// Close releases the provider's resources, it's called once its stream is
+	// closed
+	Close() error
+}
+
+// HealthReporter is a provider that can report if it's connected
+type HealthReporter interface {
+	// Health returns an error if the provider isn't connected
+	Health() error
+}
+
+// Sender is a provider that can send messages that aren't replies, e.g. a
+// notification to a user
+type Sender interface {
+	// Send sends r to to.ChatID on to.PlatformName, returning ErrUnsupported
+	// if it has attachments and the provider can't send files
+	Send(to *Message, r *Reply) error
+}
+
+// Document is a file attached to a message
+type Document struct {
+	// Name is the file name of the document
+	Name string
+
+	Data []byte
 }
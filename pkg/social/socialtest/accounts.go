@@ -0,0 +1,129 @@
+package socialtest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+)
+
+// Accounts is an in-memory version of the parts of account.Client providers
+// use to find who sent a message. It counts lookups, so caching can be tested.
+// Like account.Client, it hands out copies of its users.
+type Accounts struct {
+	mu        sync.Mutex
+	users     []*account.User
+	listeners []account.UserListener
+	lookups   int
+}
+
+// NewAccounts creates an empty set of accounts
+func NewAccounts() *Accounts {
+	return &Accounts{}
+}
+
+// AddUser adds a user, creating its ID if it doesn't have one
+func (a *Accounts) AddUser(u *account.User) *account.User {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if u.Id == uuid.Nil {
+		u.Id = uuid.Must(uuid.NewV4())
+	}
+	if u.PlatformIds == nil {
+		u.PlatformIds = make(map[account.PlatformName]string)
+	}
+	if u.PlatformUsernames == nil {
+		u.PlatformUsernames = make(map[account.PlatformName]string)
+	}
+
+	a.users = append(a.users, u)
+	return u
+}
+
+// Lookups returns how many times FindUser has been called
+func (a *Accounts) Lookups() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lookups
+}
+
+// Changed notifies every listener that a user changed, like account.Client
+// does when a user is updated or merged
+func (a *Accounts) Changed(u *account.User) {
+	a.mu.Lock()
+	listeners := append([]account.UserListener{}, a.listeners...)
+	a.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(u)
+	}
+}
+
+// FindUser finds a user by their platform ID
+func (a *Accounts) FindUser(ctx context.Context, p account.PlatformName, id string) (*account.User, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.lookups++
+	for _, u := range a.users {
+		if u.PlatformIds[p] == id {
+			return u.Clone(), nil
+		}
+	}
+
+	return nil, account.ErrUserNotFound
+}
+
+// ClaimPlaceholder turns a placeholder into a real user, placeholders are
+// matched by their platform ID if they have one, otherwise by their username
+// if one is given
+func (a *Accounts) ClaimPlaceholder(ctx context.Context, p account.PlatformName, id, username string) (*account.User, error) {
+	a.mu.Lock()
+	var claimed *account.User
+	for _, u := range a.users {
+		pid, hasID := u.PlatformIds[p]
+		if u.Placeholder && (pid == id || (!hasID && username != "" && u.PlatformUsernames[p] == username)) {
+			claimed = u
+			break
+		}
+	}
+	if claimed != nil {
+		claimed.Placeholder = false
+		claimed.PlatformIds[p] = id
+		if username != "" {
+			claimed.PlatformUsernames[p] = username
+		}
+	}
+	a.mu.Unlock()
+
+	if claimed == nil {
+		return nil, account.ErrUserNotFound
+	}
+
+	a.Changed(claimed)
+	return claimed.Clone(), nil
+}
+
+// UpdateUsername changes the username of a user on a platform
+func (a *Accounts) UpdateUsername(ctx context.Context, u *account.User, p account.PlatformName, username string) error {
+	a.mu.Lock()
+	for _, stored := range a.users {
+		if stored.Id == u.Id {
+			stored.PlatformUsernames[p] = username
+		}
+	}
+	a.mu.Unlock()
+	u.PlatformUsernames[p] = username
+
+	a.Changed(u)
+	return nil
+}
+
+// AddUserListener calls fn whenever a user changes
+func (a *Accounts) AddUserListener(fn account.UserListener) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.listeners = append(a.listeners, fn)
+}
@@ -0,0 +1,345 @@
+// Package socialtest provides a conformance suite that every social.Provider
+// should pass, and fakes to run providers against without network access.
+package socialtest
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaredallard/balance/pkg/social"
+)
+
+// Timeout is how long the suite waits for a provider or platform to do
+// something before failing
+var Timeout = 10 * time.Second
+
+// Incoming is a message sent to a provider by a user on its platform. IDs are
+// numeric, since some platforms only have numeric IDs.
+type Incoming struct {
+	ChatID   string
+	UserID   string
+	Username string
+	Text     string
+	Private  bool
+
+	// Attachment is a file sent with the message, Text is its caption
+	Attachment *social.Document
+}
+
+// Outgoing is a message a provider sent to its platform
+type Outgoing struct {
+	ChatID string
+
+	// ReplyTo is the ID of the message this replies to, empty if it isn't a reply
+	ReplyTo string
+
+	// Text is the text of the message, as the provider rendered it
+	Text string
+
+	Buttons     []social.Button
+	Attachments []*social.Document
+}
+
+// Platform is the platform side of a provider under test, usually a fake of
+// its API
+type Platform interface {
+	// Deliver sends a message to the provider, returning its ID on the platform
+	Deliver(in Incoming) string
+
+	// Sent waits for the next message the provider sent, returning an error if
+	// there isn't one before ctx is done
+	Sent(ctx context.Context) (*Outgoing, error)
+
+	// SetDown makes the platform fail every request until it's set back up
+	SetDown(down bool)
+}
+
+// Factory creates a provider connected to a new platform, both are cleaned
+// up by the factory once the test is done, e.g. with t.Cleanup
+type Factory func(t *testing.T) (social.Provider, Platform)
+
+// Run runs the conformance suite against providers created by factory
+func Run(t *testing.T, factory Factory) {
+	t.Run("MessageMapping", func(t *testing.T) { testMessageMapping(t, factory) })
+	t.Run("Attachments", func(t *testing.T) { testAttachments(t, factory) })
+	t.Run("ReplyRouting", func(t *testing.T) { testReplyRouting(t, factory) })
+	t.Run("Send", func(t *testing.T) { testSend(t, factory) })
+	t.Run("Cancellation", func(t *testing.T) { testCancellation(t, factory) })
+	t.Run("Reconnect", func(t *testing.T) { testReconnect(t, factory) })
+}
+
+// start creates a provider and starts its stream, which is stopped once the
+// test is done
+func start(t *testing.T, factory Factory) (social.Provider, Platform, <-chan social.Message) {
+	t.Helper()
+
+	p, platform := factory(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := p.CreateStream(ctx)
+	if err != nil {
+		cancel()
+		t.Fatalf("failed to create stream: %v", err)
+	}
+
+	t.Cleanup(func() {
+		cancel()
+		for range stream {
+		}
+	})
+
+	return p, platform, stream
+}
+
+// receive waits for the next message from a stream
+func receive(t *testing.T, stream <-chan social.Message) *social.Message {
+	t.Helper()
+
+	select {
+	case msg, ok := <-stream:
+		if !ok {
+			t.Fatalf("stream closed while waiting for a message")
+		}
+		return &msg
+	case <-time.After(Timeout):
+		t.Fatalf("no message received after %v", Timeout)
+	}
+	return nil
+}
+
+// sent waits for the next message sent to a platform
+func sent(t *testing.T, platform Platform) *Outgoing {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	out, err := platform.Sent(ctx)
+	if err != nil {
+		t.Fatalf("nothing was sent to the platform: %v", err)
+	}
+	return out
+}
+
+// eventually waits for cond to be true, failing with msg if it isn't in time
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+
+	deadline := time.Now().Add(Timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out after %v: %s", Timeout, msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testMessageMapping(t *testing.T, factory Factory) {
+	p, platform, stream := start(t, factory)
+
+	for _, in := range []Incoming{
+		{ChatID: "1001", UserID: "1001", Username: "alice", Text: "/status", Private: true},
+		{ChatID: "2002", UserID: "1002", Username: "bob", Text: `/add alice 10 "pizza"`},
+	} {
+		id := platform.Deliver(in)
+		msg := receive(t, stream)
+
+		if msg.ID != id {
+			t.Errorf("expected message ID '%s', got '%s'", id, msg.ID)
+		}
+		if msg.ChatID != in.ChatID {
+			t.Errorf("expected chat ID '%s', got '%s'", in.ChatID, msg.ChatID)
+		}
+		if msg.UserID != in.UserID {
+			t.Errorf("expected user ID '%s', got '%s'", in.UserID, msg.UserID)
+		}
+		if msg.Username != in.Username {
+			t.Errorf("expected username '%s', got '%s'", in.Username, msg.Username)
+		}
+		if msg.Text != in.Text {
+			t.Errorf("expected text '%s', got '%s'", in.Text, msg.Text)
+		}
+		if msg.Private != in.Private {
+			t.Errorf("expected private to be %v, got %v", in.Private, msg.Private)
+		}
+		if msg.PlatformName != p.Name() {
+			t.Errorf("expected platform '%s', got '%s'", p.Name(), msg.PlatformName)
+		}
+		if msg.Replyer == nil {
+			t.Errorf("message has no replyer")
+		}
+		if msg.Context() == nil {
+			t.Errorf("message has no context")
+		}
+	}
+}
+
+func testAttachments(t *testing.T, factory Factory) {
+	p, platform, stream := start(t, factory)
+	if !p.Capabilities().Files {
+		t.Skip("provider doesn't support files")
+	}
+
+	doc := &social.Document{Name: "export.csv", Data: []byte("date,amount\n2020-01-31,10\n")}
+	platform.Deliver(Incoming{ChatID: "1001", UserID: "1001", Username: "alice", Text: "/import dry-run", Private: true, Attachment: doc})
+	msg := receive(t, stream)
+
+	if msg.Text != "/import dry-run" {
+		t.Errorf("expected the caption as the text, got '%s'", msg.Text)
+	}
+	if msg.Attachment == nil {
+		t.Fatalf("message has no attachment")
+	}
+	if msg.Attachment.Name != doc.Name {
+		t.Errorf("expected attachment '%s', got '%s'", doc.Name, msg.Attachment.Name)
+	}
+	if msg.Attachment.Size != len(doc.Data) {
+		t.Errorf("expected attachment size %d, got %d", len(doc.Data), msg.Attachment.Size)
+	}
+
+	data, err := msg.Attachment.Download()
+	if err != nil {
+		t.Fatalf("failed to download attachment: %v", err)
+	}
+	if !bytes.Equal(data, doc.Data) {
+		t.Errorf("expected attachment data '%s', got '%s'", doc.Data, data)
+	}
+}
+
+func testReplyRouting(t *testing.T, factory Factory) {
+	p, platform, stream := start(t, factory)
+
+	id := platform.Deliver(Incoming{ChatID: "2002", UserID: "1001", Username: "alice", Text: "/status"})
+	msg := receive(t, stream)
+
+	if err := msg.Reply(social.TextReply("replyer")); err != nil {
+		t.Fatalf("failed to reply with the message's replyer: %v", err)
+	}
+	if err := p.Reply(msg, social.TextReply("provider")); err != nil {
+		t.Fatalf("failed to reply with the provider: %v", err)
+	}
+
+	for _, text := range []string{"replyer", "provider"} {
+		out := sent(t, platform)
+		if out.ChatID != "2002" {
+			t.Errorf("expected reply in chat '2002', got '%s'", out.ChatID)
+		}
+		if out.ReplyTo != id {
+			t.Errorf("expected reply to message '%s', got '%s'", id, out.ReplyTo)
+		}
+		if !strings.Contains(out.Text, text) {
+			t.Errorf("expected reply to contain '%s', got '%s'", text, out.Text)
+		}
+	}
+}
+
+func testSend(t *testing.T, factory Factory) {
+	p, platform, _ := start(t, factory)
+	to := &social.Message{ChatID: "1001", PlatformName: p.Name()}
+
+	r := social.TextReply("notification")
+	if p.Capabilities().Buttons {
+		r.AddButton("Accept", "/accept 1234abcd")
+	}
+	if err := p.Send(to, r); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+
+	out := sent(t, platform)
+	if out.ChatID != "1001" {
+		t.Errorf("expected message in chat '1001', got '%s'", out.ChatID)
+	}
+	if out.ReplyTo != "" {
+		t.Errorf("expected a message that isn't a reply, got a reply to '%s'", out.ReplyTo)
+	}
+	if !strings.Contains(out.Text, "notification") {
+		t.Errorf("expected message to contain 'notification', got '%s'", out.Text)
+	}
+	if p.Capabilities().Buttons {
+		if len(out.Buttons) != 1 || out.Buttons[0] != r.Buttons[0] {
+			t.Errorf("expected buttons %v, got %v", r.Buttons, out.Buttons)
+		}
+	}
+
+	doc := &social.Document{Name: "balance.csv", Data: []byte("date,amount\n")}
+	err := p.Send(to, social.TextReply("export").Attach(doc))
+	if !p.Capabilities().Files {
+		if err != social.ErrUnsupported {
+			t.Errorf("expected ErrUnsupported sending a file, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("failed to send file: %v", err)
+	}
+
+	out = sent(t, platform)
+	if len(out.Attachments) != 1 {
+		t.Fatalf("expected 1 attachment, got %d", len(out.Attachments))
+	}
+	if out.Attachments[0].Name != doc.Name || !bytes.Equal(out.Attachments[0].Data, doc.Data) {
+		t.Errorf("expected attachment '%s' (%q), got '%s' (%q)", doc.Name, doc.Data, out.Attachments[0].Name, out.Attachments[0].Data)
+	}
+}
+
+func testCancellation(t *testing.T, factory Factory) {
+	p, platform := factory(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := p.CreateStream(ctx)
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+
+	platform.Deliver(Incoming{ChatID: "1001", UserID: "1001", Username: "alice", Text: "/status", Private: true})
+	receive(t, stream)
+	cancel()
+
+	// the stream has to close, even while it's waiting on the platform
+	timeout := time.After(Timeout)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-stream:
+			closed = !ok
+		case <-timeout:
+			t.Fatalf("stream wasn't closed %v after its context was cancelled", Timeout)
+		}
+	}
+
+	if err := p.Close(); err != nil {
+		t.Errorf("failed to close provider: %v", err)
+	}
+}
+
+func testReconnect(t *testing.T, factory Factory) {
+	p, platform, stream := start(t, factory)
+	hr, reportsHealth := p.(social.HealthReporter)
+
+	platform.Deliver(Incoming{ChatID: "1001", UserID: "1001", Username: "alice", Text: "/status", Private: true})
+	receive(t, stream)
+	if reportsHealth {
+		eventually(t, func() bool { return hr.Health() == nil }, "provider isn't healthy while connected")
+	}
+
+	platform.SetDown(true)
+	if reportsHealth {
+		eventually(t, func() bool { return hr.Health() != nil }, "provider is healthy while its platform is down")
+	} else {
+		time.Sleep(time.Second)
+	}
+	platform.SetDown(false)
+
+	// messages sent while we were down, or after we're back up, both arrive
+	platform.Deliver(Incoming{ChatID: "1001", UserID: "1001", Username: "alice", Text: "/pending", Private: true})
+	if msg := receive(t, stream); msg.Text != "/pending" {
+		t.Errorf("expected '/pending' after reconnecting, got '%s'", msg.Text)
+	}
+
+	if reportsHealth {
+		eventually(t, func() bool { return hr.Health() == nil }, "provider isn't healthy after reconnecting")
+	}
+}
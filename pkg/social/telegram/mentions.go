@@ -0,0 +1,69 @@
+package telegram
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/jaredallard/balance/pkg/social"
+)
+
+// parseMentions returns the text of a message with every text_mention, a mention
+// of a user by their name, replaced with @id:USERID so that handlers can resolve
+// them, and every user that was mentioned
+func parseMentions(m *tgbotapi.Message) (string, []social.Mention) {
+	if m.Entities == nil || len(*m.Entities) == 0 {
+		return m.Text, nil
+	}
+
+	entities := make([]tgbotapi.MessageEntity, len(*m.Entities))
+	copy(entities, *m.Entities)
+
+	// replace from the end of the message so earlier offsets stay valid
+	sort.Slice(entities, func(i, j int) bool {
+		return entities[i].Offset > entities[j].Offset
+	})
+
+	// entity offsets are in UTF-16 code units
+	text := utf16.Encode([]rune(m.Text))
+	mentions := make([]social.Mention, 0)
+	for _, e := range entities {
+		if e.Offset < 0 || e.Length <= 0 || e.Offset+e.Length > len(text) {
+			continue
+		}
+
+		switch e.Type {
+		case "mention":
+			username := string(utf16.Decode(text[e.Offset : e.Offset+e.Length]))
+			mentions = append(mentions, social.Mention{
+				Username: strings.ToLower(strings.TrimPrefix(username, "@")),
+			})
+		case "text_mention":
+			if e.User == nil {
+				continue
+			}
+
+			id := strconv.Itoa(e.User.ID)
+			mentions = append(mentions, social.Mention{
+				Username: getUsername(e.User),
+				UserID:   id,
+			})
+
+			replacement := utf16.Encode([]rune("@id:" + id))
+			spliced := make([]uint16, 0, len(text)-e.Length+len(replacement))
+			spliced = append(spliced, text[:e.Offset]...)
+			spliced = append(spliced, replacement...)
+			spliced = append(spliced, text[e.Offset+e.Length:]...)
+			text = spliced
+		}
+	}
+
+	// we walked the entities backwards
+	for i, j := 0, len(mentions)-1; i < j; i, j = i+1, j-1 {
+		mentions[i], mentions[j] = mentions[j], mentions[i]
+	}
+
+	return string(utf16.Decode(text)), mentions
+}
@@ -0,0 +1,126 @@
+package telegram
+
+import (
+	"reflect"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/jaredallard/balance/pkg/social"
+)
+
+func TestParseMentions(t *testing.T) {
+	bob := &tgbotapi.User{ID: 2, FirstName: "Bob"}
+	carol := &tgbotapi.User{ID: 3, FirstName: "Carol", UserName: "Carol_C"}
+
+	tests := []struct {
+		name     string
+		text     string
+		entities []tgbotapi.MessageEntity
+		want     string
+		mentions []social.Mention
+	}{
+		{
+			name: "no entities",
+			text: "/status",
+			want: "/status",
+		},
+		{
+			name:     "mention at the start",
+			text:     "@Alice paid",
+			entities: []tgbotapi.MessageEntity{{Type: "mention", Offset: 0, Length: 6}},
+			want:     "@Alice paid",
+			mentions: []social.Mention{{Username: "alice"}},
+		},
+		{
+			name:     "text mention at the start",
+			text:     "Bob paid",
+			entities: []tgbotapi.MessageEntity{{Type: "text_mention", Offset: 0, Length: 3, User: bob}},
+			want:     "@id:2 paid",
+			mentions: []social.Mention{{Username: "bob", UserID: "2"}},
+		},
+		{
+			name:     "mention at the end",
+			text:     "/add 5 @alice",
+			entities: []tgbotapi.MessageEntity{{Type: "mention", Offset: 7, Length: 6}},
+			want:     "/add 5 @alice",
+			mentions: []social.Mention{{Username: "alice"}},
+		},
+		{
+			name:     "text mention at the end",
+			text:     "/add 5 Bob",
+			entities: []tgbotapi.MessageEntity{{Type: "text_mention", Offset: 7, Length: 3, User: bob}},
+			want:     "/add 5 @id:2",
+			mentions: []social.Mention{{Username: "bob", UserID: "2"}},
+		},
+		{
+			// 🍕 is two UTF-16 code units
+			name:     "emoji before a mention",
+			text:     "🍕 @alice",
+			entities: []tgbotapi.MessageEntity{{Type: "mention", Offset: 3, Length: 6}},
+			want:     "🍕 @alice",
+			mentions: []social.Mention{{Username: "alice"}},
+		},
+		{
+			name:     "emoji before a text mention",
+			text:     "🍕🍕 Bob owes",
+			entities: []tgbotapi.MessageEntity{{Type: "text_mention", Offset: 5, Length: 3, User: bob}},
+			want:     "🍕🍕 @id:2 owes",
+			mentions: []social.Mention{{Username: "bob", UserID: "2"}},
+		},
+		{
+			name:     "non-BMP text in a text mention",
+			text:     "/add 5 Bob🍕 for lunch",
+			entities: []tgbotapi.MessageEntity{{Type: "text_mention", Offset: 7, Length: 5, User: bob}},
+			want:     "/add 5 @id:2 for lunch",
+			mentions: []social.Mention{{Username: "bob", UserID: "2"}},
+		},
+		{
+			name: "several mentions",
+			text: "@alice and Bob split 🍕 with Carol",
+			entities: []tgbotapi.MessageEntity{
+				// not in order, the way we sort them shouldn't matter
+				{Type: "text_mention", Offset: 29, Length: 5, User: carol},
+				{Type: "mention", Offset: 0, Length: 6},
+				{Type: "bold", Offset: 21, Length: 2},
+				{Type: "text_mention", Offset: 11, Length: 3, User: bob},
+			},
+			want: "@alice and @id:2 split 🍕 with @id:3",
+			mentions: []social.Mention{
+				{Username: "alice"},
+				{Username: "bob", UserID: "2"},
+				{Username: "carol_c", UserID: "3"},
+			},
+		},
+		{
+			name: "invalid entities",
+			text: "Bob 🍕",
+			entities: []tgbotapi.MessageEntity{
+				{Type: "text_mention", Offset: 0, Length: 3},
+				{Type: "mention", Offset: 4, Length: 3},
+				{Type: "mention", Offset: -1, Length: 2},
+				{Type: "mention", Offset: 1, Length: 0},
+			},
+			want:     "Bob 🍕",
+			mentions: []social.Mention{},
+		},
+	}
+
+	for _, tt := range tests {
+		m := &tgbotapi.Message{Text: tt.text}
+		if tt.entities != nil {
+			entities := append([]tgbotapi.MessageEntity{}, tt.entities...)
+			m.Entities = &entities
+		}
+
+		text, mentions := parseMentions(m)
+		if text != tt.want {
+			t.Errorf("%s: expected text '%s', got '%s'", tt.name, tt.want, text)
+		}
+		if !reflect.DeepEqual(mentions, tt.mentions) {
+			t.Errorf("%s: expected mentions %v, got %v", tt.name, tt.mentions, mentions)
+		}
+		if m.Entities != nil && !reflect.DeepEqual(*m.Entities, tt.entities) {
+			t.Errorf("%s: expected the entities of the message not to change, got %v", tt.name, *m.Entities)
+		}
+	}
+}
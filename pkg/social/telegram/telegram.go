@@ -3,90 +3,224 @@ package telegram
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/metrics"
 	"github.com/jaredallard/balance/pkg/social"
+	"github.com/jaredallard/balance/pkg/tracing"
 	"github.com/patrickmn/go-cache"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// pollTimeout is how long, in seconds, Telegram holds a request for updates
+// open waiting for one
+const pollTimeout = 60
+
+// Accounts is what the provider needs to find out who sent a message,
+// *account.Client implements it
+type Accounts interface {
+	FindUser(ctx context.Context, p account.PlatformName, id string) (*account.User, error)
+	ClaimPlaceholder(ctx context.Context, p account.PlatformName, id, username string) (*account.User, error)
+	UpdateUsername(ctx context.Context, u *account.User, p account.PlatformName, username string) error
+	AddUserListener(fn account.UserListener)
+}
+
 type Provider struct {
 	client  *tgbotapi.BotAPI
-	account *account.Client
+	account Accounts
 	cache   *cache.Cache
+
+	// backoff is how long we wait between failed requests for updates
+	backoff social.Backoff
+
+	statusMu sync.Mutex
+	// streaming is set while a message stream is running
+	streaming bool
+	// pollErr is the error from the last request for updates, if it failed
+	pollErr error
+}
+
+func init() {
+	social.Register(account.PlatformTelegram, func(a *account.Client) (social.Provider, error) {
+		return NewProvider(a)
+	})
 }
 
 // NewProvider creates a new Telegram message provider
 func NewProvider(a *account.Client) (*Provider, error) {
-	bot, err := tgbotapi.NewBotAPI(os.Getenv("TELEGRAM_TOKEN"))
+	return NewProviderWithClient(a, os.Getenv("TELEGRAM_TOKEN"), &http.Client{})
+}
+
+// NewProviderWithClient creates a new Telegram message provider that makes
+// requests to Telegram with client, e.g. to talk to a fake of the Bot API
+func NewProviderWithClient(a Accounts, token string, client *http.Client) (*Provider, error) {
+	bot, err := tgbotapi.NewBotAPIWithClient(token, client)
 	if err != nil {
 		return nil, err
 	}
 
 	c := cache.New(30*time.Minute, 1*time.Hour)
 
-	return &Provider{
+	p := &Provider{
 		client:  bot,
 		account: a,
 		cache:   c,
-	}, nil
+		backoff: social.Backoff{Min: 1 * time.Second, Max: 1 * time.Minute},
+	}
+
+	// drop users from our cache when they change, e.g. when they're merged into another user
+	a.AddUserListener(func(u *account.User) {
+		if id, ok := u.PlatformIds[account.PlatformTelegram]; ok {
+			p.cache.Delete(fmt.Sprintf("%s:%s", account.PlatformTelegram, id))
+		}
+	})
+
+	return p, nil
+}
+
+// Name returns the platform this provider is for
+func (p *Provider) Name() account.PlatformName {
+	return account.PlatformTelegram
+}
+
+// Capabilities returns the features Telegram supports
+func (p *Provider) Capabilities() social.Capabilities {
+	return social.Capabilities{
+		Markdown: true,
+		Buttons:  true,
+		Files:    true,
+	}
 }
 
-func (p *Provider) processUpdate(update tgbotapi.Update, stream chan social.Message) error {
+// Close closes any idle connections to Telegram
+func (p *Provider) Close() error {
+	p.client.Client.CloseIdleConnections()
+	return nil
+}
+
+func (p *Provider) processUpdate(ctx context.Context, update tgbotapi.Update, stream chan social.Message) error {
 	log.Infof("got update: %v", update)
-	if update.Message == nil { // ignore any non-Message Updates
+	m := update.Message
+
+	// pressing a button runs its command, as if whoever pressed it sent it
+	if cq := update.CallbackQuery; cq != nil && cq.Message != nil {
+		if _, err := p.client.AnswerCallbackQuery(tgbotapi.NewCallback(cq.ID, "")); err != nil {
+			log.Warnf("failed to answer callback query: %v", err)
+		}
+
+		m = &tgbotapi.Message{
+			MessageID: cq.Message.MessageID,
+			From:      cq.From,
+			Chat:      cq.Message.Chat,
+			Text:      cq.Data,
+		}
+	}
+
+	if m == nil { // ignore any non-Message Updates
 		log.Infof("skipping non-message update")
 		return nil
 	}
 
-	username := update.Message.From.UserName
-	if username == "" {
-		username = update.Message.From.FirstName + update.Message.From.LastName
+	// messages sent on behalf of a channel don't have a sender
+	if m.From == nil || m.Chat == nil {
+		metrics.UpdatesSkipped.WithLabelValues(string(account.PlatformTelegram), "malformed").Inc()
+		log.Warnf("skipping message %d without a sender or chat", m.MessageID)
+		return nil
 	}
-	username = strings.ToLower(username)
+
+	username := getUsername(m.From)
+
+	// the span is ended by whoever handles the message, once it's replied to.
+	// Messages outlive the stream, so they can be handled while we shutdown.
+	ctx, span := tracing.Tracer().Start(context.WithoutCancel(ctx), "telegram.message", trace.WithSpanKind(trace.SpanKindConsumer))
+	span.SetAttributes(
+		attribute.String("messaging.system", string(account.PlatformTelegram)),
+		attribute.Int("telegram.message_id", m.MessageID),
+		attribute.Int64("telegram.chat_id", m.Chat.ID),
+	)
 
 	msg := social.Message{
-		ChatID:       strconv.Itoa(int(update.Message.Chat.ID)),
+		ID:           strconv.Itoa(m.MessageID),
+		ChatID:       strconv.Itoa(int(m.Chat.ID)),
+		Private:      m.Chat.IsPrivate(),
 		Username:     username,
-		UserID:       strconv.Itoa(update.Message.From.ID),
+		UserID:       strconv.Itoa(m.From.ID),
 		PlatformName: account.PlatformTelegram,
-		Text:         update.Message.Text,
-		Replyer: func(chatId, text string) error {
-			chatID, err := strconv.Atoi(chatId)
-			if err != nil {
-				return err
-			}
+		Replyer: func(r *social.Reply) error {
+			return p.send(ctx, strconv.Itoa(int(m.Chat.ID)), m.MessageID, r)
+		},
+	}
+	msg = msg.WithContext(ctx)
 
-			log.Infof("[telegram] sending message: %v", strings.ReplaceAll(text, "\n", "\\n"))
+	msg.Text, msg.Mentions = parseMentions(m)
 
-			msg := tgbotapi.NewMessage(int64(chatID), text)
-			msg.ReplyToMessageID = update.Message.MessageID
-			msg.ParseMode = "Markdown"
-			_, err = p.client.Send(msg)
-			return err
-		},
+	// commands can be sent as the caption of a file
+	if d := m.Document; d != nil {
+		if msg.Text == "" {
+			msg.Text = m.Caption
+		}
+
+		msg.Attachment = &social.Attachment{
+			Name: d.FileName,
+			Size: d.FileSize,
+			Download: func() ([]byte, error) {
+				return p.download(d.FileID)
+			},
+		}
 	}
 
-	cacheKey := fmt.Sprintf("%s:%d", account.PlatformTelegram, update.Message.From.ID)
+	cacheKey := fmt.Sprintf("%s:%d", account.PlatformTelegram, m.From.ID)
 	v, found := p.cache.Get(cacheKey)
+	metrics.CacheLookup("telegram_users", found && v != nil)
 
 	// check if we didn't find a user
 	if !found || v == nil {
 		log.Warnf("cache miss for user: %s", cacheKey)
-		u, err := p.account.FindUser(account.PlatformTelegram, strconv.Itoa(update.Message.From.ID))
-		if err != nil {
-			msg.Error = err
-		} else {
+		u, err := p.account.FindUser(ctx, account.PlatformTelegram, strconv.Itoa(m.From.ID))
+
+		// users that were invited before they talked to us claim their placeholder,
+		// only by their real username since anyone can use any name
+		if err == account.ErrUserNotFound || (err == nil && u.Placeholder) {
+			u, err = p.account.ClaimPlaceholder(ctx, account.PlatformTelegram, strconv.Itoa(m.From.ID), strings.ToLower(m.From.UserName))
+			if err == nil {
+				log.Infof("user %s claimed their placeholder", u.Id)
+			}
+		}
+
+		// handlers change the users of their messages, so the cache keeps its own copy
+		if err == nil {
 			msg.From = u
+			p.cache.Set(cacheKey, u.Clone(), cache.DefaultExpiration)
+		} else if err != account.ErrUserNotFound {
+			// we don't know if this user is registered or not, so let the handlers decide
+			msg.Error = err
+			span.RecordError(err)
 		}
-		p.cache.Set(cacheKey, u, cache.DefaultExpiration)
 	} else { // we found the user in our cache
-		msg.From = v.(*account.User)
+		msg.From = v.(*account.User).Clone()
+	}
+
+	// keep track of users changing their username, otherwise they can't be found
+	// by it. Only real usernames are tracked, since anyone can use any name, and
+	// taking a username moves it away from whoever had it before.
+	realUsername := strings.ToLower(m.From.UserName)
+	if msg.From != nil && realUsername != "" && msg.From.PlatformUsernames[account.PlatformTelegram] != realUsername {
+		log.Infof("user %s changed their username from '%s' to '%s'", msg.From.Id, msg.From.PlatformUsernames[account.PlatformTelegram], realUsername)
+		if err := p.account.UpdateUsername(ctx, msg.From, account.PlatformTelegram, realUsername); err != nil {
+			log.Warnf("failed to update username: %v", err)
+		}
 	}
 
 	// TODO(jaredallard): cache users
@@ -94,45 +228,259 @@ func (p *Provider) processUpdate(update tgbotapi.Update, stream chan social.Mess
 	return nil
 }
 
-// CreatStream returns a telegram message stream
-func (p *Provider) CreateStream(ctx context.Context) (<-chan social.Message, error) {
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-	updates, err := p.client.GetUpdatesChan(u)
+// download downloads a file that was sent to us
+func (p *Provider) download(fileID string) ([]byte, error) {
+	url, err := p.client.GetFileDirectURL(fileID)
 	if err != nil {
 		return nil, err
 	}
 
+	resp, err := p.client.Client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download file: %s", resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// getUsername returns the username of a user, users without a username
+// use their name instead
+func getUsername(u *tgbotapi.User) string {
+	username := u.UserName
+	if username == "" {
+		username = u.FirstName + u.LastName
+	}
+
+	return strings.ToLower(username)
+}
+
+// CreatStream returns a telegram message stream
+func (p *Provider) CreateStream(ctx context.Context) (<-chan social.Message, error) {
 	stream := make(chan social.Message)
+	p.setStreaming(true)
 
 	go func() {
 		// close the channel once we're done
 		defer close(stream)
+		defer p.setStreaming(false)
+
+		p.receive(ctx, stream)
+		log.Warnf("message processor shutdown")
+	}()
+
+	return stream, nil
+}
+
+// receive requests updates from Telegram, and processes them, until ctx is
+// done. Failed requests are retried with a backoff.
+func (p *Provider) receive(ctx context.Context, stream chan social.Message) {
+	config := tgbotapi.NewUpdate(0)
+	config.Timeout = pollTimeout
+
+	for ctx.Err() == nil {
+		// requesting updates after offset acknowledges every update before it
+		updates, err := p.getUpdates(ctx, config)
+		p.setPollErr(err)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+
+			metrics.ProviderErrors.WithLabelValues(string(account.PlatformTelegram)).Inc()
+			wait := p.backoff.Next()
+			log.Warnf("failed to get updates from Telegram, retrying in %s: %v", wait, err)
 
-		for {
 			select {
-			case update := <-updates:
-				if err := p.processUpdate(update, stream); err != nil {
-					log.Errorf("error processing message: %v", err)
-				}
+			case <-time.After(wait):
 			case <-ctx.Done():
-				log.Warnf("message processor shutdown")
-				return
 			}
+			continue
+		}
+		p.backoff.Reset()
+
+		for _, update := range updates {
+			if update.UpdateID < config.Offset {
+				continue
+			}
+			config.Offset = update.UpdateID + 1
+
+			if err := p.safeProcessUpdate(ctx, update, stream); err != nil {
+				log.Errorf("error processing message: %v", err)
+			}
+		}
+	}
+
+	// acknowledge the updates we processed, so they aren't sent to us again
+	if config.Offset != 0 {
+		ack := tgbotapi.NewUpdate(config.Offset)
+		ack.Limit = 1
+		if _, err := p.client.GetUpdates(ack); err != nil {
+			log.Warnf("failed to acknowledge updates: %v", err)
 		}
+	}
+}
+
+// getUpdates requests updates from Telegram, giving up once ctx is done.
+// Updates from a request we gave up on are sent to us again, since they
+// haven't been acknowledged.
+func (p *Provider) getUpdates(ctx context.Context, config tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+	type result struct {
+		updates []tgbotapi.Update
+		err     error
+	}
+
+	// buffered, so the request can finish after we've given up on it
+	done := make(chan result, 1)
+	go func() {
+		updates, err := p.client.GetUpdates(config)
+		done <- result{updates, err}
 	}()
 
-	return stream, nil
+	select {
+	case r := <-done:
+		return r.updates, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// safeProcessUpdate processes an update, recovering from any panic so that
+// one bad update can't stop the stream
+func (p *Provider) safeProcessUpdate(ctx context.Context, update tgbotapi.Update, stream chan social.Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			metrics.UpdatesSkipped.WithLabelValues(string(account.PlatformTelegram), "panic").Inc()
+			err = fmt.Errorf("panic processing update %d: %v\n%s", update.UpdateID, r, debug.Stack())
+		}
+	}()
+
+	return p.processUpdate(ctx, update, stream)
+}
+
+func (p *Provider) setStreaming(streaming bool) {
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+	p.streaming = streaming
+
+	v := 0.0
+	if streaming {
+		v = 1
+	}
+	metrics.ProviderStreaming.WithLabelValues(string(account.PlatformTelegram)).Set(v)
+}
+
+func (p *Provider) setPollErr(err error) {
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+	p.pollErr = err
+}
+
+// maxCallbackData is the most bytes Telegram allows in a button's callback data
+const maxCallbackData = 64
+
+// keyboard returns the buttons of a reply as an inline keyboard, buttons are
+// pressed by sending their command back to us
+func keyboard(buttons []social.Button) *tgbotapi.InlineKeyboardMarkup {
+	row := make([]tgbotapi.InlineKeyboardButton, 0, len(buttons))
+	for _, b := range buttons {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(b.Text, b.Command))
+	}
+
+	k := tgbotapi.NewInlineKeyboardMarkup(row)
+	return &k
 }
 
-func (p *Provider) Send(m *social.Message) error {
-	chatID, err := strconv.Atoi(m.ChatID)
+// send sends a reply to a chat, as a reply to messageID if it's set
+func (p *Provider) send(ctx context.Context, chatId string, messageID int, r *social.Reply) error {
+	chatID, err := strconv.Atoi(chatId)
 	if err != nil {
 		return err
 	}
 
-	msg := tgbotapi.NewMessage(int64(chatID), m.Text)
-	msg.ParseMode = "Markdown"
-	_, err = p.client.Send(msg)
-	return err
+	// commands that don't fit in a button are listed instead
+	for _, b := range r.Buttons {
+		if len(b.Command) > maxCallbackData {
+			r = r.ButtonsAsText()
+			break
+		}
+	}
+
+	text := r.Render(social.FormatHTML)
+	log.Infof("[telegram] sending message: %v", strings.ReplaceAll(r.String(), "\n", "\\n"))
+
+	_, span := tracing.Tracer().Start(ctx, "telegram.send", trace.WithSpanKind(trace.SpanKindProducer))
+	defer span.End()
+
+	msgs := make([]tgbotapi.Chattable, 0, len(r.Attachments)+1)
+	if len(r.Attachments) == 0 {
+		msg := tgbotapi.NewMessage(int64(chatID), text)
+		msg.ReplyToMessageID = messageID
+		msg.ParseMode = tgbotapi.ModeHTML
+		if len(r.Buttons) != 0 {
+			msg.ReplyMarkup = keyboard(r.Buttons)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	// the text and buttons are sent with the first document
+	for i, d := range r.Attachments {
+		log.Infof("[telegram] sending document: %s (%d bytes)", d.Name, len(d.Data))
+
+		msg := tgbotapi.NewDocumentUpload(int64(chatID), tgbotapi.FileBytes{Name: d.Name, Bytes: d.Data})
+		msg.ReplyToMessageID = messageID
+		if i == 0 {
+			msg.Caption = text
+			msg.ParseMode = tgbotapi.ModeHTML
+			if len(r.Buttons) != 0 {
+				msg.ReplyMarkup = keyboard(r.Buttons)
+			}
+		}
+		msgs = append(msgs, msg)
+	}
+
+	for _, msg := range msgs {
+		if _, err := p.client.Send(msg); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reply replies to a message that came from Telegram
+func (p *Provider) Reply(to *social.Message, r *social.Reply) error {
+	messageID, err := strconv.Atoi(to.ID)
+	if err != nil {
+		return fmt.Errorf("invalid message id '%s': %v", to.ID, err)
+	}
+
+	return p.send(to.Context(), to.ChatID, messageID, r)
+}
+
+// Send sends a reply to a chat, or a user if the chat is their platform ID
+func (p *Provider) Send(to *social.Message, r *social.Reply) error {
+	return p.send(to.Context(), to.ChatID, 0, r)
+}
+
+// Health returns an error if our message stream isn't running, or our last
+// request for updates failed
+func (p *Provider) Health() error {
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+
+	if !p.streaming {
+		return fmt.Errorf("message stream isn't running")
+	}
+	if p.pollErr != nil {
+		return fmt.Errorf("failed to get updates: %v", p.pollErr)
+	}
+
+	return nil
 }
@@ -0,0 +1,209 @@
+package telegram_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/social"
+	"github.com/jaredallard/balance/pkg/social/socialtest"
+	"github.com/jaredallard/balance/pkg/social/telegram/telegramtest"
+)
+
+func TestConformance(t *testing.T) {
+	socialtest.Run(t, telegramtest.Factory)
+}
+
+// start creates a provider that finds users in accounts, and starts its
+// stream, which is stopped once the test is done
+func start(t *testing.T, accounts *socialtest.Accounts) (*telegramtest.Server, <-chan social.Message) {
+	t.Helper()
+
+	p, s := telegramtest.NewProvider(t, accounts)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := p.CreateStream(ctx)
+	if err != nil {
+		cancel()
+		t.Fatalf("failed to create stream: %v", err)
+	}
+
+	t.Cleanup(func() {
+		cancel()
+		for range stream {
+		}
+	})
+
+	return s, stream
+}
+
+// receive waits for the next message from a stream
+func receive(t *testing.T, stream <-chan social.Message) *social.Message {
+	t.Helper()
+
+	select {
+	case msg, ok := <-stream:
+		if !ok {
+			t.Fatalf("stream closed while waiting for a message")
+		}
+		return &msg
+	case <-time.After(socialtest.Timeout):
+		t.Fatalf("no message received after %v", socialtest.Timeout)
+	}
+	return nil
+}
+
+// newAlice adds alice, who talked to us before, to accounts
+func newAlice(accounts *socialtest.Accounts) *account.User {
+	return accounts.AddUser(&account.User{
+		PlatformIds:       map[account.PlatformName]string{account.PlatformTelegram: "1001"},
+		PlatformUsernames: map[account.PlatformName]string{account.PlatformTelegram: "alice"},
+	})
+}
+
+var fromAlice = socialtest.Incoming{ChatID: "1001", UserID: "1001", Username: "alice", Text: "/status", Private: true}
+
+func TestCachedUser(t *testing.T) {
+	accounts := socialtest.NewAccounts()
+	alice := newAlice(accounts)
+	s, stream := start(t, accounts)
+
+	for i := 0; i < 3; i++ {
+		s.Deliver(fromAlice)
+		msg := receive(t, stream)
+		if msg.From == nil || msg.From.Id != alice.Id || msg.From.PlatformUsernames[account.PlatformTelegram] != "alice" {
+			t.Fatalf("expected message %d to be from alice, got %v", i, msg.From)
+		}
+
+		// handlers can change the user of their message without changing the cache
+		msg.From.PlatformUsernames[account.PlatformTelegram] = "mallory"
+	}
+
+	if got := accounts.Lookups(); got != 1 {
+		t.Errorf("expected alice to be looked up once, and then cached, got %d lookups", got)
+	}
+}
+
+func TestUncachedUnknownUser(t *testing.T) {
+	accounts := socialtest.NewAccounts()
+	s, stream := start(t, accounts)
+
+	// users that haven't registered are looked up every time, so we notice
+	// once they do
+	for i := 0; i < 2; i++ {
+		s.Deliver(fromAlice)
+		if msg := receive(t, stream); msg.From != nil {
+			t.Fatalf("expected a message from an unknown user, got %v", msg.From)
+		}
+	}
+	if got := accounts.Lookups(); got != 2 {
+		t.Errorf("expected an unknown user to be looked up every time, got %d lookups", got)
+	}
+
+	alice := newAlice(accounts)
+	s.Deliver(fromAlice)
+	if msg := receive(t, stream); msg.From == nil || msg.From.Id != alice.Id {
+		t.Errorf("expected a message from alice once she registered, got %v", msg.From)
+	}
+}
+
+func TestChangedUserInvalidatesCache(t *testing.T) {
+	accounts := socialtest.NewAccounts()
+	alice := newAlice(accounts)
+	s, stream := start(t, accounts)
+
+	s.Deliver(fromAlice)
+	receive(t, stream)
+
+	// e.g. alice was merged into another user with /link
+	accounts.Changed(alice)
+
+	s.Deliver(fromAlice)
+	receive(t, stream)
+
+	if got := accounts.Lookups(); got != 2 {
+		t.Errorf("expected alice to be looked up again once she changed, got %d lookups", got)
+	}
+}
+
+func TestClaimPlaceholder(t *testing.T) {
+	accounts := socialtest.NewAccounts()
+
+	// bob added alice by her username, before she talked to us
+	placeholder := accounts.AddUser(&account.User{
+		Placeholder:       true,
+		PlatformUsernames: map[account.PlatformName]string{account.PlatformTelegram: "alice"},
+	})
+	s, stream := start(t, accounts)
+
+	// anyone can use alice as their name, it doesn't make them alice
+	s.PushMessage(&tgbotapi.Message{
+		From: &tgbotapi.User{ID: 1002, FirstName: "alice"},
+		Chat: &tgbotapi.Chat{ID: 1002, Type: "private"},
+		Text: "/status",
+	})
+	if msg := receive(t, stream); msg.From != nil {
+		t.Fatalf("expected a user without a username not to claim the placeholder, got %v", msg.From)
+	}
+
+	s.PushMessage(&tgbotapi.Message{
+		From: &tgbotapi.User{ID: 1001, FirstName: "Alice", UserName: "Alice"},
+		Chat: &tgbotapi.Chat{ID: 1001, Type: "private"},
+		Text: "/status",
+	})
+	msg := receive(t, stream)
+	if msg.From == nil || msg.From.Id != placeholder.Id {
+		t.Fatalf("expected alice to claim her placeholder, got %v", msg.From)
+	}
+	if msg.From.Placeholder || msg.From.PlatformIds[account.PlatformTelegram] != "1001" {
+		t.Errorf("expected the placeholder to become alice, got %v", msg.From)
+	}
+
+	// once it's claimed, it's cached like any other user
+	lookups := accounts.Lookups()
+	s.Deliver(fromAlice)
+	if msg := receive(t, stream); msg.From == nil || msg.From.Id != placeholder.Id {
+		t.Errorf("expected another message from alice, got %v", msg.From)
+	}
+	if got := accounts.Lookups(); got != lookups {
+		t.Errorf("expected alice to be cached once she claimed her placeholder, got %d more lookups", got-lookups)
+	}
+}
+
+func TestUsernameChanges(t *testing.T) {
+	accounts := socialtest.NewAccounts()
+	alice := newAlice(accounts)
+	accounts.AddUser(&account.User{
+		PlatformIds:       map[account.PlatformName]string{account.PlatformTelegram: "1002"},
+		PlatformUsernames: map[account.PlatformName]string{account.PlatformTelegram: "bob"},
+	})
+	s, stream := start(t, accounts)
+
+	// bob doesn't have a username anymore, and uses alice as his name
+	s.PushMessage(&tgbotapi.Message{
+		From: &tgbotapi.User{ID: 1002, FirstName: "alice"},
+		Chat: &tgbotapi.Chat{ID: 1002, Type: "private"},
+		Text: "/status",
+	})
+	msg := receive(t, stream)
+	if got := msg.From.PlatformUsernames[account.PlatformTelegram]; got != "bob" {
+		t.Errorf("expected a name not to be tracked as a username, got '%s'", got)
+	}
+
+	// real usernames are
+	s.PushMessage(&tgbotapi.Message{
+		From: &tgbotapi.User{ID: 1002, FirstName: "alice", UserName: "Bobby"},
+		Chat: &tgbotapi.Chat{ID: 1002, Type: "private"},
+		Text: "/status",
+	})
+	msg = receive(t, stream)
+	if got := msg.From.PlatformUsernames[account.PlatformTelegram]; got != "bobby" {
+		t.Errorf("expected bob's new username to be tracked, got '%s'", got)
+	}
+
+	s.Deliver(fromAlice)
+	if msg := receive(t, stream); msg.From.Id != alice.Id || msg.From.PlatformUsernames[account.PlatformTelegram] != "alice" {
+		t.Errorf("expected alice to keep her username, got %v", msg.From)
+	}
+}
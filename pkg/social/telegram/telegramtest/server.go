@@ -0,0 +1,444 @@
+// Package telegramtest provides a fake of the Telegram Bot API, so that the
+// Telegram provider can be tested end-to-end without network access.
+package telegramtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/jaredallard/balance/pkg/social"
+	"github.com/jaredallard/balance/pkg/social/socialtest"
+	"github.com/jaredallard/balance/pkg/social/telegram"
+)
+
+// Token is the bot token the fake server accepts
+const Token = "123456:fake-token"
+
+// file is a file that can be downloaded from the server
+type file struct {
+	id   string
+	path string
+	data []byte
+}
+
+// Server is a fake Telegram Bot API server. Updates pushed to it are returned
+// by getUpdates until they're acknowledged, and messages sent to it are
+// recorded. It implements socialtest.Platform.
+type Server struct {
+	srv    *httptest.Server
+	closed chan struct{}
+	once   sync.Once
+
+	mu sync.Mutex
+	// changed is closed, and replaced, whenever updates are pushed or the
+	// server goes up or down, to wake up requests waiting for updates
+	changed       chan struct{}
+	down          bool
+	updates       []tgbotapi.Update
+	nextUpdateID  int
+	nextMessageID int
+	files         map[string]*file
+	requests      map[string][]url.Values
+	sent          []*socialtest.Outgoing
+}
+
+// NewServer starts a fake Telegram Bot API server, it should be closed once
+// it's done with
+func NewServer() *Server {
+	s := &Server{
+		closed:        make(chan struct{}),
+		changed:       make(chan struct{}),
+		nextUpdateID:  1,
+		nextMessageID: 1,
+		files:         make(map[string]*file),
+		requests:      make(map[string][]url.Values),
+	}
+	s.srv = httptest.NewServer(s)
+
+	return s
+}
+
+// NewProvider creates a Telegram provider connected to a new fake server, both
+// are closed once the test is done
+func NewProvider(t *testing.T, a telegram.Accounts) (*telegram.Provider, *Server) {
+	s := NewServer()
+	t.Cleanup(s.Close)
+
+	p, err := telegram.NewProviderWithClient(a, Token, s.Client())
+	if err != nil {
+		t.Fatalf("failed to create telegram provider: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+
+	return p, s
+}
+
+// Factory creates a Telegram provider for the socialtest conformance suite
+func Factory(t *testing.T) (social.Provider, socialtest.Platform) {
+	return NewProvider(t, socialtest.NewAccounts())
+}
+
+// Close stops the server, releasing any requests waiting for updates
+func (s *Server) Close() {
+	s.once.Do(func() { close(s.closed) })
+	s.srv.Close()
+}
+
+// rewriteTransport sends every request to the fake server, instead of the
+// host it was for
+type rewriteTransport struct {
+	target *url.URL
+	next   http.RoundTripper
+}
+
+func (t *rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	r.Host = ""
+
+	return t.next.RoundTrip(r)
+}
+
+// Client returns a client that sends requests for the real Bot API, and
+// files, to the fake server
+func (s *Server) Client() *http.Client {
+	target, _ := url.Parse(s.srv.URL)
+	return &http.Client{
+		Transport: &rewriteTransport{target: target, next: s.srv.Client().Transport},
+	}
+}
+
+// notify wakes up every request waiting on a change, s.mu must be held
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Push queues an update to be returned by getUpdates, returning its ID
+func (s *Server) Push(u tgbotapi.Update) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u.UpdateID = s.nextUpdateID
+	s.nextUpdateID++
+	s.updates = append(s.updates, u)
+	s.notify()
+
+	return u.UpdateID
+}
+
+// PushMessage queues a message, returning its ID
+func (s *Server) PushMessage(m *tgbotapi.Message) int {
+	s.mu.Lock()
+	m.MessageID = s.nextMessageID
+	s.nextMessageID++
+	s.mu.Unlock()
+
+	if m.Date == 0 {
+		m.Date = int(time.Now().Unix())
+	}
+
+	s.Push(tgbotapi.Update{Message: m})
+	return m.MessageID
+}
+
+// AddFile adds a file that can be downloaded, returning its ID
+func (s *Server) AddFile(name string, data []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := fmt.Sprintf("file-%d", len(s.files)+1)
+	s.files[id] = &file{id: id, path: "documents/" + name, data: data}
+	return id
+}
+
+// mustAtoi parses a numeric ID, Telegram doesn't have any other kind
+func mustAtoi(id string) int {
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		panic(fmt.Sprintf("telegram IDs are numeric, got '%s'", id))
+	}
+	return i
+}
+
+// Deliver sends a message to the bot as if a user sent it, returning its ID
+func (s *Server) Deliver(in socialtest.Incoming) string {
+	m := &tgbotapi.Message{
+		From: &tgbotapi.User{ID: mustAtoi(in.UserID), FirstName: in.Username, UserName: in.Username},
+		Chat: &tgbotapi.Chat{ID: int64(mustAtoi(in.ChatID)), Type: "group"},
+		Text: in.Text,
+	}
+	if in.Private {
+		m.Chat.Type = "private"
+	}
+
+	// files are sent with the text as their caption
+	if d := in.Attachment; d != nil {
+		m.Document = &tgbotapi.Document{FileID: s.AddFile(d.Name, d.Data), FileName: d.Name, FileSize: len(d.Data)}
+		m.Caption = in.Text
+		m.Text = ""
+	}
+
+	return strconv.Itoa(s.PushMessage(m))
+}
+
+// Sent waits for the next message the bot sent
+func (s *Server) Sent(ctx context.Context) (*socialtest.Outgoing, error) {
+	for {
+		s.mu.Lock()
+		if len(s.sent) != 0 {
+			out := s.sent[0]
+			s.sent = s.sent[1:]
+			s.mu.Unlock()
+			return out, nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// SetDown makes every request fail, or stop failing
+func (s *Server) SetDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.down = down
+	s.notify()
+}
+
+// Requests returns the parameters of every request for a method, e.g. to
+// check which callback queries were answered
+func (s *Server) Requests(method string) []url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]url.Values{}, s.requests[method]...)
+}
+
+// ok responds with a successful result
+func (s *Server) ok(w http.ResponseWriter, result interface{}) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		s.fail(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: data})
+}
+
+// fail responds with an error, the same way Telegram does
+func (s *Server) fail(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{ErrorCode: code, Description: description})
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if path := strings.TrimPrefix(r.URL.Path, "/file/bot"+Token+"/"); path != r.URL.Path {
+		s.serveFile(w, r, path)
+		return
+	}
+
+	method := strings.TrimPrefix(r.URL.Path, "/bot"+Token+"/")
+	if method == r.URL.Path {
+		s.fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+		s.fail(w, http.StatusBadRequest, fmt.Sprintf("Bad Request: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	s.requests[method] = append(s.requests[method], r.Form)
+	down := s.down
+	s.mu.Unlock()
+
+	if down {
+		s.fail(w, http.StatusBadGateway, "Bad Gateway")
+		return
+	}
+
+	switch method {
+	case "getMe":
+		s.ok(w, tgbotapi.User{ID: 1, FirstName: "balance", UserName: "balancebot", IsBot: true})
+	case "getUpdates":
+		s.getUpdates(w, r)
+	case "sendMessage", "sendDocument":
+		s.send(w, r)
+	case "answerCallbackQuery":
+		s.ok(w, true)
+	case "getFile":
+		s.getFile(w, r)
+	default:
+		s.fail(w, http.StatusNotFound, "Not Found: method not found")
+	}
+}
+
+// getUpdates returns the updates after the offset, waiting up to the timeout
+// for one if there aren't any. Requesting an offset acknowledges every update
+// before it, so they aren't returned again.
+func (s *Server) getUpdates(w http.ResponseWriter, r *http.Request) {
+	offset, _ := strconv.Atoi(r.FormValue("offset"))
+	limit, _ := strconv.Atoi(r.FormValue("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 100
+	}
+	timeout, _ := strconv.Atoi(r.FormValue("timeout"))
+	deadline := time.After(time.Duration(timeout) * time.Second)
+
+	for {
+		s.mu.Lock()
+		if offset > 0 {
+			for len(s.updates) != 0 && s.updates[0].UpdateID < offset {
+				s.updates = s.updates[1:]
+			}
+		}
+
+		updates := s.updates
+		if len(updates) > limit {
+			updates = updates[:limit]
+		}
+		updates = append([]tgbotapi.Update{}, updates...)
+		down := s.down
+		changed := s.changed
+		s.mu.Unlock()
+
+		if down {
+			s.fail(w, http.StatusBadGateway, "Bad Gateway")
+			return
+		}
+
+		if len(updates) != 0 || timeout == 0 {
+			s.ok(w, updates)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			s.ok(w, updates)
+			return
+		case <-s.closed:
+			s.ok(w, updates)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// send records a message, or document, sent by the bot
+func (s *Server) send(w http.ResponseWriter, r *http.Request) {
+	chatID, err := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, "Bad Request: chat not found")
+		return
+	}
+
+	out := &socialtest.Outgoing{
+		ChatID:  r.FormValue("chat_id"),
+		ReplyTo: r.FormValue("reply_to_message_id"),
+		Text:    r.FormValue("text"),
+	}
+
+	if markup := r.FormValue("reply_markup"); markup != "" {
+		var k tgbotapi.InlineKeyboardMarkup
+		if err := json.Unmarshal([]byte(markup), &k); err != nil {
+			s.fail(w, http.StatusBadRequest, "Bad Request: can't parse reply keyboard markup JSON object")
+			return
+		}
+
+		for _, row := range k.InlineKeyboard {
+			for _, b := range row {
+				if b.CallbackData == nil {
+					continue
+				}
+				out.Buttons = append(out.Buttons, social.Button{Text: b.Text, Command: *b.CallbackData})
+			}
+		}
+	}
+
+	if r.MultipartForm != nil {
+		out.Text = r.FormValue("caption")
+		for _, fh := range r.MultipartForm.File["document"] {
+			f, err := fh.Open()
+			if err != nil {
+				s.fail(w, http.StatusBadRequest, fmt.Sprintf("Bad Request: %v", err))
+				return
+			}
+			data, err := ioutil.ReadAll(f)
+			f.Close()
+			if err != nil {
+				s.fail(w, http.StatusBadRequest, fmt.Sprintf("Bad Request: %v", err))
+				return
+			}
+
+			out.Attachments = append(out.Attachments, &social.Document{Name: fh.Filename, Data: data})
+		}
+	}
+
+	s.mu.Lock()
+	m := tgbotapi.Message{
+		MessageID: s.nextMessageID,
+		From:      &tgbotapi.User{ID: 1, FirstName: "balance", UserName: "balancebot", IsBot: true},
+		Chat:      &tgbotapi.Chat{ID: chatID},
+		Date:      int(time.Now().Unix()),
+		Text:      out.Text,
+	}
+	s.nextMessageID++
+	s.sent = append(s.sent, out)
+	s.notify()
+	s.mu.Unlock()
+
+	s.ok(w, m)
+}
+
+// getFile returns the path a file can be downloaded from
+func (s *Server) getFile(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	f, ok := s.files[r.FormValue("file_id")]
+	s.mu.Unlock()
+
+	if !ok {
+		s.fail(w, http.StatusBadRequest, "Bad Request: invalid file_id")
+		return
+	}
+
+	s.ok(w, tgbotapi.File{FileID: f.id, FileSize: len(f.data), FilePath: f.path})
+}
+
+// serveFile serves the contents of a file
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, f := range s.files {
+		if f.path == path {
+			w.Write(f.data)
+			return
+		}
+	}
+
+	http.NotFound(w, r)
+}
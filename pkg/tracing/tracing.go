@@ -0,0 +1,124 @@
+// Package tracing configures OpenTelemetry tracing
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/go-pg/pg/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// name is the name of the tracer used throughout balance
+const name = "github.com/jaredallard/balance"
+
+// Tracer returns the tracer used throughout balance
+func Tracer() trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// Exporter is where traces are sent
+type Exporter string
+
+const (
+	// ExporterNone doesn't export traces
+	ExporterNone Exporter = "none"
+
+	// ExporterOTLP exports traces over OTLP/HTTP, to OTEL_EXPORTER_OTLP_ENDPOINT
+	ExporterOTLP Exporter = "otlp"
+
+	// ExporterStdout writes traces to stdout, for local use
+	ExporterStdout Exporter = "stdout"
+)
+
+// Setup configures the global tracer provider to export to e, the returned
+// function flushes any remaining spans and should be called before exiting
+func Setup(ctx context.Context, e Exporter) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch e {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown exporter '%s', expected none, otlp or stdout", e)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("balance"),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return tp.Shutdown, nil
+}
+
+// spanKey is the key of a query's span in a go-pg query event's stash
+type spanKey struct{}
+
+// QueryHook is a go-pg query hook that creates a span for every query
+type QueryHook struct{}
+
+// BeforeQuery implements pg.QueryHook
+func (QueryHook) BeforeQuery(ctx context.Context, ev *pg.QueryEvent) (context.Context, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ctx, span := Tracer().Start(ctx, "db.query", trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(semconv.DBSystemPostgreSQL)
+	if q, err := ev.UnformattedQuery(); err == nil {
+		span.SetAttributes(attribute.String("db.statement", q))
+	}
+
+	if ev.Stash == nil {
+		ev.Stash = make(map[interface{}]interface{})
+	}
+	ev.Stash[spanKey{}] = span
+
+	return ctx, nil
+}
+
+// AfterQuery implements pg.QueryHook
+func (QueryHook) AfterQuery(ctx context.Context, ev *pg.QueryEvent) error {
+	span, ok := ev.Stash[spanKey{}].(trace.Span)
+	if !ok {
+		return nil
+	}
+	defer span.End()
+
+	if ev.Err != nil && ev.Err != pg.ErrNoRows {
+		span.RecordError(ev.Err)
+		span.SetStatus(codes.Error, ev.Err.Error())
+	}
+	if ev.Result != nil {
+		span.SetAttributes(attribute.Int("db.rows_affected", ev.Result.RowsAffected()))
+	}
+
+	return nil
+}
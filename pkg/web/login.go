@@ -0,0 +1,112 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/social"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleLogin shows the login form, and sends a magic link to the user that
+// submits it
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.render(w, r, "login.html", nil)
+		return
+	}
+
+	username := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(r.FormValue("username")), "@"))
+	if username == "" {
+		s.render(w, r, "login.html", "Please enter your username")
+		return
+	}
+
+	// always show the same page, so this can't be used to find out who's registered
+	defer s.render(w, r, "login_sent.html", nil)
+
+	u, _, err := s.a.LookupUsername(r.Context(), s.Platform, username)
+	if err != nil {
+		if err != account.ErrUserNotFound {
+			log.Errorf("failed to lookup user for login: %v", err)
+		}
+		return
+	}
+
+	if u.Placeholder {
+		return
+	}
+
+	token, err := s.a.CreateSession(r.Context(), u, account.SessionLogin, loginTTL)
+	if err != nil {
+		log.Errorf("failed to create login session: %v", err)
+		return
+	}
+
+	link := fmt.Sprintf("%s/login/callback?token=%s", s.BaseURL, url.QueryEscape(token))
+	err = s.s.Send(&social.Message{
+		ChatID:       u.PlatformIds[s.Platform],
+		PlatformName: s.Platform,
+	}, social.NewReply(
+		social.Textf("Someone asked to log in to your dashboard, if it was you, open this link within %v:", loginTTL),
+	).Add(social.Text(link)).Add(social.Text("If it wasn't you, you can ignore this message.")))
+	if err != nil {
+		log.Errorf("failed to send login link to user %s: %v", u.Id, err)
+	}
+}
+
+// handleLoginCallback logs in a user from a magic link. Opening the link only
+// asks to confirm logging in, since link previews and scanners open links
+// too, and would use up the token before the user could.
+func (s *Server) handleLoginCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.render(w, r, "login_confirm.html", r.URL.Query().Get("token"))
+		return
+	}
+
+	u, err := s.a.RedeemLoginSession(r.Context(), r.FormValue("token"))
+	if err == account.ErrSessionNotFound || err == account.ErrUserNotFound {
+		s.render(w, r, "login.html", "That login link is invalid or has expired, please request a new one")
+		return
+	} else if err != nil {
+		s.error(w, http.StatusInternalServerError, "failed to log in", err)
+		return
+	}
+
+	token, err := s.a.CreateSession(r.Context(), u, account.SessionWeb, sessionTTL)
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, "failed to log in", err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(sessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleLogout logs out the current session
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.error(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	if c, err := r.Cookie(cookieName); err == nil {
+		if err := s.a.DeleteSession(r.Context(), c.Value); err != nil {
+			log.Warnf("failed to delete session: %v", err)
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: cookieName, Value: "", Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
@@ -0,0 +1,250 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+)
+
+// pageSize is how many transactions are shown per page
+const pageSize = 50
+
+// counterparty is the balance between the logged in user and another user
+type counterparty struct {
+	Id uuid.UUID
+
+	// Balance is how much this user owes the logged in user, negative if the
+	// logged in user owes them
+	Balance float64
+
+	UpdatedAt time.Time
+}
+
+// row is a transaction in a table of transactions
+type row struct {
+	*account.Transaction
+
+	// Leg is the state of the logged in user's leg of the transaction, if
+	// they're a subject of it
+	Leg account.TransactionState
+
+	Subjects []uuid.UUID
+}
+
+// member is a user's net change within a group
+type member struct {
+	Id  uuid.UUID
+	Net float64
+}
+
+// counterparties returns the balances the logged in user has with other users
+func (s *Server) counterparties(ctx context.Context, u *account.User) ([]counterparty, error) {
+	accts, err := s.a.FindAccounts(ctx, u)
+	if err != nil && err != account.ErrAccountNotFound {
+		return nil, err
+	}
+
+	cps := make([]counterparty, 0, len(accts))
+	for _, a := range accts {
+		// account balances are what the subject owes the creator
+		cp := counterparty{Id: a.SubjectId, Balance: a.Balance, UpdatedAt: a.UpdatedAt}
+		if a.SubjectId == u.Id {
+			cp = counterparty{Id: a.CreatorId, Balance: -a.Balance, UpdatedAt: a.UpdatedAt}
+		}
+		cps = append(cps, cp)
+	}
+
+	sort.Slice(cps, func(i, j int) bool {
+		return cps[i].UpdatedAt.After(cps[j].UpdatedAt)
+	})
+
+	return cps, nil
+}
+
+// rows returns the table rows of trans from the perspective of u
+func rows(u *account.User, trans []*account.Transaction) []row {
+	rs := make([]row, 0, len(trans))
+	for _, t := range trans {
+		r := row{Transaction: t}
+		if _, ok := t.Accounts[u.Id]; ok {
+			r.Leg = t.State(u.Id)
+		}
+		for uid := range t.Accounts {
+			r.Subjects = append(r.Subjects, uid)
+		}
+		rs = append(rs, r)
+	}
+
+	return rs
+}
+
+// handleIndex shows the logged in user's balance with each user
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		s.error(w, http.StatusNotFound, "not found", nil)
+		return
+	}
+
+	cps, err := s.counterparties(r.Context(), userFrom(r))
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, "failed to list balances", err)
+		return
+	}
+
+	s.render(w, r, "index.html", cps)
+}
+
+// handleTransactions shows a filterable table of the logged in user's transactions
+func (s *Server) handleTransactions(w http.ResponseWriter, r *http.Request) {
+	u := userFrom(r)
+	q := r.URL.Query()
+	f := &account.TransactionFilter{Limit: pageSize}
+
+	if id, err := uuid.FromString(q.Get("with")); err == nil {
+		f.With = &id
+	}
+	if since, err := time.Parse("2006-01-02", q.Get("since")); err == nil {
+		f.Since = since
+	}
+	if until, err := time.Parse("2006-01-02", q.Get("until")); err == nil {
+		// include the whole day
+		f.Until = until.Add(24 * time.Hour)
+	}
+	f.State = account.TransactionState(q.Get("state"))
+
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	f.Offset = (page - 1) * pageSize
+
+	trans, total, err := s.a.ListTransactions(r.Context(), u, f)
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, "failed to list transactions", err)
+		return
+	}
+
+	cps, err := s.counterparties(r.Context(), u)
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, "failed to list balances", err)
+		return
+	}
+
+	// pageURL returns the URL of another page with the same filters
+	pageURL := func(page int) string {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(page))
+		return "/transactions?" + q.Encode()
+	}
+
+	data := struct {
+		Rows           []row
+		Counterparties []counterparty
+		States         []account.TransactionState
+		Query          url.Values
+		Total          int
+		Page           int
+		PrevURL        string
+		NextURL        string
+	}{
+		Rows:           rows(u, trans),
+		Counterparties: cps,
+		States:         []account.TransactionState{account.TransactionPending, account.TransactionAccepted, account.TransactionDisputed, account.TransactionVoided},
+		Query:          q,
+		Total:          total,
+		Page:           page,
+	}
+	if page > 1 {
+		data.PrevURL = pageURL(page - 1)
+	}
+	if f.Offset+len(trans) < total {
+		data.NextURL = pageURL(page + 1)
+	}
+
+	s.render(w, r, "transactions.html", data)
+}
+
+// handleGroups lists the chats the logged in user has transactions in
+func (s *Server) handleGroups(w http.ResponseWriter, r *http.Request) {
+	chats, err := s.a.ListChats(r.Context(), userFrom(r))
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, "failed to list groups", err)
+		return
+	}
+
+	s.render(w, r, "groups.html", chats)
+}
+
+// handleGroup shows an overview of a chat, /groups/PLATFORM/CHATID
+func (s *Server) handleGroup(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/groups/"), "/")
+	if len(parts) != 2 {
+		s.error(w, http.StatusNotFound, "not found", nil)
+		return
+	}
+	p, chatID := account.PlatformName(parts[0]), parts[1]
+
+	u := userFrom(r)
+	chats, err := s.a.ListChats(r.Context(), u)
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, "failed to list groups", err)
+		return
+	}
+
+	// only show groups the user has taken part in
+	var chat *account.Chat
+	for i := range chats {
+		if chats[i].PlatformName == p && chats[i].ChatID == chatID {
+			chat = &chats[i]
+			break
+		}
+	}
+	if chat == nil {
+		s.error(w, http.StatusNotFound, "not found", nil)
+		return
+	}
+
+	trans, err := s.a.GetTransactionsInChat(r.Context(), p, chatID, time.Time{})
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, "failed to list transactions", err)
+		return
+	}
+
+	// net is how much each user is owed from the accepted legs in this group
+	net := make(map[uuid.UUID]float64)
+	for _, t := range trans {
+		for uid := range t.Accounts {
+			if t.State(uid) != account.TransactionAccepted {
+				continue
+			}
+			net[t.CreatedBy] += t.Share()
+			net[uid] -= t.Share()
+		}
+	}
+
+	members := make([]member, 0, len(net))
+	for id, amount := range net {
+		members = append(members, member{Id: id, Net: amount})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Net > members[j].Net
+	})
+
+	// show the newest transactions first
+	for i, j := 0, len(trans)-1; i < j; i, j = i+1, j-1 {
+		trans[i], trans[j] = trans[j], trans[i]
+	}
+
+	s.render(w, r, "group.html", struct {
+		Chat    *account.Chat
+		Members []member
+		Rows    []row
+	}{chat, members, rows(u, trans)})
+}
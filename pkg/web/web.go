@@ -0,0 +1,194 @@
+// Package web implements a read-only web dashboard
+package web
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/social"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// cookieName is the name of the cookie that holds a web session
+	cookieName = "balance_session"
+
+	// sessionTTL is how long a browser stays logged in for
+	sessionTTL = 30 * 24 * time.Hour
+
+	// loginTTL is how long a magic link can be used for
+	loginTTL = 15 * time.Minute
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+type contextKey int
+
+// userKey is the context key of the logged in user
+const userKey contextKey = iota
+
+// Store is the part of the account client the dashboard is served from
+type Store interface {
+	LookupUsername(ctx context.Context, p account.PlatformName, username string) (*account.User, bool, error)
+	GetUser(ctx context.Context, id uuid.UUID) (*account.User, error)
+	CreateSession(ctx context.Context, u *account.User, kind account.SessionKind, ttl time.Duration) (string, error)
+	RedeemLoginSession(ctx context.Context, token string) (*account.User, error)
+	FindUserBySession(ctx context.Context, token string) (*account.User, error)
+	DeleteSession(ctx context.Context, token string) error
+	FindAccounts(ctx context.Context, u *account.User) ([]*account.Account, error)
+	ListTransactions(ctx context.Context, u *account.User, f *account.TransactionFilter) ([]*account.Transaction, int, error)
+	ListChats(ctx context.Context, u *account.User) ([]account.Chat, error)
+	GetTransactionsInChat(ctx context.Context, p account.PlatformName, chatID string, since time.Time) ([]*account.Transaction, error)
+}
+
+// Server serves the dashboard
+type Server struct {
+	a   Store
+	s   social.Sender
+	mux *http.ServeMux
+
+	// pages are the parsed templates of each page, keyed by their file name
+	pages map[string]*template.Template
+
+	// BaseURL is the URL the dashboard is reachable at, used in magic links
+	BaseURL string
+
+	// Platform is the platform users log in with, and whose usernames are shown
+	Platform account.PlatformName
+}
+
+// NewServer creates a new dashboard server, magic links are sent with s
+func NewServer(a Store, s social.Sender, baseURL string) (*Server, error) {
+	srv := &Server{
+		a:        a,
+		s:        s,
+		mux:      http.NewServeMux(),
+		pages:    make(map[string]*template.Template),
+		BaseURL:  strings.TrimSuffix(baseURL, "/"),
+		Platform: account.PlatformTelegram,
+	}
+
+	funcs := template.FuncMap{
+		// name is replaced in render, so it can look users up with the request's context
+		"name":  func(uuid.UUID) string { return "" },
+		"money": money,
+		"date": func(t time.Time) string {
+			return t.UTC().Format("2006-01-02 15:04")
+		},
+	}
+
+	for _, page := range []string{"login.html", "login_sent.html", "login_confirm.html", "index.html", "transactions.html", "groups.html", "group.html"} {
+		tmpl, err := template.New(page).Funcs(funcs).ParseFS(templateFS, "templates/layout.html", "templates/"+page)
+		if err != nil {
+			return nil, err
+		}
+		srv.pages[page] = tmpl
+	}
+
+	srv.mux.HandleFunc("/login", srv.handleLogin)
+	srv.mux.HandleFunc("/login/callback", srv.handleLoginCallback)
+	srv.mux.HandleFunc("/logout", srv.handleLogout)
+	srv.mux.HandleFunc("/transactions", srv.loggedIn(srv.handleTransactions))
+	srv.mux.HandleFunc("/groups", srv.loggedIn(srv.handleGroups))
+	srv.mux.HandleFunc("/groups/", srv.loggedIn(srv.handleGroup))
+	srv.mux.HandleFunc("/", srv.loggedIn(srv.handleIndex))
+
+	return srv, nil
+}
+
+// ServeHTTP serves the dashboard
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// name returns the display name of a user
+func (s *Server) name(ctx context.Context, id uuid.UUID) string {
+	u, err := s.a.GetUser(ctx, id)
+	if err != nil {
+		log.Warnf("failed to find user %s for dashboard: %v", id, err)
+		return "unknown"
+	}
+
+	if username := u.PlatformUsernames[s.Platform]; username != "" {
+		return username
+	}
+	return id.String()
+}
+
+// money formats an amount of money
+func money(amount float64) string {
+	// TODO: don't depend on USD
+	if amount < 0 {
+		return fmt.Sprintf("-$%.2f", -amount)
+	}
+	return fmt.Sprintf("$%.2f", amount)
+}
+
+// userFrom returns the logged in user of a request
+func userFrom(r *http.Request) *account.User {
+	u, _ := r.Context().Value(userKey).(*account.User)
+	return u
+}
+
+// loggedIn is middleware that redirects requests without a session to /login
+func (s *Server) loggedIn(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(cookieName)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		u, err := s.a.FindUserBySession(r.Context(), c.Value)
+		if err == account.ErrSessionNotFound || err == account.ErrUserNotFound {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		} else if err != nil {
+			s.error(w, http.StatusInternalServerError, "failed to find session", err)
+			return
+		}
+
+		fn(w, r.WithContext(context.WithValue(r.Context(), userKey, u)))
+	}
+}
+
+// render renders a page, data is available to templates as .Data
+func (s *Server) render(w http.ResponseWriter, r *http.Request, page string, data interface{}) {
+	// pages are cloned so they're never executed, which would stop them from
+	// being cloned again
+	tmpl, err := s.pages[page].Clone()
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, "failed to render page", err)
+		return
+	}
+	tmpl.Funcs(template.FuncMap{
+		"name": func(id uuid.UUID) string {
+			return s.name(r.Context(), id)
+		},
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = tmpl.ExecuteTemplate(w, "layout", struct {
+		User *account.User
+		Data interface{}
+	}{userFrom(r), data})
+	if err != nil {
+		log.Errorf("failed to render %s: %v", page, err)
+	}
+}
+
+// error writes an error page, internal errors are logged rather than shown
+func (s *Server) error(w http.ResponseWriter, status int, msg string, err error) {
+	if err != nil {
+		log.Errorf("web: %s: %v", msg, err)
+	}
+	http.Error(w, msg, status)
+}
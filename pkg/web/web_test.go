@@ -0,0 +1,397 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jaredallard/balance/pkg/account"
+	"github.com/jaredallard/balance/pkg/social"
+)
+
+// session is a session in a store
+type session struct {
+	user    uuid.UUID
+	kind    account.SessionKind
+	expires time.Time
+}
+
+// store is an in-process Store, sessions follow the same rules as account.Client
+type store struct {
+	mu       sync.Mutex
+	users    map[uuid.UUID]*account.User
+	sessions map[string]*session
+	next     int
+}
+
+func newStore() *store {
+	return &store{
+		users:    make(map[uuid.UUID]*account.User),
+		sessions: make(map[string]*session),
+	}
+}
+
+// addUser adds a user on telegram
+func (s *store) addUser(name string, placeholder bool) *account.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := &account.User{
+		Id:                uuid.Must(uuid.NewV4()),
+		PlatformIds:       map[account.PlatformName]string{account.PlatformTelegram: name + "-chat"},
+		PlatformUsernames: map[account.PlatformName]string{account.PlatformTelegram: name},
+		Placeholder:       placeholder,
+	}
+	s.users[u.Id] = u
+	return u
+}
+
+func (s *store) LookupUsername(ctx context.Context, p account.PlatformName, username string) (*account.User, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.PlatformUsernames[p] == username {
+			return u, false, nil
+		}
+	}
+	return nil, false, account.ErrUserNotFound
+}
+
+func (s *store) GetUser(ctx context.Context, id uuid.UUID) (*account.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return nil, account.ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (s *store) CreateSession(ctx context.Context, u *account.User, kind account.SessionKind, ttl time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.next++
+	token := fmt.Sprintf("%s-token-%d", kind, s.next)
+	s.sessions[token] = &session{user: u.Id, kind: kind, expires: time.Now().Add(ttl)}
+	return token, nil
+}
+
+// find returns the user of an unexpired session of kind, s.mu must be held
+func (s *store) find(token string, kind account.SessionKind) (*account.User, error) {
+	ses, ok := s.sessions[token]
+	if !ok || ses.kind != kind || time.Now().After(ses.expires) {
+		return nil, account.ErrSessionNotFound
+	}
+
+	u, ok := s.users[ses.user]
+	if !ok {
+		return nil, account.ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (s *store) RedeemLoginSession(ctx context.Context, token string) (*account.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.find(token, account.SessionLogin)
+	if err == nil {
+		delete(s.sessions, token)
+	}
+	return u, err
+}
+
+func (s *store) FindUserBySession(ctx context.Context, token string) (*account.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.find(token, account.SessionWeb)
+}
+
+func (s *store) DeleteSession(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+	return nil
+}
+
+func (s *store) FindAccounts(ctx context.Context, u *account.User) ([]*account.Account, error) {
+	return nil, account.ErrAccountNotFound
+}
+
+func (s *store) ListTransactions(ctx context.Context, u *account.User, f *account.TransactionFilter) ([]*account.Transaction, int, error) {
+	return nil, 0, nil
+}
+
+func (s *store) ListChats(ctx context.Context, u *account.User) ([]account.Chat, error) {
+	return nil, nil
+}
+
+func (s *store) GetTransactionsInChat(ctx context.Context, p account.PlatformName, chatID string, since time.Time) ([]*account.Transaction, error) {
+	return nil, nil
+}
+
+// sender records the messages sent to each chat
+type sender struct {
+	mu   sync.Mutex
+	sent map[string][]string
+}
+
+func (s *sender) Send(to *social.Message, r *social.Reply) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent[to.ChatID] = append(s.sent[to.ChatID], r.String())
+	return nil
+}
+
+// messages returns the messages sent to a chat
+func (s *sender) messages(chatID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.sent[chatID]...)
+}
+
+// testServer serves a dashboard where alice is registered, and bob is a
+// placeholder
+type testServer struct {
+	*httptest.Server
+	store  *store
+	sender *sender
+	alice  *account.User
+	bob    *account.User
+}
+
+func newTestServer(t *testing.T, baseURL string) *testServer {
+	t.Helper()
+
+	ts := &testServer{store: newStore(), sender: &sender{sent: make(map[string][]string)}}
+	ts.alice = ts.store.addUser("alice", false)
+	ts.bob = ts.store.addUser("bob", true)
+
+	srv, err := NewServer(ts.store, ts.sender, baseURL)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	ts.Server = httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+// client returns a client that doesn't follow redirects
+func (ts *testServer) client() *http.Client {
+	return &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// do sends a request with a session cookie, if it isn't empty, returning the
+// response and its body
+func (ts *testServer) do(t *testing.T, method, path string, form url.Values, cookie string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: cookie})
+	}
+
+	resp, err := ts.client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to %s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	return resp, string(b)
+}
+
+var linkPattern = regexp.MustCompile(`/login/callback\?token=(\S+)`)
+
+// login asks for a magic link for u, returning the token in the link
+// that was sent, or an empty string if none was
+func (ts *testServer) login(t *testing.T, u *account.User) string {
+	t.Helper()
+
+	chat := u.PlatformIds[account.PlatformTelegram]
+	before := len(ts.sender.messages(chat))
+
+	resp, body := ts.do(t, http.MethodPost, "/login", url.Values{"username": {"@" + strings.ToUpper(u.PlatformUsernames[account.PlatformTelegram])}}, "")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "Check your messages") {
+		t.Fatalf("expected the login sent page, got %d: %s", resp.StatusCode, body)
+	}
+
+	sent := ts.sender.messages(chat)
+	if len(sent) == before {
+		return ""
+	}
+
+	m := linkPattern.FindStringSubmatch(sent[len(sent)-1])
+	if m == nil {
+		t.Fatalf("expected a login link, got '%s'", sent[len(sent)-1])
+	}
+	token, err := url.QueryUnescape(m[1])
+	if err != nil {
+		t.Fatalf("failed to unescape token: %v", err)
+	}
+	return token
+}
+
+// sessionCookie returns the session cookie a response set
+func sessionCookie(resp *http.Response) *http.Cookie {
+	for _, c := range resp.Cookies() {
+		if c.Name == cookieName {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestLogin(t *testing.T) {
+	ts := newTestServer(t, "http://localhost")
+
+	if resp, body := ts.do(t, http.MethodGet, "/login", nil, ""); resp.StatusCode != http.StatusOK || !strings.Contains(body, `action="/login"`) {
+		t.Errorf("expected the login form, got %d: %s", resp.StatusCode, body)
+	}
+
+	if resp, body := ts.do(t, http.MethodPost, "/login", url.Values{"username": {" "}}, ""); !strings.Contains(body, "Please enter your username") {
+		t.Errorf("expected to be asked for a username, got %d: %s", resp.StatusCode, body)
+	}
+
+	if token := ts.login(t, ts.alice); token == "" {
+		t.Errorf("expected alice to be sent a login link")
+	}
+
+	// placeholders haven't talked to us, so they can't be sent a link
+	if token := ts.login(t, ts.bob); token != "" {
+		t.Errorf("expected a placeholder not to be sent a login link")
+	}
+
+	// unknown users see the same page, so it can't be used to find out who's registered
+	resp, body := ts.do(t, http.MethodPost, "/login", url.Values{"username": {"carol"}}, "")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "Check your messages") {
+		t.Errorf("expected the login sent page for an unknown user, got %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestLoginCallback(t *testing.T) {
+	ts := newTestServer(t, "http://localhost")
+	token := ts.login(t, ts.alice)
+
+	// link previews open the link before the user does, which only shows the
+	// confirmation page
+	for i := 0; i < 2; i++ {
+		resp, body := ts.do(t, http.MethodGet, "/login/callback?token="+url.QueryEscape(token), nil, "")
+		if resp.StatusCode != http.StatusOK || !strings.Contains(body, `method="post" action="/login/callback"`) {
+			t.Fatalf("expected the confirmation page, got %d: %s", resp.StatusCode, body)
+		}
+		if !strings.Contains(body, `value="`+token+`"`) {
+			t.Errorf("expected the confirmation page to submit the token, got %s", body)
+		}
+		if c := sessionCookie(resp); c != nil {
+			t.Errorf("expected opening the link not to log in, got cookie %v", c)
+		}
+	}
+
+	resp, body := ts.do(t, http.MethodPost, "/login/callback", url.Values{"token": {token}}, "")
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/" {
+		t.Fatalf("expected a redirect to the dashboard, got %d: %s", resp.StatusCode, body)
+	}
+
+	c := sessionCookie(resp)
+	if c == nil || c.Value == "" {
+		t.Fatalf("expected a session cookie, got %v", resp.Cookies())
+	}
+	if !c.HttpOnly || c.SameSite != http.SameSiteLaxMode || c.Secure || c.Path != "/" {
+		t.Errorf("expected an HttpOnly, SameSite=Lax cookie for /, got %v", c)
+	}
+	if c.MaxAge != int(sessionTTL.Seconds()) {
+		t.Errorf("expected the cookie to last %v, got %ds", sessionTTL, c.MaxAge)
+	}
+
+	// login links can only be used once
+	_, body = ts.do(t, http.MethodPost, "/login/callback", url.Values{"token": {token}}, "")
+	if !strings.Contains(body, "invalid or has expired") {
+		t.Errorf("expected a used login link to be rejected, got %s", body)
+	}
+
+	// web sessions can't be used as login links
+	_, body = ts.do(t, http.MethodPost, "/login/callback", url.Values{"token": {c.Value}}, "")
+	if !strings.Contains(body, "invalid or has expired") {
+		t.Errorf("expected a web session to be rejected as a login link, got %s", body)
+	}
+}
+
+func TestSecureCookie(t *testing.T) {
+	ts := newTestServer(t, "https://balance.example.com/")
+	token := ts.login(t, ts.alice)
+
+	resp, _ := ts.do(t, http.MethodPost, "/login/callback", url.Values{"token": {token}}, "")
+	if c := sessionCookie(resp); c == nil || !c.Secure {
+		t.Errorf("expected a secure cookie when served over https, got %v", c)
+	}
+}
+
+func TestSession(t *testing.T) {
+	ts := newTestServer(t, "http://localhost")
+
+	for _, cookie := range []string{"", "not-a-session"} {
+		resp, _ := ts.do(t, http.MethodGet, "/", nil, cookie)
+		if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/login" {
+			t.Errorf("expected a redirect to /login with cookie '%s', got %d", cookie, resp.StatusCode)
+		}
+	}
+
+	// login links aren't sessions
+	login := ts.login(t, ts.alice)
+	if resp, _ := ts.do(t, http.MethodGet, "/", nil, login); resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("expected a login link not to be accepted as a session, got %d", resp.StatusCode)
+	}
+
+	resp, _ := ts.do(t, http.MethodPost, "/login/callback", url.Values{"token": {login}}, "")
+	cookie := sessionCookie(resp).Value
+
+	for _, path := range []string{"/", "/transactions", "/groups"} {
+		resp, body := ts.do(t, http.MethodGet, path, nil, cookie)
+		if resp.StatusCode != http.StatusOK || !strings.Contains(body, `action="/logout"`) {
+			t.Errorf("expected %s to be shown to a logged in user, got %d: %s", path, resp.StatusCode, body)
+		}
+	}
+
+	if resp, _ := ts.do(t, http.MethodGet, "/logout", nil, cookie); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected logging out to require a POST, got %d", resp.StatusCode)
+	}
+
+	resp, _ = ts.do(t, http.MethodPost, "/logout", nil, cookie)
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/login" {
+		t.Errorf("expected a redirect to /login after logging out, got %d", resp.StatusCode)
+	}
+	if c := sessionCookie(resp); c == nil || c.MaxAge >= 0 {
+		t.Errorf("expected the session cookie to be cleared, got %v", c)
+	}
+
+	if resp, _ := ts.do(t, http.MethodGet, "/", nil, cookie); resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("expected a session to be unusable after logging out, got %d", resp.StatusCode)
+	}
+}